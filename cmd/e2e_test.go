@@ -1,41 +1,53 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"log"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-resty/resty/v2"
+	"github.com/zaher1307/IDEANEST-project-assignment/internal/auth"
+	"github.com/zaher1307/IDEANEST-project-assignment/internal/blob"
 	"github.com/zaher1307/IDEANEST-project-assignment/internal/business"
+	"github.com/zaher1307/IDEANEST-project-assignment/internal/database"
+	"github.com/zaher1307/IDEANEST-project-assignment/internal/mailer"
 	"github.com/zaher1307/IDEANEST-project-assignment/internal/types"
 )
 
 var (
-	r   *gin.Engine
-	c   *resty.Client
-	url string
+	r      *gin.Engine
+	c      *resty.Client
+	url    string
+	outbox mailer.Outbox
 )
 
 func init() {
+	store := database.NewMemory()
+	business.SetRepositories(store, store)
+	auth.SetTokenStore(auth.NewMemoryTokenStore())
+	business.SetMailSender(&outbox)
+
+	dir, err := os.MkdirTemp("", "ideanest-blobs-")
+	if err != nil {
+		log.Fatal(err)
+	}
+	blobStore, err := blob.NewLocalStore(dir, "/blobs")
+	if err != nil {
+		log.Fatal(err)
+	}
+	business.SetBlobStore(blobStore)
+
 	c = resty.New()
 	r = gin.Default()
 	url = httptest.NewServer(r).URL
 
-	r.POST("/signup", SignUpHandler)
-	r.POST("/signin", SignInHandler)
-	r.POST("/refresh-token", RefreshTokenHandler)
-
-	r.Use(AuthMiddleware())
-
-	r.POST("/organization", CreateOrgHandler)
-	r.GET("/organization/:organization_id", ReadOrgHandler)
-	r.GET("/organization", ReadAllOrgsHandler)
-	r.PUT("/organization/:organization_id", UpdateOrgHandler)
-	r.DELETE("/organization/:organization_id", DeleteOrgHandler)
-	r.POST("/organization/:organization_id/invite", InviteUserToOrgHandler)
-	r.POST("/revoke-refresh-token", RevokeRefreshTokenHandler)
+	setupRoutes(r)
 }
 
 func TestHandlers(t *testing.T) {
@@ -72,7 +84,7 @@ func TestHandlers(t *testing.T) {
 		}
 
 		// good request with status 200
-		business.SignUp(types.User{
+		business.SignUp(context.Background(), types.User{
 			UserInfo: types.UserInfo{
 				Name:  "ahmed",
 				Email: "zaher@a.b",
@@ -90,14 +102,14 @@ func TestHandlers(t *testing.T) {
 	})
 
 	t.Run("RefreshTokenHandler", func(t *testing.T) {
-		business.SignUp(types.User{
+		business.SignUp(context.Background(), types.User{
 			UserInfo: types.UserInfo{
 				Name:  "ahmed",
 				Email: "zaher@a.b",
 			},
 			Password: "123",
 		})
-		tokens, _ := business.SignIn(types.User{
+		tokens, _ := business.SignIn(context.Background(), types.User{
 			UserInfo: types.UserInfo{
 				Email: "zaher@a.b",
 			},
@@ -189,6 +201,64 @@ func TestHandlers(t *testing.T) {
 		}
 	})
 
+	t.Run("CreateInviteLinkHandler", func(t *testing.T) {
+		resp, _ := c.R().
+			SetBody(`{"access_level":"user", "max_uses":10, "expires_at":"2030-01-01T00:00:00Z"}`).
+			Post(url + "/organization/1234/invite-links")
+
+		faildMessage := `{"message":"Unauthorized"}`
+
+		if string(resp.Body()) != faildMessage {
+			t.Errorf("Expected faild message %s but got %s", faildMessage, string(resp.Body()))
+		}
+	})
+
+	t.Run("JoinOrgHandler", func(t *testing.T) {
+		resp, _ := c.R().
+			Post(url + "/join/1234")
+
+		faildMessage := `{"message":"Unauthorized"}`
+
+		if string(resp.Body()) != faildMessage {
+			t.Errorf("Expected faild message %s but got %s", faildMessage, string(resp.Body()))
+		}
+	})
+
+	t.Run("CreateTeamHandler", func(t *testing.T) {
+		resp, _ := c.R().
+			SetBody(`{"name":"team name", "description":"team description"}`).
+			Post(url + "/organization/1234/teams")
+
+		faildMessage := `{"message":"Unauthorized"}`
+
+		if string(resp.Body()) != faildMessage {
+			t.Errorf("Expected faild message %s but got %s", faildMessage, string(resp.Body()))
+		}
+	})
+
+	t.Run("CreateProjectHandler", func(t *testing.T) {
+		resp, _ := c.R().
+			SetBody(`{"name":"project name", "description":"project description"}`).
+			Post(url + "/organization/1234/projects")
+
+		faildMessage := `{"message":"Unauthorized"}`
+
+		if string(resp.Body()) != faildMessage {
+			t.Errorf("Expected faild message %s but got %s", faildMessage, string(resp.Body()))
+		}
+	})
+
+	t.Run("DiscoverOrgsHandler", func(t *testing.T) {
+		resp, _ := c.R().
+			Get(url + "/organizations/discover?q=org")
+
+		faildMessage := `{"message":"Unauthorized"}`
+
+		if string(resp.Body()) != faildMessage {
+			t.Errorf("Expected faild message %s but got %s", faildMessage, string(resp.Body()))
+		}
+	})
+
 	t.Run("RevokeRefreshTokenHandler", func(t *testing.T) {
 		resp, _ := c.R().
 			SetBody(`{"refresh_token":"refresh_token"`).
@@ -201,3 +271,197 @@ func TestHandlers(t *testing.T) {
 		}
 	})
 }
+
+func TestUpdateOrgIfMatch(t *testing.T) {
+	ctx := context.Background()
+	business.SignUp(ctx, types.User{
+		UserInfo: types.UserInfo{Name: "etag", Email: "etag@a.b"},
+		Password: "123",
+	})
+	tokens, _ := business.SignIn(ctx, types.User{
+		UserInfo: types.UserInfo{Email: "etag@a.b"},
+		Password: "123",
+	})
+	authHeader := "Bearer " + tokens.AccessToken
+
+	var created types.CreateOrgResp
+	resp, _ := c.R().
+		SetHeader("Authorization", authHeader).
+		SetBody(`{"name":"etag org", "description":"org description"}`).
+		Post(url + "/organization")
+	json.Unmarshal(resp.Body(), &created)
+
+	resp, _ = c.R().
+		SetHeader("Authorization", authHeader).
+		Get(url + "/organization/" + created.OrgId)
+	etag := resp.Header().Get("ETag")
+	if etag == "" {
+		t.Fatalf("Expected an ETag but got none, body %s", resp.Body())
+	}
+
+	var readOrg types.ReadOrgResp
+	json.Unmarshal(resp.Body(), &readOrg)
+	if len(readOrg.OrgMembers) != 1 || readOrg.OrgMembers[0].Email != "etag@a.b" {
+		t.Errorf("Expected the deprecated organization_members to hold the creator but got %v", readOrg.OrgMembers)
+	}
+
+	resp, _ = c.R().
+		SetHeader("Authorization", authHeader).
+		SetHeader("If-Match", etag).
+		SetBody(`{"name":"first", "description":"org description"}`).
+		Put(url + "/organization/" + created.OrgId)
+	if resp.StatusCode() != http.StatusOK || resp.Header().Get("ETag") == etag {
+		t.Errorf("Expected update to succeed with a new ETag but got %d %s", resp.StatusCode(), resp.Header().Get("ETag"))
+	}
+
+	resp, _ = c.R().
+		SetHeader("Authorization", authHeader).
+		SetHeader("If-Match", etag).
+		SetBody(`{"name":"second", "description":"org description"}`).
+		Put(url + "/organization/" + created.OrgId)
+	if resp.StatusCode() != http.StatusPreconditionFailed {
+		t.Errorf("Expected status %d for a stale ETag but got %d", http.StatusPreconditionFailed, resp.StatusCode())
+	}
+
+	org, _ := business.ReadOrg(ctx, created.OrgId, "etag@a.b")
+	if org.Name != "first" {
+		t.Errorf("Expected stale update to be rejected but name is %s", org.Name)
+	}
+
+	resp, _ = c.R().
+		SetHeader("Authorization", authHeader).
+		SetHeader("If-Match", etag).
+		SetBody(`{"user_email":"etag-member@a.b"}`).
+		Post(url + "/organization/" + created.OrgId + "/invite")
+	if resp.StatusCode() != http.StatusPreconditionFailed {
+		t.Errorf("Expected status %d for a stale invitation but got %d", http.StatusPreconditionFailed, resp.StatusCode())
+	}
+
+	resp, _ = c.R().
+		SetHeader("Authorization", authHeader).
+		SetHeader("If-Match", etag).
+		Delete(url + "/organization/" + created.OrgId + "/members/etag@a.b")
+	if resp.StatusCode() != http.StatusPreconditionFailed {
+		t.Errorf("Expected status %d for a stale removal but got %d", http.StatusPreconditionFailed, resp.StatusCode())
+	}
+}
+
+func TestOrgHierarchyAccess(t *testing.T) {
+	ctx := context.Background()
+	for _, email := range []string{"root@a.b", "child-admin@a.b", "child-member@a.b"} {
+		business.SignUp(ctx, types.User{
+			UserInfo: types.UserInfo{Name: email, Email: email},
+			Password: "123",
+		})
+	}
+
+	parentId, err := business.CreateOrg(ctx, types.OrgInfo{Name: "root org", Description: "root"}, "root@a.b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	childId, err := business.CreateChildOrg(ctx, types.OrgInfo{Name: "child org", ParentId: parentId}, "root@a.b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	business.InviteUserToOrg(ctx, childId, "root@a.b", types.OrgMember{
+		UserInfo:    types.UserInfo{Email: "child-admin@a.b"},
+		AccessLevel: types.ACCESS_LEVEL_ADMIN,
+	}, 0)
+	business.InviteUserToOrg(ctx, childId, "root@a.b", types.OrgMember{
+		UserInfo:    types.UserInfo{Email: "child-member@a.b"},
+		AccessLevel: types.ACCESS_LEVEL_USER,
+	}, 0)
+
+	ancestors, err := business.ReadOrgAncestors(ctx, childId, "child-member@a.b")
+	if err != nil || len(ancestors) != 1 {
+		t.Fatalf("Expected the parent as only ancestor but got %+v, %v", ancestors, err)
+	}
+
+	if ancestors[0].Name != "root org" || ancestors[0].Description != "" {
+		t.Errorf("Expected only the name of an unreadable ancestor but got %+v", ancestors[0])
+	}
+
+	ancestors, _ = business.ReadOrgAncestors(ctx, childId, "root@a.b")
+	if len(ancestors) != 1 || ancestors[0].Description != "root" {
+		t.Errorf("Expected the whole parent for its admin but got %+v", ancestors)
+	}
+
+	if err := business.MoveOrg(ctx, childId, "", "child-admin@a.b"); err == nil {
+		t.Errorf("Expected a child admin not to detach the org from its parent")
+	}
+
+	if err := business.MoveOrg(ctx, childId, "", "root@a.b"); err != nil {
+		t.Errorf("Expected the parent admin to detach the org but got %v", err)
+	}
+}
+
+func TestEmailVerification(t *testing.T) {
+	ctx := context.Background()
+	business.SignUp(ctx, types.User{
+		UserInfo: types.UserInfo{Name: "inviter", Email: "inviter@a.b"},
+		Password: "123",
+	})
+
+	orgId, err := business.CreateOrg(ctx, types.OrgInfo{Name: "verified org"}, "inviter@a.b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	business.InviteUserToOrg(ctx, orgId, "inviter@a.b", types.OrgMember{
+		UserInfo:    types.UserInfo{Email: "invited@a.b"},
+		AccessLevel: types.ACCESS_LEVEL_USER,
+	}, 0)
+
+	business.SignUp(ctx, types.User{
+		UserInfo: types.UserInfo{Name: "invited", Email: "invited@a.b"},
+		Password: "123",
+	})
+
+	if _, err := business.ReadOrg(ctx, orgId, "invited@a.b"); err == nil {
+		t.Errorf("Expected the invitation to wait for the email verification")
+	}
+
+	verifyEmail(t, "invited@a.b")
+
+	if _, err := business.ReadOrg(ctx, orgId, "invited@a.b"); err != nil {
+		t.Errorf("Expected the verified email to join the org but got %v", err)
+	}
+
+	if err := business.ChangeEmail(ctx, "invited@a.b", "renamed@a.b", "123"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := business.SignIn(ctx, types.User{UserInfo: types.UserInfo{Email: "renamed@a.b"}, Password: "123"}); err == nil {
+		t.Errorf("Expected the email to change only once verified")
+	}
+
+	verifyEmail(t, "renamed@a.b")
+
+	if _, err := business.SignIn(ctx, types.User{UserInfo: types.UserInfo{Email: "renamed@a.b"}, Password: "123"}); err != nil {
+		t.Errorf("Expected the verified email to sign in but got %v", err)
+	}
+
+	_, err = business.AddOrgDomain(ctx, orgId, "inviter@a.b", types.OrgDomain{Domain: "a.b", AccessLevel: types.ACCESS_LEVEL_ADMIN})
+	if err == nil {
+		t.Errorf("Expected a domain claim granting admin to be rejected")
+	}
+}
+
+// verifyEmail posts the token of the last verification mailed to the email.
+func verifyEmail(t *testing.T, email string) {
+	mail, ok := outbox.Last(email)
+	if !ok {
+		t.Fatalf("Expected a verification mail to %s", email)
+	}
+
+	token := strings.Split(mail.Body, "\n\n")[1]
+	resp, _ := c.R().
+		SetBody(`{"token":"` + token + `"}`).
+		Post(url + "/user/email/verify")
+
+	if !strings.Contains(string(resp.Body()), "Succeeded") {
+		t.Fatalf("Expected the verification of %s to succeed but got %s", email, resp.Body())
+	}
+}
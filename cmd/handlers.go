@@ -1,10 +1,18 @@
 package main
 
 import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/zaher1307/IDEANEST-project-assignment/internal/business"
+	"github.com/zaher1307/IDEANEST-project-assignment/internal/database"
+	"github.com/zaher1307/IDEANEST-project-assignment/internal/domain"
 	"github.com/zaher1307/IDEANEST-project-assignment/internal/types"
 )
 
@@ -25,7 +33,7 @@ func SignUpHandler(c *gin.Context) {
 		Password: signUpReq.Password,
 	}
 
-	err := business.SignUp(user)
+	err := business.SignUp(c.Request.Context(), user)
 	if err != nil {
 		c.JSON(http.StatusOK, types.MessageResp{
 			Message: "Faild: " + err.Error(),
@@ -56,7 +64,7 @@ func SignInHandler(c *gin.Context) {
 		Password: signInReq.Password,
 	}
 
-	tokens, err := business.SignIn(user)
+	tokens, err := business.SignIn(c.Request.Context(), user)
 	if err != nil {
 		c.JSON(http.StatusOK, types.TokenResp{
 			Message:      "Faild: " + err.Error(),
@@ -101,6 +109,52 @@ func RefreshTokenHandler(c *gin.Context) {
 	})
 }
 
+func ChangeEmailHandler(c *gin.Context) {
+	changeEmailReq := types.ChangeEmailReq{}
+	if err := c.ShouldBindJSON(&changeEmailReq); err != nil {
+		c.JSON(http.StatusBadRequest, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	email, _ := c.Get("email")
+
+	err := business.ChangeEmail(c.Request.Context(), email.(string), changeEmailReq.Email, changeEmailReq.Password)
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.MessageResp{
+		Message: "Succeeded",
+	})
+}
+
+func VerifyEmailHandler(c *gin.Context) {
+	verifyEmailReq := types.VerifyEmailReq{}
+	if err := c.ShouldBindJSON(&verifyEmailReq); err != nil {
+		c.JSON(http.StatusBadRequest, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	err := business.VerifyEmail(c.Request.Context(), verifyEmailReq.Token)
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.MessageResp{
+		Message: "Succeeded",
+	})
+}
+
 func CreateOrgHandler(c *gin.Context) {
 	createOrgReq := types.CreateOrgReq{}
 	if err := c.ShouldBindJSON(&createOrgReq); err != nil {
@@ -117,7 +171,7 @@ func CreateOrgHandler(c *gin.Context) {
 
 	email, _ := c.Get("email")
 
-	id, err := business.CreateOrg(orgInfo, email.(string))
+	id, err := business.CreateOrg(c.Request.Context(), orgInfo, email.(string))
 	if err != nil {
 		c.JSON(http.StatusOK, types.MessageResp{
 			Message: "Faild: " + err.Error(),
@@ -134,7 +188,7 @@ func ReadOrgHandler(c *gin.Context) {
 	orgId := c.Param("organization_id")
 	email, _ := c.Get("email")
 
-	org, err := business.ReadOrg(orgId, email.(string))
+	org, err := business.ReadOrg(c.Request.Context(), orgId, email.(string))
 	if err != nil {
 		c.JSON(http.StatusOK, types.MessageResp{
 			Message: "Faild: " + err.Error(),
@@ -142,28 +196,47 @@ func ReadOrgHandler(c *gin.Context) {
 		return
 	}
 
-	readOrgResp := types.ReadOrgResp{
-		OrgId:       org.OrgId,
-		Name:        org.Name,
-		Description: org.Description,
+	c.Header("ETag", orgETag(org.Version))
+	c.JSON(http.StatusOK, readOrgResp(org))
+}
+
+func ReadAllOrgsHandler(c *gin.Context) {
+	email, _ := c.Get("email")
+
+	orgs, err := business.ReadAllOrgs(c.Request.Context(), email.(string), c.QueryArray("tag"))
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
 	}
 
-	for _, orgMember := range org.OrgMembers {
-		orgMemberResp := types.OrgMemberResp{
-			Name:        orgMember.Name,
-			Email:       orgMember.Email,
-			AccessLevel: orgMember.AccessLevel,
-		}
-		readOrgResp.OrgMembers = append(readOrgResp.OrgMembers, orgMemberResp)
+	var allOrgsResp []types.ReadOrgResp
+	for _, org := range orgs {
+		allOrgsResp = append(allOrgsResp, readOrgResp(org))
 	}
 
-	c.JSON(http.StatusOK, readOrgResp)
+	c.JSON(http.StatusOK, allOrgsResp)
 }
 
-func ReadAllOrgsHandler(c *gin.Context) {
+func CreateChildOrgHandler(c *gin.Context) {
+	createOrgReq := types.CreateOrgReq{}
+	if err := c.ShouldBindJSON(&createOrgReq); err != nil {
+		c.JSON(http.StatusBadRequest, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	orgInfo := types.OrgInfo{
+		ParentId:    c.Param("organization_id"),
+		Name:        createOrgReq.Name,
+		Description: createOrgReq.Description,
+	}
+
 	email, _ := c.Get("email")
 
-	orgs, err := business.ReadAllOrgs(email.(string))
+	id, err := business.CreateChildOrg(c.Request.Context(), orgInfo, email.(string))
 	if err != nil {
 		c.JSON(http.StatusOK, types.MessageResp{
 			Message: "Faild: " + err.Error(),
@@ -171,32 +244,97 @@ func ReadAllOrgsHandler(c *gin.Context) {
 		return
 	}
 
-	var allOrgsResp []types.ReadOrgResp
-	for _, org := range orgs {
-		readOrgResp := types.ReadOrgResp{
-			OrgId:       org.OrgId,
-			Name:        org.Name,
-			Description: org.Description,
-		}
+	c.JSON(http.StatusOK, types.CreateOrgResp{
+		OrgId: id,
+	})
+}
 
-		for _, orgMember := range org.OrgMembers {
-			orgMemberResp := types.OrgMemberResp{
-				Name:        orgMember.Name,
-				Email:       orgMember.Email,
-				AccessLevel: orgMember.AccessLevel,
-			}
-			readOrgResp.OrgMembers = append(readOrgResp.OrgMembers, orgMemberResp)
-		}
+func AddOrgDomainHandler(c *gin.Context) {
+	addOrgDomainReq := types.AddOrgDomainReq{}
+	if err := c.ShouldBindJSON(&addOrgDomainReq); err != nil {
+		c.JSON(http.StatusBadRequest, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
 
-		allOrgsResp = append(allOrgsResp, readOrgResp)
+	email, _ := c.Get("email")
+	orgId := c.Param("organization_id")
+	orgDomain := types.OrgDomain{
+		Domain:      addOrgDomainReq.Domain,
+		AccessLevel: addOrgDomainReq.AccessLevel,
 	}
 
-	c.JSON(http.StatusOK, allOrgsResp)
+	orgDomain, err := business.AddOrgDomain(c.Request.Context(), orgId, email.(string), orgDomain)
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, orgDomainResp(orgDomain))
 }
 
-func UpdateOrgHandler(c *gin.Context) {
-	updateOrgReq := types.UpdateOrgReq{}
-	if err := c.ShouldBindJSON(&updateOrgReq); err != nil {
+func ReadOrgDomainsHandler(c *gin.Context) {
+	email, _ := c.Get("email")
+	orgId := c.Param("organization_id")
+
+	orgDomains, err := business.ReadOrgDomains(c.Request.Context(), orgId, email.(string))
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	orgDomainsResp := []types.OrgDomainResp{}
+	for _, orgDomain := range orgDomains {
+		orgDomainsResp = append(orgDomainsResp, orgDomainResp(orgDomain))
+	}
+
+	c.JSON(http.StatusOK, orgDomainsResp)
+}
+
+func VerifyOrgDomainHandler(c *gin.Context) {
+	email, _ := c.Get("email")
+	orgId := c.Param("organization_id")
+	domainName := c.Param("domain")
+
+	err := business.VerifyOrgDomain(c.Request.Context(), orgId, domainName, email.(string))
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.MessageResp{
+		Message: "Succeeded",
+	})
+}
+
+func RemoveOrgDomainHandler(c *gin.Context) {
+	email, _ := c.Get("email")
+	orgId := c.Param("organization_id")
+	domainName := c.Param("domain")
+
+	err := business.RemoveOrgDomain(c.Request.Context(), orgId, domainName, email.(string))
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.MessageResp{
+		Message: "Succeeded",
+	})
+}
+
+func UpdateOrgSettingsHandler(c *gin.Context) {
+	updateSettingsReq := types.UpdateSettingsReq{}
+	if err := c.ShouldBindJSON(&updateSettingsReq); err != nil {
 		c.JSON(http.StatusBadRequest, types.MessageResp{
 			Message: "Faild: " + err.Error(),
 		})
@@ -205,33 +343,72 @@ func UpdateOrgHandler(c *gin.Context) {
 
 	email, _ := c.Get("email")
 	orgId := c.Param("organization_id")
-	orgInfo := types.OrgInfo{
-		OrgId:       orgId,
-		Name:        updateOrgReq.Name,
-		Description: updateOrgReq.Description,
+	settings := types.OrgSettings{
+		Timezone:          updateSettingsReq.Timezone,
+		Locale:            updateSettingsReq.Locale,
+		LogoURL:           updateSettingsReq.LogoURL,
+		DefaultMemberRole: updateSettingsReq.DefaultMemberRole,
+		Visibility:        updateSettingsReq.Visibility,
 	}
 
-	orgInfoMod, err := business.UpdateOrg(orgInfo, email.(string))
+	err := business.UpdateOrgSettings(c.Request.Context(), orgId, settings, email.(string))
 	if err != nil {
 		c.JSON(http.StatusOK, types.MessageResp{
 			Message: "Faild: " + err.Error(),
 		})
 		return
 	}
-	updateOrgResp := types.UpdateOrgResp{
-		OrgId:       orgId,
-		Name:        orgInfoMod.Name,
-		Description: orgInfo.Description,
+
+	c.JSON(http.StatusOK, types.MessageResp{
+		Message: "Succeeded",
+	})
+}
+
+func UpdateOrgMetadataFieldsHandler(c *gin.Context) {
+	var metadataFieldReqs []types.MetadataFieldReq
+	if err := c.ShouldBindJSON(&metadataFieldReqs); err != nil {
+		c.JSON(http.StatusBadRequest, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
 	}
 
-	c.JSON(http.StatusOK, updateOrgResp)
+	email, _ := c.Get("email")
+	orgId := c.Param("organization_id")
+	fields := make([]types.MetadataField, len(metadataFieldReqs))
+	for i, metadataFieldReq := range metadataFieldReqs {
+		fields[i] = types.MetadataField{
+			Key:  metadataFieldReq.Key,
+			Type: metadataFieldReq.Type,
+		}
+	}
+
+	err := business.UpdateOrgMetadataFields(c.Request.Context(), orgId, fields, email.(string))
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.MessageResp{
+		Message: "Succeeded",
+	})
 }
 
-func DeleteOrgHandler(c *gin.Context) {
+func UpdateOrgMetadataHandler(c *gin.Context) {
+	var metadata map[string]interface{}
+	if err := c.ShouldBindJSON(&metadata); err != nil {
+		c.JSON(http.StatusBadRequest, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
 	email, _ := c.Get("email")
 	orgId := c.Param("organization_id")
 
-	err := business.DeleteOrg(orgId, email.(string))
+	err := business.UpdateOrgMetadata(c.Request.Context(), orgId, metadata, email.(string))
 	if err != nil {
 		c.JSON(http.StatusOK, types.MessageResp{
 			Message: "Faild: " + err.Error(),
@@ -244,9 +421,9 @@ func DeleteOrgHandler(c *gin.Context) {
 	})
 }
 
-func InviteUserToOrgHandler(c *gin.Context) {
-	inviteReq := types.InviteReq{}
-	if err := c.ShouldBindJSON(&inviteReq); err != nil {
+func UpdateOrgQuotaHandler(c *gin.Context) {
+	updateQuotaReq := types.UpdateQuotaReq{}
+	if err := c.ShouldBindJSON(&updateQuotaReq); err != nil {
 		c.JSON(http.StatusBadRequest, types.MessageResp{
 			Message: "Faild: " + err.Error(),
 		})
@@ -255,14 +432,13 @@ func InviteUserToOrgHandler(c *gin.Context) {
 
 	email, _ := c.Get("email")
 	orgId := c.Param("organization_id")
-	member := types.OrgMember{
-		UserInfo: types.UserInfo{
-			Email: inviteReq.Email,
-		},
-		AccessLevel: types.ACCESS_LEVEL_USER,
+	quota := types.OrgQuota{
+		MaxMembers:        updateQuotaReq.MaxMembers,
+		MaxPendingInvites: updateQuotaReq.MaxPendingInvites,
+		MaxTeams:          updateQuotaReq.MaxTeams,
 	}
 
-	err := business.InviteUserToOrg(orgId, email.(string), member)
+	err := business.UpdateOrgQuota(c.Request.Context(), orgId, quota, email.(string))
 	if err != nil {
 		c.JSON(http.StatusOK, types.MessageResp{
 			Message: "Faild: " + err.Error(),
@@ -275,9 +451,31 @@ func InviteUserToOrgHandler(c *gin.Context) {
 	})
 }
 
-func RevokeRefreshTokenHandler(c *gin.Context) {
-	refreshTokenReq := types.RefreshTokenReq{}
-	if err := c.ShouldBindJSON(&refreshTokenReq); err != nil {
+func ReadOrgUsageHandler(c *gin.Context) {
+	email, _ := c.Get("email")
+	orgId := c.Param("organization_id")
+
+	org, err := business.ReadOrgUsage(c.Request.Context(), orgId, email.(string))
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.OrgUsageResp{
+		Members:           org.MembersCount,
+		MaxMembers:        org.Quota.MaxMembers,
+		PendingInvites:    org.PendingInvites,
+		MaxPendingInvites: org.Quota.MaxPendingInvites,
+		Teams:             org.TeamsCount,
+		MaxTeams:          org.Quota.MaxTeams,
+	})
+}
+
+func MoveOrgHandler(c *gin.Context) {
+	moveOrgReq := types.MoveOrgReq{}
+	if err := c.ShouldBindJSON(&moveOrgReq); err != nil {
 		c.JSON(http.StatusBadRequest, types.MessageResp{
 			Message: "Faild: " + err.Error(),
 		})
@@ -285,8 +483,9 @@ func RevokeRefreshTokenHandler(c *gin.Context) {
 	}
 
 	email, _ := c.Get("email")
+	orgId := c.Param("organization_id")
 
-	err := business.RevokeRefreshToken(refreshTokenReq.RefreshToken, email.(string))
+	err := business.MoveOrg(c.Request.Context(), orgId, moveOrgReq.ParentId, email.(string))
 	if err != nil {
 		c.JSON(http.StatusOK, types.MessageResp{
 			Message: "Faild: " + err.Error(),
@@ -298,3 +497,1153 @@ func RevokeRefreshTokenHandler(c *gin.Context) {
 		Message: "Succeeded",
 	})
 }
+
+func ReadOrgAncestorsHandler(c *gin.Context) {
+	email, _ := c.Get("email")
+	orgId := c.Param("organization_id")
+
+	orgs, err := business.ReadOrgAncestors(c.Request.Context(), orgId, email.(string))
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	orgsResp := []types.ReadOrgResp{}
+	for _, org := range orgs {
+		orgsResp = append(orgsResp, readOrgResp(org))
+	}
+
+	c.JSON(http.StatusOK, orgsResp)
+}
+
+func ReadOrgDescendantsHandler(c *gin.Context) {
+	email, _ := c.Get("email")
+	orgId := c.Param("organization_id")
+
+	orgs, err := business.ReadOrgDescendants(c.Request.Context(), orgId, email.(string))
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	orgsResp := []types.ReadOrgResp{}
+	for _, org := range orgs {
+		orgsResp = append(orgsResp, readOrgResp(org))
+	}
+
+	c.JSON(http.StatusOK, orgsResp)
+}
+
+func UpdateOrgVisibilityHandler(c *gin.Context) {
+	updateVisibilityReq := types.UpdateVisibilityReq{}
+	if err := c.ShouldBindJSON(&updateVisibilityReq); err != nil {
+		c.JSON(http.StatusBadRequest, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	email, _ := c.Get("email")
+	orgId := c.Param("organization_id")
+
+	err := business.UpdateOrgVisibility(c.Request.Context(), orgId, updateVisibilityReq.Visibility, email.(string))
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.MessageResp{
+		Message: "Succeeded",
+	})
+}
+
+func DiscoverOrgsHandler(c *gin.Context) {
+	orgs, err := business.DiscoverOrgs(c.Request.Context(), c.Query("q"))
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	discoverOrgsResp := []types.DiscoverOrgResp{}
+	for _, org := range orgs {
+		discoverOrgsResp = append(discoverOrgsResp, types.DiscoverOrgResp{
+			OrgId:        org.OrgId,
+			Name:         org.Name,
+			Description:  org.Description,
+			MembersCount: org.MembersCount,
+		})
+	}
+
+	c.JSON(http.StatusOK, discoverOrgsResp)
+}
+
+func RequestToJoinOrgHandler(c *gin.Context) {
+	email, _ := c.Get("email")
+	orgId := c.Param("organization_id")
+
+	id, err := business.RequestToJoinOrg(c.Request.Context(), orgId, email.(string))
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.CreateJoinRequestResp{
+		RequestId: id,
+	})
+}
+
+func ReadJoinRequestsHandler(c *gin.Context) {
+	email, _ := c.Get("email")
+	orgId := c.Param("organization_id")
+
+	joinRequests, err := business.ReadJoinRequests(c.Request.Context(), orgId, email.(string))
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	joinRequestsResp := []types.JoinRequestResp{}
+	for _, joinRequest := range joinRequests {
+		joinRequestsResp = append(joinRequestsResp, types.JoinRequestResp{
+			RequestId: joinRequest.RequestId,
+			OrgId:     joinRequest.OrgId,
+			Name:      joinRequest.Name,
+			Email:     joinRequest.Email,
+			Status:    joinRequest.Status,
+			CreatedAt: joinRequest.CreatedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, joinRequestsResp)
+}
+
+func ApproveJoinRequestHandler(c *gin.Context) {
+	email, _ := c.Get("email")
+	orgId := c.Param("organization_id")
+	requestId := c.Param("request_id")
+
+	err := business.ApproveJoinRequest(c.Request.Context(), orgId, requestId, email.(string))
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.MessageResp{
+		Message: "Succeeded",
+	})
+}
+
+func RejectJoinRequestHandler(c *gin.Context) {
+	email, _ := c.Get("email")
+	orgId := c.Param("organization_id")
+	requestId := c.Param("request_id")
+
+	err := business.RejectJoinRequest(c.Request.Context(), orgId, requestId, email.(string))
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.MessageResp{
+		Message: "Succeeded",
+	})
+}
+
+func UpdateOrgHandler(c *gin.Context) {
+	updateOrgReq := types.UpdateOrgReq{}
+	if err := c.ShouldBindJSON(&updateOrgReq); err != nil {
+		c.JSON(http.StatusBadRequest, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		c.JSON(http.StatusPreconditionFailed, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	email, _ := c.Get("email")
+	orgId := c.Param("organization_id")
+	orgInfo := types.OrgInfo{
+		OrgId:       orgId,
+		Name:        updateOrgReq.Name,
+		Description: updateOrgReq.Description,
+		Version:     version,
+	}
+
+	orgInfoMod, err := business.UpdateOrg(c.Request.Context(), orgInfo, email.(string))
+	if errors.Is(err, database.ErrOrgModified) {
+		c.JSON(http.StatusPreconditionFailed, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+	c.Header("ETag", orgETag(orgInfoMod.Version))
+	updateOrgResp := types.UpdateOrgResp{
+		OrgId:       orgId,
+		Slug:        orgInfoMod.Slug,
+		Name:        orgInfoMod.Name,
+		Description: orgInfo.Description,
+	}
+
+	c.JSON(http.StatusOK, updateOrgResp)
+}
+
+func DeleteOrgHandler(c *gin.Context) {
+	email, _ := c.Get("email")
+	orgId := c.Param("organization_id")
+
+	err := business.DeleteOrg(c.Request.Context(), orgId, email.(string))
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.MessageResp{
+		Message: "Succeeded",
+	})
+}
+
+func AddOrgTagsHandler(c *gin.Context) {
+	addOrgTagsReq := types.AddOrgTagsReq{}
+	if err := c.ShouldBindJSON(&addOrgTagsReq); err != nil {
+		c.JSON(http.StatusBadRequest, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	email, _ := c.Get("email")
+	orgId := c.Param("organization_id")
+
+	tags, err := business.AddOrgTags(c.Request.Context(), orgId, email.(string), addOrgTagsReq.Tags)
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.OrgTagsResp{
+		Tags: tags,
+	})
+}
+
+func RemoveOrgTagHandler(c *gin.Context) {
+	email, _ := c.Get("email")
+	orgId := c.Param("organization_id")
+	tag := c.Param("tag")
+
+	err := business.RemoveOrgTag(c.Request.Context(), orgId, email.(string), tag)
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.MessageResp{
+		Message: "Succeeded",
+	})
+}
+
+func UploadOrgLogoHandler(c *gin.Context) {
+	// leave room for the multipart envelope around the file itself
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, business.MAX_LOGO_SIZE+64<<10)
+
+	data, err := readLogoFile(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	email, _ := c.Get("email")
+	orgId := c.Param("organization_id")
+
+	logoURLs, err := business.UploadOrgLogo(c.Request.Context(), orgId, email.(string), data)
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.OrgLogoResp{
+		LogoURLs: logoURLs,
+	})
+}
+
+func DeleteOrgLogoHandler(c *gin.Context) {
+	email, _ := c.Get("email")
+	orgId := c.Param("organization_id")
+
+	err := business.DeleteOrgLogo(c.Request.Context(), orgId, email.(string))
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.MessageResp{
+		Message: "Succeeded",
+	})
+}
+
+func RestoreOrgHandler(c *gin.Context) {
+	email, _ := c.Get("email")
+	orgId := c.Param("organization_id")
+
+	err := business.RestoreOrg(c.Request.Context(), orgId, email.(string))
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.MessageResp{
+		Message: "Succeeded",
+	})
+}
+
+func InviteUserToOrgHandler(c *gin.Context) {
+	inviteReq := types.InviteReq{}
+	if err := c.ShouldBindJSON(&inviteReq); err != nil {
+		c.JSON(http.StatusBadRequest, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		c.JSON(http.StatusPreconditionFailed, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	email, _ := c.Get("email")
+	orgId := c.Param("organization_id")
+	member := types.OrgMember{
+		UserInfo: types.UserInfo{
+			Email: inviteReq.Email,
+		},
+	}
+
+	err = business.InviteUserToOrg(c.Request.Context(), orgId, email.(string), member, version)
+	if errors.Is(err, database.ErrOrgModified) {
+		c.JSON(http.StatusPreconditionFailed, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.MessageResp{
+		Message: "Succeeded",
+	})
+}
+
+func ReadOrgMembersHandler(c *gin.Context) {
+	readMembersReq := types.ReadMembersReq{}
+	if err := c.ShouldBindQuery(&readMembersReq); err != nil {
+		c.JSON(http.StatusBadRequest, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	email, _ := c.Get("email")
+	orgId := c.Param("organization_id")
+	query := types.MemberQuery{
+		Prefix:     readMembersReq.Query,
+		Role:       readMembersReq.Role,
+		SortBy:     strings.TrimPrefix(readMembersReq.Sort, "-"),
+		Descending: strings.HasPrefix(readMembersReq.Sort, "-"),
+		Limit:      readMembersReq.Limit,
+	}
+
+	members, nextCursor, err := business.ReadOrgMembers(c.Request.Context(), orgId, email.(string), query, readMembersReq.Cursor)
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	membersPageResp := types.MembersPageResp{
+		OrgMembers: []types.OrgMemberResp{},
+		NextCursor: nextCursor,
+	}
+	for _, member := range members {
+		membersPageResp.OrgMembers = append(membersPageResp.OrgMembers, types.OrgMemberResp{
+			Name:        member.Name,
+			Email:       member.Email,
+			AccessLevel: member.AccessLevel,
+		})
+	}
+
+	c.JSON(http.StatusOK, membersPageResp)
+}
+
+func RemoveUserFromOrgHandler(c *gin.Context) {
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		c.JSON(http.StatusPreconditionFailed, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	email, _ := c.Get("email")
+	orgId := c.Param("organization_id")
+	memberEmail := c.Param("user_email")
+
+	err = business.RemoveUserFromOrg(c.Request.Context(), orgId, email.(string), memberEmail, version)
+	if errors.Is(err, database.ErrOrgModified) {
+		c.JSON(http.StatusPreconditionFailed, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.MessageResp{
+		Message: "Succeeded",
+	})
+}
+
+func ImportMembersHandler(c *gin.Context) {
+	var importMemberReqs []types.ImportMemberReq
+	var err error
+	if c.ContentType() == "text/csv" {
+		importMemberReqs, err = parseImportCSV(c.Request.Body)
+	} else {
+		err = c.ShouldBindJSON(&importMemberReqs)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		c.JSON(http.StatusPreconditionFailed, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	email, _ := c.Get("email")
+	orgId := c.Param("organization_id")
+	members := make([]types.OrgMember, len(importMemberReqs))
+	for i, importMemberReq := range importMemberReqs {
+		members[i] = types.OrgMember{
+			UserInfo: types.UserInfo{
+				Email: importMemberReq.Email,
+			},
+			AccessLevel: importMemberReq.AccessLevel,
+		}
+	}
+
+	results, err := business.ImportMembersToOrg(c.Request.Context(), orgId, email.(string), members, version)
+	if errors.Is(err, database.ErrOrgModified) {
+		c.JSON(http.StatusPreconditionFailed, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	importResultsResp := []types.ImportResultResp{}
+	for i, result := range results {
+		importResultsResp = append(importResultsResp, types.ImportResultResp{
+			Row:         i + 1,
+			Email:       result.Email,
+			AccessLevel: result.AccessLevel,
+			Status:      result.Status,
+			Reason:      result.Reason,
+		})
+	}
+
+	c.JSON(http.StatusOK, importResultsResp)
+}
+
+func CreateInviteLinkHandler(c *gin.Context) {
+	createInviteLinkReq := types.CreateInviteLinkReq{}
+	if err := c.ShouldBindJSON(&createInviteLinkReq); err != nil {
+		c.JSON(http.StatusBadRequest, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	email, _ := c.Get("email")
+	orgId := c.Param("organization_id")
+	link := types.InviteLink{
+		OrgId:       orgId,
+		AccessLevel: createInviteLinkReq.AccessLevel,
+		MaxUses:     createInviteLinkReq.MaxUses,
+		ExpiresAt:   createInviteLinkReq.ExpiresAt,
+	}
+
+	link, err := business.CreateInviteLink(c.Request.Context(), link, email.(string))
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, inviteLinkResp(link))
+}
+
+func ReadInviteLinksHandler(c *gin.Context) {
+	email, _ := c.Get("email")
+	orgId := c.Param("organization_id")
+
+	links, err := business.ReadInviteLinks(c.Request.Context(), orgId, email.(string))
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	inviteLinksResp := []types.InviteLinkResp{}
+	for _, link := range links {
+		inviteLinksResp = append(inviteLinksResp, inviteLinkResp(link))
+	}
+
+	c.JSON(http.StatusOK, inviteLinksResp)
+}
+
+func RevokeInviteLinkHandler(c *gin.Context) {
+	email, _ := c.Get("email")
+	orgId := c.Param("organization_id")
+	code := c.Param("code")
+
+	err := business.RevokeInviteLink(c.Request.Context(), orgId, code, email.(string))
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.MessageResp{
+		Message: "Succeeded",
+	})
+}
+
+func JoinOrgHandler(c *gin.Context) {
+	email, _ := c.Get("email")
+	code := c.Param("code")
+
+	orgId, err := business.JoinOrgByInviteLink(c.Request.Context(), code, email.(string))
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.CreateOrgResp{
+		OrgId: orgId,
+	})
+}
+
+func CreateTeamHandler(c *gin.Context) {
+	createTeamReq := types.CreateTeamReq{}
+	if err := c.ShouldBindJSON(&createTeamReq); err != nil {
+		c.JSON(http.StatusBadRequest, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	email, _ := c.Get("email")
+	team := types.Team{
+		OrgId:       c.Param("organization_id"),
+		Name:        createTeamReq.Name,
+		Description: createTeamReq.Description,
+	}
+
+	id, err := business.CreateTeam(c.Request.Context(), team, email.(string))
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	team.TeamId = id
+
+	c.JSON(http.StatusOK, teamResp(team))
+}
+
+func ReadTeamsHandler(c *gin.Context) {
+	email, _ := c.Get("email")
+	orgId := c.Param("organization_id")
+
+	teams, err := business.ReadTeams(c.Request.Context(), orgId, email.(string))
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	teamsResp := []types.TeamResp{}
+	for _, team := range teams {
+		teamsResp = append(teamsResp, teamResp(team))
+	}
+
+	c.JSON(http.StatusOK, teamsResp)
+}
+
+func ReadTeamHandler(c *gin.Context) {
+	email, _ := c.Get("email")
+	orgId := c.Param("organization_id")
+	teamId := c.Param("team_id")
+
+	team, err := business.ReadTeam(c.Request.Context(), orgId, teamId, email.(string))
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, teamResp(team))
+}
+
+func UpdateTeamHandler(c *gin.Context) {
+	updateTeamReq := types.UpdateTeamReq{}
+	if err := c.ShouldBindJSON(&updateTeamReq); err != nil {
+		c.JSON(http.StatusBadRequest, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	email, _ := c.Get("email")
+	team := types.Team{
+		TeamId:      c.Param("team_id"),
+		OrgId:       c.Param("organization_id"),
+		Name:        updateTeamReq.Name,
+		Description: updateTeamReq.Description,
+	}
+
+	err := business.UpdateTeam(c.Request.Context(), team, email.(string))
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.MessageResp{
+		Message: "Succeeded",
+	})
+}
+
+func DeleteTeamHandler(c *gin.Context) {
+	email, _ := c.Get("email")
+	orgId := c.Param("organization_id")
+	teamId := c.Param("team_id")
+
+	err := business.DeleteTeam(c.Request.Context(), orgId, teamId, email.(string))
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.MessageResp{
+		Message: "Succeeded",
+	})
+}
+
+func AddTeamMemberHandler(c *gin.Context) {
+	teamMemberReq := types.TeamMemberReq{}
+	if err := c.ShouldBindJSON(&teamMemberReq); err != nil {
+		c.JSON(http.StatusBadRequest, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	email, _ := c.Get("email")
+	orgId := c.Param("organization_id")
+	teamId := c.Param("team_id")
+	member := types.TeamMember{
+		UserInfo: types.UserInfo{
+			Email: teamMemberReq.Email,
+		},
+		Role: teamMemberReq.Role,
+	}
+
+	err := business.AddTeamMember(c.Request.Context(), orgId, teamId, email.(string), member)
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.MessageResp{
+		Message: "Succeeded",
+	})
+}
+
+func RemoveTeamMemberHandler(c *gin.Context) {
+	email, _ := c.Get("email")
+	orgId := c.Param("organization_id")
+	teamId := c.Param("team_id")
+	memberEmail := c.Param("user_email")
+
+	err := business.RemoveTeamMember(c.Request.Context(), orgId, teamId, email.(string), memberEmail)
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.MessageResp{
+		Message: "Succeeded",
+	})
+}
+
+func CreateProjectHandler(c *gin.Context) {
+	createProjectReq := types.CreateProjectReq{}
+	if err := c.ShouldBindJSON(&createProjectReq); err != nil {
+		c.JSON(http.StatusBadRequest, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	email, _ := c.Get("email")
+	project := types.Project{
+		OrgId:       c.Param("organization_id"),
+		Name:        createProjectReq.Name,
+		Description: createProjectReq.Description,
+	}
+
+	id, err := business.CreateProject(c.Request.Context(), project, email.(string))
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	project, err = business.ReadProject(c.Request.Context(), project.OrgId, id, email.(string))
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, projectResp(project))
+}
+
+func ReadProjectsHandler(c *gin.Context) {
+	email, _ := c.Get("email")
+	orgId := c.Param("organization_id")
+
+	projects, err := business.ReadProjects(c.Request.Context(), orgId, email.(string))
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	projectsResp := []types.ProjectResp{}
+	for _, project := range projects {
+		projectsResp = append(projectsResp, projectResp(project))
+	}
+
+	c.JSON(http.StatusOK, projectsResp)
+}
+
+func ReadProjectHandler(c *gin.Context) {
+	email, _ := c.Get("email")
+	orgId := c.Param("organization_id")
+	projectId := c.Param("project_id")
+
+	project, err := business.ReadProject(c.Request.Context(), orgId, projectId, email.(string))
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, projectResp(project))
+}
+
+func UpdateProjectHandler(c *gin.Context) {
+	updateProjectReq := types.UpdateProjectReq{}
+	if err := c.ShouldBindJSON(&updateProjectReq); err != nil {
+		c.JSON(http.StatusBadRequest, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	email, _ := c.Get("email")
+	project := types.Project{
+		ProjectId:   c.Param("project_id"),
+		OrgId:       c.Param("organization_id"),
+		Name:        updateProjectReq.Name,
+		Description: updateProjectReq.Description,
+	}
+
+	err := business.UpdateProject(c.Request.Context(), project, email.(string))
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.MessageResp{
+		Message: "Succeeded",
+	})
+}
+
+func DeleteProjectHandler(c *gin.Context) {
+	email, _ := c.Get("email")
+	orgId := c.Param("organization_id")
+	projectId := c.Param("project_id")
+
+	err := business.DeleteProject(c.Request.Context(), orgId, projectId, email.(string))
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.MessageResp{
+		Message: "Succeeded",
+	})
+}
+
+func SetProjectMemberHandler(c *gin.Context) {
+	projectMemberReq := types.ProjectMemberReq{}
+	if err := c.ShouldBindJSON(&projectMemberReq); err != nil {
+		c.JSON(http.StatusBadRequest, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	email, _ := c.Get("email")
+	orgId := c.Param("organization_id")
+	projectId := c.Param("project_id")
+	member := types.ProjectMember{
+		UserInfo: types.UserInfo{
+			Email: projectMemberReq.Email,
+		},
+		AccessLevel: projectMemberReq.AccessLevel,
+	}
+
+	err := business.SetProjectMember(c.Request.Context(), orgId, projectId, email.(string), member)
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.MessageResp{
+		Message: "Succeeded",
+	})
+}
+
+func RemoveProjectMemberHandler(c *gin.Context) {
+	email, _ := c.Get("email")
+	orgId := c.Param("organization_id")
+	projectId := c.Param("project_id")
+	memberEmail := c.Param("user_email")
+
+	err := business.RemoveProjectMember(c.Request.Context(), orgId, projectId, email.(string), memberEmail)
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.MessageResp{
+		Message: "Succeeded",
+	})
+}
+
+func RevokeRefreshTokenHandler(c *gin.Context) {
+	refreshTokenReq := types.RefreshTokenReq{}
+	if err := c.ShouldBindJSON(&refreshTokenReq); err != nil {
+		c.JSON(http.StatusBadRequest, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	email, _ := c.Get("email")
+
+	err := business.RevokeRefreshToken(refreshTokenReq.RefreshToken, email.(string))
+	if err != nil {
+		c.JSON(http.StatusOK, types.MessageResp{
+			Message: "Faild: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.MessageResp{
+		Message: "Succeeded",
+	})
+}
+
+// ====================== helper private function ====================== //
+
+// readLogoFile reads the "logo" file of a multipart form, refusing files
+// larger than business.MAX_LOGO_SIZE.
+func readLogoFile(c *gin.Context) ([]byte, error) {
+	fileHeader, err := c.FormFile("logo")
+	if err != nil {
+		return nil, err
+	}
+
+	if fileHeader.Size > business.MAX_LOGO_SIZE {
+		return nil, fmt.Errorf("logo must not exceed %d bytes", business.MAX_LOGO_SIZE)
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return io.ReadAll(io.LimitReader(file, business.MAX_LOGO_SIZE))
+}
+
+// orgETag is the strong entity tag of an organization version.
+func orgETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// ifMatchVersion returns the organization version required by the If-Match
+// header, or 0 when the request has no precondition.
+func ifMatchVersion(c *gin.Context) (int, error) {
+	ifMatch := strings.TrimSpace(c.GetHeader("If-Match"))
+	if ifMatch == "" || ifMatch == "*" {
+		return 0, nil
+	}
+
+	version, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(ifMatch, `"`), `"`))
+	if err != nil || version <= 0 || !strings.HasPrefix(ifMatch, `"`) {
+		return 0, database.ErrOrgModified
+	}
+
+	return version, nil
+}
+
+func readOrgResp(org types.Org) types.ReadOrgResp {
+	readOrgResp := types.ReadOrgResp{
+		OrgId:       org.OrgId,
+		Slug:        org.Slug,
+		LogoURLs:    business.LogoURLs(org.Logo),
+		Tags:        append([]string{}, org.Tags...),
+		ParentId:    org.ParentId,
+		Name:        org.Name,
+		Description: org.Description,
+		Visibility:  org.Visibility,
+		Settings: types.OrgSettingsResp{
+			Timezone:          org.Timezone,
+			Locale:            org.Locale,
+			LogoURL:           org.LogoURL,
+			DefaultMemberRole: org.DefaultMemberRole,
+			Visibility:        org.Visibility,
+		},
+		MetadataFields: []types.MetadataFieldResp{},
+		Metadata:       org.Metadata,
+		Teams:          []types.TeamResp{},
+	}
+
+	if readOrgResp.Visibility == "" {
+		readOrgResp.Visibility = types.ORG_VISIBILITY_PRIVATE
+		readOrgResp.Settings.Visibility = types.ORG_VISIBILITY_PRIVATE
+	}
+
+	if readOrgResp.Settings.DefaultMemberRole == "" {
+		readOrgResp.Settings.DefaultMemberRole = types.ACCESS_LEVEL_USER
+	}
+
+	if readOrgResp.Metadata == nil {
+		readOrgResp.Metadata = map[string]interface{}{}
+	}
+
+	for _, field := range org.MetadataFields {
+		readOrgResp.MetadataFields = append(readOrgResp.MetadataFields, types.MetadataFieldResp{
+			Key:  field.Key,
+			Type: field.Type,
+		})
+	}
+
+	for _, team := range org.Teams {
+		readOrgResp.Teams = append(readOrgResp.Teams, teamResp(team))
+	}
+
+	for _, member := range org.OrgMembers {
+		readOrgResp.OrgMembers = append(readOrgResp.OrgMembers, types.OrgMemberResp{
+			Name:        member.Name,
+			Email:       member.Email,
+			AccessLevel: member.AccessLevel,
+		})
+	}
+
+	return readOrgResp
+}
+
+func teamResp(team types.Team) types.TeamResp {
+	teamResp := types.TeamResp{
+		TeamId:      team.TeamId,
+		Name:        team.Name,
+		Description: team.Description,
+		Members:     []types.TeamMemberResp{},
+	}
+
+	for _, member := range team.Members {
+		teamResp.Members = append(teamResp.Members, types.TeamMemberResp{
+			Name:  member.Name,
+			Email: member.Email,
+			Role:  member.Role,
+		})
+	}
+
+	return teamResp
+}
+
+func projectResp(project types.Project) types.ProjectResp {
+	projectResp := types.ProjectResp{
+		ProjectId:   project.ProjectId,
+		Name:        project.Name,
+		Description: project.Description,
+		Members:     []types.ProjectMemberResp{},
+	}
+
+	for _, member := range project.Members {
+		projectResp.Members = append(projectResp.Members, types.ProjectMemberResp{
+			Name:        member.Name,
+			Email:       member.Email,
+			AccessLevel: member.AccessLevel,
+		})
+	}
+
+	return projectResp
+}
+
+func inviteLinkResp(link types.InviteLink) types.InviteLinkResp {
+	return types.InviteLinkResp{
+		Code:        link.Code,
+		OrgId:       link.OrgId,
+		AccessLevel: link.AccessLevel,
+		MaxUses:     link.MaxUses,
+		Uses:        link.Uses,
+		ExpiresAt:   link.ExpiresAt,
+	}
+}
+
+// parseImportCSV reads "user_email,access_level" records, the header line and
+// the access level column are both optional.
+func parseImportCSV(body io.Reader) ([]types.ImportMemberReq, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) > 0 && len(records[0]) > 0 && records[0][0] == "user_email" {
+		records = records[1:]
+	}
+
+	importMemberReqs := make([]types.ImportMemberReq, len(records))
+	for i, record := range records {
+		if len(record) > 0 {
+			importMemberReqs[i].Email = record[0]
+		}
+		if len(record) > 1 {
+			importMemberReqs[i].AccessLevel = record[1]
+		}
+	}
+
+	return importMemberReqs, nil
+}
+
+func orgDomainResp(orgDomain types.OrgDomain) types.OrgDomainResp {
+	return types.OrgDomainResp{
+		Domain:             orgDomain.Domain,
+		AccessLevel:        orgDomain.AccessLevel,
+		Verified:           orgDomain.Verified,
+		VerificationRecord: domain.VerificationRecord(orgDomain.Token),
+	}
+}
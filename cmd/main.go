@@ -1,25 +1,179 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
 	"github.com/gin-gonic/gin"
+	"github.com/zaher1307/IDEANEST-project-assignment/internal/auth"
+	"github.com/zaher1307/IDEANEST-project-assignment/internal/blob"
+	"github.com/zaher1307/IDEANEST-project-assignment/internal/business"
+	"github.com/zaher1307/IDEANEST-project-assignment/internal/database"
+	"github.com/zaher1307/IDEANEST-project-assignment/internal/mailer"
 )
 
 func main() {
+	defaultStorage := os.Getenv("STORAGE")
+	if defaultStorage == "" {
+		defaultStorage = "mongo"
+	}
+
+	storage := flag.String("storage", defaultStorage, `storage backend, "mongo", "postgres", "sqlite" or "memory", defaults to $STORAGE`)
+	flag.Parse()
+
+	if flag.Arg(0) == "migrate" {
+		err := runMigrate(*storage, flag.Args()[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	ctx := context.Background()
+
+	store, err := database.Open(ctx, *storage)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+
+	if migrator, ok := store.(database.Migrator); ok {
+		err = checkMigrations(ctx, migrator)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	business.SetRepositories(store, store)
+
+	blobStore, err := blobStoreFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	business.SetBlobStore(blobStore)
+
+	// the memory and sqlite storages run without any external service
+	switch store := store.(type) {
+	case *database.Memory:
+		auth.SetTokenStore(auth.NewMemoryTokenStore())
+	case *database.SQLite:
+		auth.SetTokenStore(auth.NewSQLiteTokenStore(store.DB()))
+	}
+
+	// without an SMTP server the mails are only logged
+	if host := os.Getenv("SMTP_HOST"); host != "" {
+		business.SetMailSender(mailer.NewSMTPSender(host, os.Getenv("SMTP_PORT"),
+			os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), os.Getenv("MAIL_FROM")))
+	}
+
 	r := gin.Default()
 
+	business.StartOrgPurger(ctx)
+
+	setupRoutes(r)
+
+	r.Run(":8080")
+}
+
+func setupRoutes(r *gin.Engine) {
 	r.POST("/signup", SignUpHandler)
 	r.POST("/signin", SignInHandler)
 	r.POST("/refresh-token", RefreshTokenHandler)
+	// the mailed token authenticates the request
+	r.POST("/user/email/verify", VerifyEmailHandler)
+
+	// blobs of the local store are public, like the objects of an S3 bucket
+	if store, ok := business.BlobStore().(*blob.LocalStore); ok {
+		r.Static(store.BaseURL, store.Dir)
+	}
 
 	r.Use(AuthMiddleware())
+	r.Use(OrgIdMiddleware())
 
+	r.PUT("/user/email", ChangeEmailHandler)
 	r.POST("/organization", CreateOrgHandler)
 	r.GET("/organization/:organization_id", ReadOrgHandler)
 	r.GET("/organization", ReadAllOrgsHandler)
+	r.GET("/organizations/discover", DiscoverOrgsHandler)
 	r.PUT("/organization/:organization_id", UpdateOrgHandler)
 	r.DELETE("/organization/:organization_id", DeleteOrgHandler)
+	r.POST("/organization/:organization_id/restore", RestoreOrgHandler)
+	r.POST("/organization/:organization_id/children", CreateChildOrgHandler)
+	r.PUT("/organization/:organization_id/parent", MoveOrgHandler)
+	r.GET("/organization/:organization_id/ancestors", ReadOrgAncestorsHandler)
+	r.GET("/organization/:organization_id/descendants", ReadOrgDescendantsHandler)
+	r.PUT("/organization/:organization_id/visibility", UpdateOrgVisibilityHandler)
+	r.PUT("/organization/:organization_id/logo", UploadOrgLogoHandler)
+	r.POST("/organization/:organization_id/tags", AddOrgTagsHandler)
+	r.DELETE("/organization/:organization_id/tags/:tag", RemoveOrgTagHandler)
+	r.DELETE("/organization/:organization_id/logo", DeleteOrgLogoHandler)
+	r.POST("/organization/:organization_id/join-requests", RequestToJoinOrgHandler)
+	r.GET("/organization/:organization_id/join-requests", ReadJoinRequestsHandler)
+	r.POST("/organization/:organization_id/join-requests/:request_id/approve", ApproveJoinRequestHandler)
+	r.POST("/organization/:organization_id/join-requests/:request_id/reject", RejectJoinRequestHandler)
+	r.POST("/organization/:organization_id/domains", AddOrgDomainHandler)
+	r.GET("/organization/:organization_id/domains", ReadOrgDomainsHandler)
+	r.POST("/organization/:organization_id/domains/:domain/verify", VerifyOrgDomainHandler)
+	r.DELETE("/organization/:organization_id/domains/:domain", RemoveOrgDomainHandler)
+	r.PUT("/organization/:organization_id/settings", UpdateOrgSettingsHandler)
+	r.PUT("/organization/:organization_id/metadata/fields", UpdateOrgMetadataFieldsHandler)
+	r.PUT("/organization/:organization_id/metadata", UpdateOrgMetadataHandler)
+	r.PUT("/organization/:organization_id/quota", UpdateOrgQuotaHandler)
+	r.GET("/organization/:organization_id/usage", ReadOrgUsageHandler)
 	r.POST("/organization/:organization_id/invite", InviteUserToOrgHandler)
+	r.GET("/organization/:organization_id/members", ReadOrgMembersHandler)
+	r.DELETE("/organization/:organization_id/members/:user_email", RemoveUserFromOrgHandler)
+	r.POST("/organization/:organization_id/members/import", ImportMembersHandler)
+	r.POST("/organization/:organization_id/invite-links", CreateInviteLinkHandler)
+	r.GET("/organization/:organization_id/invite-links", ReadInviteLinksHandler)
+	r.DELETE("/organization/:organization_id/invite-links/:code", RevokeInviteLinkHandler)
+	r.POST("/join/:code", JoinOrgHandler)
+	r.POST("/organization/:organization_id/teams", CreateTeamHandler)
+	r.GET("/organization/:organization_id/teams", ReadTeamsHandler)
+	r.GET("/organization/:organization_id/teams/:team_id", ReadTeamHandler)
+	r.PUT("/organization/:organization_id/teams/:team_id", UpdateTeamHandler)
+	r.DELETE("/organization/:organization_id/teams/:team_id", DeleteTeamHandler)
+	r.POST("/organization/:organization_id/teams/:team_id/members", AddTeamMemberHandler)
+	r.DELETE("/organization/:organization_id/teams/:team_id/members/:user_email", RemoveTeamMemberHandler)
+	r.POST("/organization/:organization_id/projects", CreateProjectHandler)
+	r.GET("/organization/:organization_id/projects", ReadProjectsHandler)
+	r.GET("/organization/:organization_id/projects/:project_id", ReadProjectHandler)
+	r.PUT("/organization/:organization_id/projects/:project_id", UpdateProjectHandler)
+	r.DELETE("/organization/:organization_id/projects/:project_id", DeleteProjectHandler)
+	r.PUT("/organization/:organization_id/projects/:project_id/members", SetProjectMemberHandler)
+	r.DELETE("/organization/:organization_id/projects/:project_id/members/:user_email", RemoveProjectMemberHandler)
 	r.POST("/revoke-refresh-token", RevokeRefreshTokenHandler)
+}
 
-	r.Run(":8080")
+// ====================== helper private function ====================== //
+
+// blobStoreFromEnv builds the store selected by BLOB_STORAGE, "local" (the
+// default) keeps blobs under BLOB_LOCAL_DIR and "s3" in an S3 compatible bucket.
+func blobStoreFromEnv() (blob.Store, error) {
+	switch os.Getenv("BLOB_STORAGE") {
+	case "", "local":
+		dir := os.Getenv("BLOB_LOCAL_DIR")
+		if dir == "" {
+			dir = "blobs"
+		}
+		baseURL := os.Getenv("BLOB_BASE_URL")
+		if baseURL == "" {
+			baseURL = "/blobs"
+		}
+		return blob.NewLocalStore(dir, baseURL)
+	case "s3":
+		return blob.NewS3Store(
+			os.Getenv("S3_ENDPOINT"),
+			os.Getenv("S3_ACCESS_KEY"),
+			os.Getenv("S3_SECRET_KEY"),
+			os.Getenv("S3_BUCKET"),
+			os.Getenv("S3_USE_SSL") == "true",
+			os.Getenv("S3_PUBLIC_URL"),
+		)
+	default:
+		return nil, fmt.Errorf("unknown blob storage %q", os.Getenv("BLOB_STORAGE"))
+	}
 }
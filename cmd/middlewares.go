@@ -6,6 +6,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/zaher1307/IDEANEST-project-assignment/internal/auth"
+	"github.com/zaher1307/IDEANEST-project-assignment/internal/business"
 	"github.com/zaher1307/IDEANEST-project-assignment/internal/types"
 )
 
@@ -47,3 +48,42 @@ func AuthMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// OrgIdMiddleware lets every organization route take either the organization
+// ID or its slug, previous slugs are redirected to the current one on reads.
+func OrgIdMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idOrSlug := c.Param("organization_id")
+		if idOrSlug == "" {
+			c.Next()
+			return
+		}
+
+		orgId, currentSlug, err := business.ResolveOrg(c.Request.Context(), idOrSlug)
+		if err != nil {
+			c.JSON(http.StatusOK, types.MessageResp{
+				Message: "Faild: " + err.Error(),
+			})
+			c.Abort()
+			return
+		}
+
+		if currentSlug != "" && c.Request.Method == http.MethodGet {
+			location := strings.Replace(c.Request.URL.Path, "/"+idOrSlug, "/"+currentSlug, 1)
+			if c.Request.URL.RawQuery != "" {
+				location += "?" + c.Request.URL.RawQuery
+			}
+			c.Redirect(http.StatusMovedPermanently, location)
+			c.Abort()
+			return
+		}
+
+		for i := range c.Params {
+			if c.Params[i].Key == "organization_id" {
+				c.Params[i].Value = orgId
+			}
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zaher1307/IDEANEST-project-assignment/internal/database"
+)
+
+const migrateUsage = "usage: migrate status | up | down | to <version>"
+
+// MIGRATION_TIMEOUT bounds a whole migrate run, it replaces the per-operation
+// timeout of the server since a migration may rewrite entire collections.
+const MIGRATION_TIMEOUT = time.Hour
+
+// runMigrate runs the migrate command against the storage backend configured
+// through the environment, the server itself never migrates the schema.
+func runMigrate(storage string, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), MIGRATION_TIMEOUT)
+	defer cancel()
+
+	backend, err := database.Open(ctx, storage)
+	if err != nil {
+		return err
+	}
+	defer backend.Close()
+
+	store, ok := backend.(database.Migrator)
+	if !ok {
+		return fmt.Errorf("the %s storage has no migrations", storage)
+	}
+
+	switch args[0] {
+	case "status":
+		return printMigrationStatus(ctx, store)
+	case "up":
+		return store.MigrateUp(ctx)
+	case "down":
+		return store.MigrateDown(ctx)
+	case "to":
+		if len(args) != 2 {
+			return errors.New(migrateUsage)
+		}
+
+		version, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid migration version %q", args[1])
+		}
+
+		return store.MigrateTo(ctx, version)
+	default:
+		return errors.New(migrateUsage)
+	}
+}
+
+// checkMigrations returns an error while a migration of the storage is still
+// pending, the server never runs against a schema older than its code.
+func checkMigrations(ctx context.Context, store database.Migrator) error {
+	statuses, err := store.MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			return fmt.Errorf("migration %d %s is pending, run the migrate up command before starting the server", status.Version, status.Name)
+		}
+	}
+
+	return nil
+}
+
+func printMigrationStatus(ctx context.Context, store database.Migrator) error {
+	statuses, err := store.MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+	for _, status := range statuses {
+		appliedAt := "pending"
+		if status.AppliedAt != nil {
+			appliedAt = status.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+	}
+
+	return w.Flush()
+}
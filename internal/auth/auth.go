@@ -6,30 +6,45 @@ import (
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
-	"github.com/go-redis/redis"
 	"github.com/google/uuid"
 	"github.com/zaher1307/IDEANEST-project-assignment/internal/types"
 )
 
+// EMAIL_TOKEN_EXPIRATION bounds how long a mailed verification token is valid.
+const EMAIL_TOKEN_EXPIRATION = 24 * time.Hour
+
 type Claims struct {
 	Email string `json:"email"`
 	jwt.StandardClaims
 }
 
+// EmailClaims are mailed to NewEmail, holding them proves that the user of
+// Email receives the mails of NewEmail. Both are the same when an account
+// verifies the email it signed up with.
+type EmailClaims struct {
+	Email    string `json:"email"`
+	NewEmail string `json:"new_email"`
+	jwt.StandardClaims
+}
+
 var (
-	accessSecret  string
-	refreshSecret string
-	redisHost     string
-	redisClient   *redis.Client
+	accessSecret       string
+	refreshSecret      string
+	verificationSecret string
+	tokenStore         TokenStore
 )
 
 func init() {
 	accessSecret = os.Getenv("ACCESS_SECRET")
 	refreshSecret = os.Getenv("REFRESH_SECRET")
-	redisHost = os.Getenv("REDIS_HOST")
-	redisClient = redis.NewClient(&redis.Options{
-		Addr: os.Getenv("REDIS_HOST") + ":6379",
-	})
+	verificationSecret = os.Getenv("VERIFICATION_SECRET")
+	tokenStore = NewRedisTokenStore(os.Getenv("REDIS_HOST") + ":6379")
+}
+
+// SetTokenStore replaces the Redis token store, it must be called before
+// issuing any token.
+func SetTokenStore(store TokenStore) {
+	tokenStore = store
 }
 
 func GenerateAccessToken(refreshToken string) (string, error) {
@@ -38,15 +53,16 @@ func GenerateAccessToken(refreshToken string) (string, error) {
 
 func GenerateRefreshToken(user types.User) (string, error) {
 	refreshToken := uuid.New().String()
-	err := redisClient.Set(refreshToken, user.Email, 0).Err()
+	err := tokenStore.Save(refreshToken, user.Email)
 	if err != nil {
 		return "", err
 	}
+
 	return refreshToken, nil
 }
 
 func GetRefreshTokenUserEmail(refreshToken string) (string, error) {
-	return redisClient.Get(refreshToken).Result()
+	return tokenStore.Email(refreshToken)
 }
 
 func ValidateAccessToken(accessToken string) (string, error) {
@@ -67,18 +83,52 @@ func ValidateAccessToken(accessToken string) (string, error) {
 }
 
 func RevokeRefreshToken(token string) error {
-	err := redisClient.Del(token).Err()
+	return tokenStore.Delete(token)
+}
+
+// RevokeUserRefreshTokens revokes every refresh token issued to the email,
+// it is used when the email of a user changes.
+func RevokeUserRefreshTokens(email string) error {
+	return tokenStore.DeleteUserTokens(email)
+}
+
+// GenerateEmailToken signs the token mailed to newEmail to verify it.
+func GenerateEmailToken(email, newEmail string) (string, error) {
+	claims := EmailClaims{
+		Email:    email,
+		NewEmail: newEmail,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(EMAIL_TOKEN_EXPIRATION).Unix(),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(verificationSecret))
+}
+
+// ValidateEmailToken returns the email of the account and the email it
+// verified.
+func ValidateEmailToken(emailToken string) (string, string, error) {
+	token, err := jwt.ParseWithClaims(emailToken, &EmailClaims{}, func(token *jwt.Token) (interface{}, error) {
+		return []byte(verificationSecret), nil
+	})
+
 	if err != nil {
-		return err
+		return "", "", err
+	}
+
+	claims, ok := token.Claims.(*EmailClaims)
+	if !ok || !token.Valid || claims.NewEmail == "" {
+		return "", "", errors.New("invalid token claims")
 	}
 
-	return nil
+	return claims.Email, claims.NewEmail, nil
 }
 
 // ======================== helper util function ======================== //
 
 func generateToken(refreshToken string, secretKey string, expiration time.Duration) (string, error) {
-	email, err := redisClient.Get(refreshToken).Result()
+	email, err := tokenStore.Email(refreshToken)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/zaher1307/IDEANEST-project-assignment/internal/types"
+)
+
+func TestRefreshTokens(t *testing.T) {
+	SetTokenStore(NewMemoryTokenStore())
+
+	refreshToken, err := GenerateRefreshToken(types.User{UserInfo: types.UserInfo{Email: "user@a.b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accessToken, err := GenerateAccessToken(refreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if email, err := ValidateAccessToken(accessToken); err != nil || email != "user@a.b" {
+		t.Errorf("Expected user@a.b but got %s, %v", email, err)
+	}
+
+	if err := RevokeUserRefreshTokens("user@a.b"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := GenerateAccessToken(refreshToken); err == nil {
+		t.Errorf("Expected a revoked refresh token to be rejected")
+	}
+}
+
+func TestEmailTokens(t *testing.T) {
+	emailToken, err := GenerateEmailToken("user@a.b", "new@a.b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	email, newEmail, err := ValidateEmailToken(emailToken)
+	if err != nil || email != "user@a.b" || newEmail != "new@a.b" {
+		t.Errorf("Expected user@a.b and new@a.b but got %s, %s, %v", email, newEmail, err)
+	}
+
+	if _, _, err := ValidateEmailToken(emailToken + "x"); err == nil {
+		t.Errorf("Expected a tampered token to be rejected")
+	}
+}
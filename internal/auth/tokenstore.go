@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+
+	"github.com/go-redis/redis"
+)
+
+var ErrTokenNotFound = errors.New("refresh token doesn't exists")
+
+// TokenStore keeps the issued refresh tokens and the email each one belongs to.
+type TokenStore interface {
+	Save(token, email string) error
+	// Email returns ErrTokenNotFound when the token is unknown or revoked.
+	Email(token string) (string, error)
+	Delete(token string) error
+	DeleteUserTokens(email string) error
+}
+
+// RedisTokenStore keeps the tokens in Redis, with a set per user to find every
+// token issued to them.
+type RedisTokenStore struct {
+	client *redis.Client
+}
+
+func NewRedisTokenStore(addr string) *RedisTokenStore {
+	return &RedisTokenStore{
+		client: redis.NewClient(&redis.Options{
+			Addr: addr,
+		}),
+	}
+}
+
+func (s *RedisTokenStore) Save(token, email string) error {
+	err := s.client.Set(token, email, 0).Err()
+	if err != nil {
+		return err
+	}
+
+	return s.client.SAdd(userTokensKey(email), token).Err()
+}
+
+func (s *RedisTokenStore) Email(token string) (string, error) {
+	email, err := s.client.Get(token).Result()
+	if err == redis.Nil {
+		return "", ErrTokenNotFound
+	}
+
+	return email, err
+}
+
+func (s *RedisTokenStore) Delete(token string) error {
+	email, err := s.Email(token)
+	if err != nil {
+		return err
+	}
+
+	err = s.client.Del(token).Err()
+	if err != nil {
+		return err
+	}
+
+	return s.client.SRem(userTokensKey(email), token).Err()
+}
+
+func (s *RedisTokenStore) DeleteUserTokens(email string) error {
+	tokens, err := s.client.SMembers(userTokensKey(email)).Result()
+	if err != nil {
+		return err
+	}
+
+	if len(tokens) > 0 {
+		err = s.client.Del(tokens...).Err()
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.client.Del(userTokensKey(email)).Err()
+}
+
+// MemoryTokenStore keeps the tokens in process memory, it pairs with the
+// in-memory storage for tests and local development.
+type MemoryTokenStore struct {
+	mu     sync.Mutex
+	emails map[string]string
+}
+
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{
+		emails: make(map[string]string),
+	}
+}
+
+func (s *MemoryTokenStore) Save(token, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.emails[token] = email
+
+	return nil
+}
+
+func (s *MemoryTokenStore) Email(token string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	email, ok := s.emails[token]
+	if !ok {
+		return "", ErrTokenNotFound
+	}
+
+	return email, nil
+}
+
+func (s *MemoryTokenStore) Delete(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.emails[token]; !ok {
+		return ErrTokenNotFound
+	}
+	delete(s.emails, token)
+
+	return nil
+}
+
+func (s *MemoryTokenStore) DeleteUserTokens(email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for token, tokenEmail := range s.emails {
+		if tokenEmail == email {
+			delete(s.emails, token)
+		}
+	}
+
+	return nil
+}
+
+// SQLiteTokenStore keeps the tokens in the refresh_tokens table of the sqlite
+// storage, created by its migrations, so a single data file holds everything.
+type SQLiteTokenStore struct {
+	db *sql.DB
+}
+
+func NewSQLiteTokenStore(db *sql.DB) *SQLiteTokenStore {
+	return &SQLiteTokenStore{
+		db: db,
+	}
+}
+
+func (s *SQLiteTokenStore) Save(token, email string) error {
+	_, err := s.db.Exec(`INSERT INTO refresh_tokens (token, email) VALUES ($1, $2)
+		ON CONFLICT (token) DO UPDATE SET email = excluded.email`, token, email)
+
+	return err
+}
+
+func (s *SQLiteTokenStore) Email(token string) (string, error) {
+	var email string
+	err := s.db.QueryRow(`SELECT email FROM refresh_tokens WHERE token = $1`, token).Scan(&email)
+	if err == sql.ErrNoRows {
+		return "", ErrTokenNotFound
+	}
+
+	return email, err
+}
+
+func (s *SQLiteTokenStore) Delete(token string) error {
+	result, err := s.db.Exec(`DELETE FROM refresh_tokens WHERE token = $1`, token)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return ErrTokenNotFound
+	}
+
+	return nil
+}
+
+func (s *SQLiteTokenStore) DeleteUserTokens(email string) error {
+	_, err := s.db.Exec(`DELETE FROM refresh_tokens WHERE email = $1`, email)
+
+	return err
+}
+
+func userTokensKey(email string) string {
+	return "user_refresh_tokens:" + email
+}
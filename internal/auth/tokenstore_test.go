@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/zaher1307/IDEANEST-project-assignment/internal/database"
+)
+
+func TestMemoryTokenStore(t *testing.T) {
+	testTokenStore(t, NewMemoryTokenStore())
+}
+
+// TestSQLiteTokenStore runs the same checks against the refresh_tokens table
+// created by the migrations of the sqlite storage.
+func TestSQLiteTokenStore(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := database.NewSQLite(ctx, filepath.Join(t.TempDir(), "ideanest.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := store.MigrateUp(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	testTokenStore(t, NewSQLiteTokenStore(store.DB()))
+}
+
+// testTokenStore checks the behaviour the auth functions rely on.
+func testTokenStore(t *testing.T, store TokenStore) {
+	for token, email := range map[string]string{"first": "user@a.b", "second": "user@a.b", "other": "other@a.b"} {
+		if err := store.Save(token, email); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if email, err := store.Email("first"); err != nil || email != "user@a.b" {
+		t.Errorf("Expected user@a.b but got %s, %v", email, err)
+	}
+
+	if _, err := store.Email("missing"); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("Expected ErrTokenNotFound for an unknown token but got %v", err)
+	}
+
+	if err := store.Save("other", "renamed@a.b"); err != nil {
+		t.Fatal(err)
+	}
+
+	if email, _ := store.Email("other"); email != "renamed@a.b" {
+		t.Errorf("Expected a saved token to be replaced but got %s", email)
+	}
+
+	if err := store.Delete("first"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Email("first"); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("Expected a deleted token to be gone but got %v", err)
+	}
+
+	if err := store.Delete("first"); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("Expected ErrTokenNotFound when deleting twice but got %v", err)
+	}
+
+	if err := store.DeleteUserTokens("user@a.b"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Email("second"); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("Expected every token of the user to be deleted but got %v", err)
+	}
+
+	if email, err := store.Email("other"); err != nil || email != "renamed@a.b" {
+		t.Errorf("Expected the tokens of other users to stay but got %s, %v", email, err)
+	}
+}
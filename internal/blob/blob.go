@@ -0,0 +1,180 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// Store keeps binary objects under slash separated keys and exposes them
+// through public URLs, it is implemented by LocalStore and S3Store.
+type Store interface {
+	Put(key, contentType string, data []byte) error
+	Delete(key string) error
+	URL(key string) string
+}
+
+// LocalStore keeps objects as files under Dir, the server is expected to
+// serve Dir under BaseURL.
+type LocalStore struct {
+	Dir     string
+	BaseURL string
+}
+
+func NewLocalStore(dir, baseURL string) (*LocalStore, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LocalStore{Dir: dir, BaseURL: strings.TrimRight(baseURL, "/")}, nil
+}
+
+// Put writes the object to a temporary file first and renames it, so readers
+// never see a partially written object.
+func (s *LocalStore) Put(key, contentType string, data []byte) error {
+	filePath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(filePath), 0o755)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filePath)
+}
+
+func (s *LocalStore) Delete(key string) error {
+	filePath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+func (s *LocalStore) URL(key string) string {
+	return s.BaseURL + "/" + key
+}
+
+func (s *LocalStore) path(key string) (string, error) {
+	if !validKey(key) {
+		return "", errors.New("invalid blob key")
+	}
+
+	return filepath.Join(s.Dir, filepath.FromSlash(key)), nil
+}
+
+// publicReadPolicy lets anyone read, but not list, the objects of the bucket.
+const publicReadPolicy = `{"Version":"2012-10-17","Statement":[{"Effect":"Allow",` +
+	`"Principal":{"AWS":["*"]},"Action":["s3:GetObject"],"Resource":["arn:aws:s3:::%s/*"]}]}`
+
+// S3Store keeps objects in a bucket of any S3 compatible storage (AWS S3,
+// MinIO...), objects are expected to be publicly readable under PublicURL.
+type S3Store struct {
+	client    *minio.Client
+	Bucket    string
+	PublicURL string
+}
+
+// NewS3Store connects to the storage and creates the bucket if it doesn't
+// exist yet. An empty publicURL defaults to the bucket URL on the endpoint and
+// the bucket is then given a policy letting anyone read its objects, a
+// publicURL in front of the bucket (a CDN...) is expected to grant the reads
+// itself.
+func NewS3Store(endpoint, accessKey, secretKey, bucket string, useSSL bool, publicURL string) (*S3Store, error) {
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
+		Secure: useSSL,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	exists, err := client.BucketExists(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		err = client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if publicURL == "" {
+		err = client.SetBucketPolicy(ctx, bucket, fmt.Sprintf(publicReadPolicy, bucket))
+		if err != nil {
+			return nil, err
+		}
+
+		publicURL = client.EndpointURL().String() + "/" + bucket
+	}
+
+	return &S3Store{
+		client:    client,
+		Bucket:    bucket,
+		PublicURL: strings.TrimRight(publicURL, "/"),
+	}, nil
+}
+
+func (s *S3Store) Put(key, contentType string, data []byte) error {
+	if !validKey(key) {
+		return errors.New("invalid blob key")
+	}
+
+	_, err := s.client.PutObject(context.Background(), s.Bucket, key,
+		bytes.NewReader(data), int64(len(data)),
+		minio.PutObjectOptions{ContentType: contentType})
+
+	return err
+}
+
+func (s *S3Store) Delete(key string) error {
+	if !validKey(key) {
+		return errors.New("invalid blob key")
+	}
+
+	return s.client.RemoveObject(context.Background(), s.Bucket, key, minio.RemoveObjectOptions{})
+}
+
+func (s *S3Store) URL(key string) string {
+	return s.PublicURL + "/" + key
+}
+
+// validKey rejects keys that would escape the store, e.g. "../secret".
+func validKey(key string) bool {
+	return key != "" && !strings.HasPrefix(key, "/") && path.Clean(key) == key &&
+		key != ".." && !strings.HasPrefix(key, "../")
+}
@@ -0,0 +1,219 @@
+package blob
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestLocalStore(t *testing.T) {
+	store, err := NewLocalStore(t.TempDir(), "/blobs/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testStore(t, store, func(key string) (string, bool) {
+		data, err := os.ReadFile(store.Dir + "/" + key)
+		return string(data), err == nil
+	})
+
+	if err := store.Put("../outside", "text/plain", []byte("data")); err == nil {
+		t.Errorf("Expected key escaping the store to be rejected")
+	}
+}
+
+// TestS3Store runs against the in-memory fakeS3 below, or against a real
+// MinIO when BLOB_TEST_S3_ENDPOINT is set, e.g.
+//
+//	docker run -p 9000:9000 minio/minio server /data
+//	BLOB_TEST_S3_ENDPOINT=localhost:9000 go test ./internal/blob
+func TestS3Store(t *testing.T) {
+	endpoint := os.Getenv("BLOB_TEST_S3_ENDPOINT")
+	accessKey, secretKey := "minioadmin", "minioadmin"
+	if endpoint == "" {
+		server := httptest.NewServer(&fakeS3{objects: map[string][]byte{}})
+		defer server.Close()
+		endpoint = strings.TrimPrefix(server.URL, "http://")
+	}
+
+	store, err := NewS3Store(endpoint, accessKey, secretKey, "ideanest-test", false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testStore(t, store, func(key string) (string, bool) {
+		resp, err := http.Get(store.URL(key))
+		if err != nil || resp.StatusCode != http.StatusOK {
+			return "", false
+		}
+		defer resp.Body.Close()
+
+		data, err := io.ReadAll(resp.Body)
+		return string(data), err == nil
+	})
+
+	// behind a public URL the bucket is left private, the URL grants the reads
+	private, err := NewS3Store(endpoint, accessKey, secretKey, "ideanest-test-private", false, "https://cdn.a.b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := private.Put("logo.png", "image/png", []byte("logo")); err != nil {
+		t.Fatal(err)
+	}
+	defer private.Delete("logo.png")
+
+	resp, err := http.Get(private.client.EndpointURL().String() + "/ideanest-test-private/logo.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected anonymous reads of a private bucket to be forbidden but got %d", resp.StatusCode)
+	}
+}
+
+func testStore(t *testing.T, store Store, read func(key string) (string, bool)) {
+	key := "orgs/1/logo.png"
+	if err := store.Put(key, "image/png", []byte("logo")); err != nil {
+		t.Fatalf("Expected object to be stored but got %s", err)
+	}
+
+	if !strings.HasSuffix(store.URL(key), "/"+key) {
+		t.Errorf("Expected URL of %s to end with the key but got %s", key, store.URL(key))
+	}
+
+	if data, ok := read(key); !ok || data != "logo" {
+		t.Errorf("Expected stored object to be %q but got %q", "logo", data)
+	}
+
+	if err := store.Delete(key); err != nil {
+		t.Fatalf("Expected object to be deleted but got %s", err)
+	}
+
+	if _, ok := read(key); ok {
+		t.Errorf("Expected deleted object to be gone")
+	}
+
+	if err := store.Delete(key); err != nil {
+		t.Errorf("Expected deleting a missing object to succeed but got %s", err)
+	}
+}
+
+// fakeS3 is a minimal stand-in for MinIO that understands the few requests
+// S3Store makes. Like a real bucket, objects are only served to anonymous
+// requests once the bucket policy allows everyone to get them.
+type fakeS3 struct {
+	mu       sync.Mutex
+	buckets  map[string]bool
+	policies map[string]string
+	objects  map[string][]byte
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.buckets == nil {
+		s.buckets = map[string]bool{}
+		s.policies = map[string]string{}
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucket, key, _ := strings.Cut(path, "/")
+
+	switch {
+	case r.URL.Query().Has("location"):
+		io.WriteString(w, `<LocationConstraint xmlns="http://s3.amazonaws.com/doc/2006-03-01/">us-east-1</LocationConstraint>`)
+	case r.URL.Query().Has("policy") && r.Method == http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		s.policies[bucket] = string(data)
+		w.WriteHeader(http.StatusNoContent)
+	case key == "" && r.Method == http.MethodHead:
+		if !s.buckets[bucket] {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case key == "" && r.Method == http.MethodPut:
+		s.buckets[bucket] = true
+	case r.Method == http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		if strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+			data = decodeAWSChunked(data)
+		}
+		s.objects[path] = data
+		w.Header().Set("ETag", `"etag"`)
+	case r.Method == http.MethodGet:
+		if r.Header.Get("Authorization") == "" && !s.publicRead(bucket) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		data, ok := s.objects[path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	case r.Method == http.MethodDelete:
+		delete(s.objects, path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+// publicRead tells whether the policy of the bucket lets anyone get its objects.
+func (s *fakeS3) publicRead(bucket string) bool {
+	var policy struct {
+		Statement []struct {
+			Effect    string
+			Principal struct{ AWS []string }
+			Action    []string
+			Resource  []string
+		}
+	}
+
+	if json.Unmarshal([]byte(s.policies[bucket]), &policy) != nil {
+		return false
+	}
+
+	for _, statement := range policy.Statement {
+		if statement.Effect == "Allow" && slices.Contains(statement.Principal.AWS, "*") &&
+			slices.Contains(statement.Action, "s3:GetObject") &&
+			slices.Contains(statement.Resource, "arn:aws:s3:::"+bucket+"/*") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// decodeAWSChunked strips the chunk headers ("<size>;chunk-signature=...")
+// that S3 clients add around the payload of streaming uploads.
+func decodeAWSChunked(body []byte) []byte {
+	var data []byte
+	rest := string(body)
+	for {
+		header, after, ok := strings.Cut(rest, "\r\n")
+		if !ok {
+			return data
+		}
+
+		sizeHex, _, _ := strings.Cut(header, ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil || size == 0 || int(size) > len(after) {
+			return data
+		}
+
+		data = append(data, after[:size]...)
+		rest = strings.TrimPrefix(after[size:], "\r\n")
+	}
+}
@@ -1,43 +1,213 @@
 package business
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
+	"net/mail"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata"
 
+	"github.com/google/uuid"
 	"github.com/zaher1307/IDEANEST-project-assignment/internal/auth"
+	"github.com/zaher1307/IDEANEST-project-assignment/internal/blob"
 	"github.com/zaher1307/IDEANEST-project-assignment/internal/database"
+	"github.com/zaher1307/IDEANEST-project-assignment/internal/domain"
+	"github.com/zaher1307/IDEANEST-project-assignment/internal/imaging"
+	"github.com/zaher1307/IDEANEST-project-assignment/internal/mailer"
 	"github.com/zaher1307/IDEANEST-project-assignment/internal/types"
 	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/text/language"
+)
+
+const (
+	// MAX_IMPORT_ROWS bounds a single bulk import request.
+	MAX_IMPORT_ROWS = 1000
+	// MAX_DISCOVER_RESULTS bounds a single discover search.
+	MAX_DISCOVER_RESULTS = 50
+	// MAX_SLUG_LENGTH bounds the slug derived from an organization name.
+	MAX_SLUG_LENGTH = 64
+	// MAX_LOGO_SIZE bounds the size in bytes of an uploaded logo.
+	MAX_LOGO_SIZE = 2 << 20
+	// MAX_ORG_TAGS bounds the number of tags of a single organization.
+	MAX_ORG_TAGS = 50
+	// MAX_MEMBERSHIP_ATTEMPTS bounds the retries of a member removal racing
+	// with other writes to the organization.
+	MAX_MEMBERSHIP_ATTEMPTS = 5
+	// DEFAULT_MEMBERS_PAGE_SIZE is the member directory page size when the
+	// client doesn't ask for one.
+	DEFAULT_MEMBERS_PAGE_SIZE = 50
+)
+
+// LOGO_SIZES are the square sizes in pixels every uploaded logo is resized to.
+var LOGO_SIZES = map[string]int{
+	"small":  64,
+	"medium": 128,
+	"large":  256,
+}
+
+var (
+	domainResolver domain.Resolver = domain.NetResolver{}
+	blobStore      blob.Store
+	mailSender     mailer.Sender = mailer.LogSender{}
+	userRepo       database.UserRepository
+	orgRepo        database.OrgRepository
+	defaultQuota   types.OrgQuota
+	quotaAdmins    []string
+
+	orgRetentionPeriod time.Duration
+	orgPurgeInterval   time.Duration
+
+	metadataKeyRegex = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+	slugSepRegex     = regexp.MustCompile(`[^a-z0-9]+`)
+	objectIdRegex    = regexp.MustCompile(`^[0-9a-fA-F]{24}$`)
+	// tags are plain labels ("beta") or key:value pairs ("region:eu")
+	tagRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,31}(:[a-z0-9][a-z0-9_.-]{0,31})?$`)
 )
 
 func init() {
-	if err := database.ConnectDB(); err != nil {
-		log.Fatal(err)
+	defaultQuota = types.OrgQuota{
+		MaxMembers:        intFromEnv("DEFAULT_MAX_MEMBERS"),
+		MaxPendingInvites: intFromEnv("DEFAULT_MAX_PENDING_INVITES"),
+		MaxTeams:          intFromEnv("DEFAULT_MAX_TEAMS"),
 	}
+	quotaAdmins = strings.Fields(strings.ReplaceAll(os.Getenv("QUOTA_ADMINS"), ",", " "))
+	orgRetentionPeriod = durationFromEnv("ORG_RETENTION_PERIOD", 30*24*time.Hour)
+	orgPurgeInterval = durationFromEnv("ORG_PURGE_INTERVAL", time.Hour)
 }
 
-func SignUp(user types.User) error {
+// SetMailSender replaces the sender logging the mails, it must be called
+// before serving any request.
+func SetMailSender(sender mailer.Sender) {
+	mailSender = sender
+}
+
+// SetRepositories sets the storage every business operation goes through, it
+// must be called before serving any request.
+func SetRepositories(users database.UserRepository, orgs database.OrgRepository) {
+	userRepo = users
+	orgRepo = orgs
+}
+
+// SignUp relies on the storage rejecting duplicate emails, so concurrent sign
+// ups with the same email cannot both succeed.
+func SignUp(ctx context.Context, user types.User) error {
 	var err error
 
-	existedUser, err := database.ReadUser(user.Email)
+	user.Email = normalizeEmail(user.Email)
+
+	user.Password, err = hashPassword(user.Password)
+	if err != nil {
+		return err
+	}
+
+	err = userRepo.CreateUser(ctx, user)
 	if err != nil {
 		return err
 	}
 
-	if existedUser.Email == user.Email {
-		return errors.New("email already exists")
+	// the account works right away, its invitations and domain memberships
+	// wait for the email to be verified
+	err = sendEmailVerification(user.Email, user.Email)
+	if err != nil {
+		log.Printf("sending the verification of %s: %v", user.Email, err)
 	}
 
-	user.Password, err = hashPassword(user.Password)
+	return nil
+}
+
+// ChangeEmail checks the password and mails a verification token to the new
+// email, the account is only renamed by VerifyEmail. Asking for the current
+// email sends its verification again.
+func ChangeEmail(ctx context.Context, email, newEmail, password string) error {
+	email = normalizeEmail(email)
+	newEmail = normalizeEmail(newEmail)
+
+	user, err := userRepo.ReadUser(ctx, email)
+	if err != nil {
+		return err
+	}
+
+	if user.Email != email {
+		return errors.New("user doesn't exists")
+	}
+
+	err = verifyPassword(password, user.Password)
+	if err != nil {
+		return err
+	}
+
+	if _, err := mail.ParseAddress(newEmail); err != nil {
+		return errors.New("invalid email")
+	}
+
+	if newEmail != email {
+		existing, err := userRepo.ReadUser(ctx, newEmail)
+		if err != nil {
+			return err
+		}
+
+		if existing.Email != "" {
+			return database.ErrEmailExists
+		}
+	}
+
+	return sendEmailVerification(email, newEmail)
+}
+
+// VerifyEmail applies a mailed verification token: the account is renamed
+// when the token verifies a new email, all sessions of the old email are
+// revoked, and the verified email then joins the organizations it was
+// invited to or whose verified domain it belongs to.
+func VerifyEmail(ctx context.Context, token string) error {
+	email, newEmail, err := auth.ValidateEmailToken(token)
+	if err != nil {
+		return err
+	}
+
+	user, err := userRepo.ReadUser(ctx, email)
+	if err != nil {
+		return err
+	}
+
+	if user.Email != email {
+		return errors.New("user doesn't exists")
+	}
+
+	if newEmail != email {
+		err = userRepo.UpdateUserEmail(ctx, email, newEmail)
+		if err != nil {
+			return err
+		}
+
+		err = auth.RevokeUserRefreshTokens(email)
+		if err != nil {
+			return err
+		}
+
+		user.Email = newEmail
+	}
+
+	err = acceptInvitations(ctx, user)
 	if err != nil {
 		return err
 	}
 
-	return database.CreateUser(user)
+	return joinDomainOrgs(ctx, user)
 }
 
-func SignIn(user types.User) (types.Token, error) {
-	fetchedUser, err := database.ReadUser(user.Email)
+func SignIn(ctx context.Context, user types.User) (types.Token, error) {
+	user.Email = normalizeEmail(user.Email)
+
+	fetchedUser, err := userRepo.ReadUser(ctx, user.Email)
 	if err != nil {
 		return types.Token{}, err
 	}
@@ -84,21 +254,38 @@ func RefreshAccessToken(refreshToken string) (string, error) {
 	return auth.GenerateAccessToken(refreshToken)
 }
 
-func CreateOrg(orgInfo types.OrgInfo, email string) (string, error) {
-	user, err := database.ReadUser(email)
+func CreateOrg(ctx context.Context, orgInfo types.OrgInfo, email string) (string, error) {
+	user, err := userRepo.ReadUser(ctx, email)
 	if err != nil {
-		return "", nil
+		return "", err
+	}
+
+	orgInfo.Slug = slugify(orgInfo.Name)
+	org := types.Org{
+		OrgInfo: orgInfo,
+		Quota:   defaultQuota,
 	}
-	return database.CreateOrg(orgInfo, user)
+
+	return orgRepo.CreateOrg(ctx, org, user)
 }
 
-func ReadOrg(orgId, email string) (types.Org, error) {
-	isMember := database.IsOrgMember(orgId, email)
-	if !isMember {
-		return types.Org{}, errors.New("this user is not an org member")
+func ReadOrg(ctx context.Context, orgId, email string) (types.Org, error) {
+	err := checkOrgReader(ctx, orgId, email)
+	if err != nil {
+		return types.Org{}, err
+	}
+
+	org, err := orgRepo.ReadOrg(ctx, orgId)
+	if err != nil {
+		return types.Org{}, err
+	}
+
+	org.Teams, err = orgRepo.ReadOrgsTeams(ctx, []string{orgId})
+	if err != nil {
+		return types.Org{}, err
 	}
 
-	org, err := database.ReadOrg(orgId)
+	org.OrgMembers, err = readAllOrgMembers(ctx, orgId)
 	if err != nil {
 		return types.Org{}, err
 	}
@@ -106,77 +293,1781 @@ func ReadOrg(orgId, email string) (types.Org, error) {
 	return org, nil
 }
 
-func ReadAllOrgs(email string) ([]types.Org, error) {
-	return database.ReadAllOrgsInfo(email)
+// ReadAllOrgs returns the organizations of the user together with every
+// organization below the ones they administrate, so clients can render the
+// whole hierarchy from the parent IDs. When tags are given only the
+// organizations labeled with all of them are returned.
+func ReadAllOrgs(ctx context.Context, email string, tags []string) ([]types.Org, error) {
+	memberOrgs, err := orgRepo.ReadAllOrgsInfo(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+
+	var orgs []types.Org
+	seen := make(map[string]bool)
+	for _, org := range memberOrgs {
+		if seen[org.OrgId] {
+			continue
+		}
+		seen[org.OrgId] = true
+		orgs = append(orgs, org)
+
+		isAdmin, err := hasAdminAccess(ctx, org.OrgId, email)
+		if err != nil {
+			return nil, err
+		}
+
+		if !isAdmin {
+			continue
+		}
+
+		descendants, err := orgRepo.ReadOrgDescendants(ctx, org.OrgId)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, descendant := range descendants {
+			if !seen[descendant.OrgId] {
+				seen[descendant.OrgId] = true
+				orgs = append(orgs, descendant)
+			}
+		}
+	}
+
+	if len(tags) > 0 {
+		orgs = filterOrgsByTags(orgs, normalizeTags(tags))
+	}
+
+	orgIds := make([]string, len(orgs))
+	for i, org := range orgs {
+		orgIds[i] = org.OrgId
+	}
+
+	teams, err := orgRepo.ReadOrgsTeams(ctx, orgIds)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range orgs {
+		for _, team := range teams {
+			if team.OrgId == orgs[i].OrgId {
+				orgs[i].Teams = append(orgs[i].Teams, team)
+			}
+		}
+
+		orgs[i].OrgMembers, err = readAllOrgMembers(ctx, orgs[i].OrgId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return orgs, nil
+}
+
+func CreateChildOrg(ctx context.Context, orgInfo types.OrgInfo, email string) (string, error) {
+	isAdmin, err := isOrgAdmin(ctx, orgInfo.ParentId, email)
+	if err != nil {
+		return "", err
+	}
+
+	if !isAdmin {
+		return "", errors.New("child orgs can be created via admins only")
+	}
+
+	return CreateOrg(ctx, orgInfo, email)
+}
+
+// SetDomainResolver replaces the DNS resolver used to verify domain claims,
+// e.g. with a domain.FakeResolver in tests and local development.
+func SetDomainResolver(resolver domain.Resolver) {
+	domainResolver = resolver
 }
 
-func UpdateOrg(orgInfo types.OrgInfo, email string) (types.OrgInfo, error) {
-	adminEmail, err := database.ReadOrgAdmin(orgInfo.OrgId)
+// AddOrgDomain claims a domain for the organization, the claim only takes
+// effect once VerifyOrgDomain finds its token in the domain TXT records.
+func AddOrgDomain(ctx context.Context, orgId, email string, orgDomain types.OrgDomain) (types.OrgDomain, error) {
+	isAdmin, err := isOrgAdmin(ctx, orgId, email)
 	if err != nil {
-		return types.OrgInfo{}, err
+		return types.OrgDomain{}, err
 	}
 
-	if adminEmail != email {
-		return types.OrgInfo{}, errors.New("orgs can be updated via admins only")
+	if !isAdmin {
+		return types.OrgDomain{}, errors.New("org domains can be added via admins only")
+	}
+
+	orgDomain.Domain = domain.Normalize(orgDomain.Domain)
+	if orgDomain.Domain == "" {
+		return types.OrgDomain{}, errors.New("invalid domain")
+	}
+
+	if orgDomain.AccessLevel == "" {
+		orgDomain.AccessLevel = types.ACCESS_LEVEL_USER
+	}
+
+	// anyone with a mailbox on the domain joins, so a claim never grants admin
+	if orgDomain.AccessLevel != types.ACCESS_LEVEL_USER {
+		return types.OrgDomain{}, errors.New("domain claims can only grant the user access level")
+	}
+
+	orgDomain.Token = uuid.New().String()
+	orgDomain.Verified = false
+
+	err = orgRepo.AddOrgDomain(ctx, orgId, orgDomain)
+	if err != nil {
+		return types.OrgDomain{}, err
+	}
+
+	return orgDomain, nil
+}
+
+func ReadOrgDomains(ctx context.Context, orgId, email string) ([]types.OrgDomain, error) {
+	isAdmin, err := isOrgAdmin(ctx, orgId, email)
+	if err != nil {
+		return nil, err
 	}
 
-	return database.UpdateOrg(orgInfo)
+	if !isAdmin {
+		return nil, errors.New("org domains can be listed via admins only")
+	}
+
+	org, err := orgRepo.ReadOrg(ctx, orgId)
+	if err != nil {
+		return nil, err
+	}
+
+	return org.Domains, nil
 }
 
-func DeleteOrg(orgId, email string) error {
-	adminEmail, err := database.ReadOrgAdmin(orgId)
+func VerifyOrgDomain(ctx context.Context, orgId, domainName, email string) error {
+	isAdmin, err := isOrgAdmin(ctx, orgId, email)
 	if err != nil {
 		return err
 	}
 
-	if adminEmail != email {
-		return errors.New("orgs can be deleted via admins only")
+	if !isAdmin {
+		return errors.New("org domains can be verified via admins only")
+	}
+
+	org, err := orgRepo.ReadOrg(ctx, orgId)
+	if err != nil {
+		return err
+	}
+
+	domainName = domain.Normalize(domainName)
+
+	var orgDomain *types.OrgDomain
+	for i := range org.Domains {
+		if org.Domains[i].Domain == domainName {
+			orgDomain = &org.Domains[i]
+		}
+	}
+
+	if orgDomain == nil {
+		return errors.New("domain is not claimed by this organization")
 	}
 
-	return database.DeleteOrg(orgId)
+	claimingOrgs, err := orgRepo.ReadOrgsByVerifiedDomain(ctx, domainName)
+	if err != nil {
+		return err
+	}
+
+	for _, claimingOrg := range claimingOrgs {
+		if claimingOrg.OrgId != orgId {
+			return errors.New("domain is already verified by another organization")
+		}
+	}
+
+	err = domain.Verify(domainResolver, orgDomain.Domain, orgDomain.Token)
+	if err != nil {
+		return err
+	}
+
+	return orgRepo.VerifyOrgDomain(ctx, orgId, domainName)
 }
 
-func InviteUserToOrg(orgId, email string, member types.OrgMember) error {
-	adminEmail, err := database.ReadOrgAdmin(orgId)
+func RemoveOrgDomain(ctx context.Context, orgId, domainName, email string) error {
+	isAdmin, err := isOrgAdmin(ctx, orgId, email)
 	if err != nil {
 		return err
 	}
 
-	if adminEmail != email {
-		return errors.New("inviting users to orgs can done only be admins")
+	if !isAdmin {
+		return errors.New("org domains can be removed via admins only")
+	}
+
+	return orgRepo.RemoveOrgDomain(ctx, orgId, domain.Normalize(domainName))
+}
+
+// UpdateOrgQuota is reserved to the quota admins configured in QUOTA_ADMINS,
+// org admins cannot lift the limits of their own organizations.
+func UpdateOrgQuota(ctx context.Context, orgId string, quota types.OrgQuota, email string) error {
+	isQuotaAdmin := false
+	for _, quotaAdmin := range quotaAdmins {
+		if quotaAdmin == email {
+			isQuotaAdmin = true
+		}
+	}
+
+	if !isQuotaAdmin {
+		return errors.New("org quotas can be updated via quota admins only")
+	}
+
+	return orgRepo.UpdateOrgQuota(ctx, orgId, quota)
+}
+
+func ReadOrgUsage(ctx context.Context, orgId, email string) (types.Org, error) {
+	err := checkOrgReader(ctx, orgId, email)
+	if err != nil {
+		return types.Org{}, err
 	}
 
-	user, err := database.ReadUser(member.Email)
+	return orgRepo.ReadOrg(ctx, orgId)
+}
+
+func UpdateOrgSettings(ctx context.Context, orgId string, settings types.OrgSettings, email string) error {
+	isAdmin, err := isOrgAdmin(ctx, orgId, email)
 	if err != nil {
 		return err
 	}
 
-	if user.Email != member.Email {
-		return errors.New("user doesn't exists")
+	if !isAdmin {
+		return errors.New("org settings can be updated via admins only")
+	}
+
+	err = validateOrgSettings(settings)
+	if err != nil {
+		return err
+	}
+
+	return orgRepo.UpdateOrgSettings(ctx, orgId, settings)
+}
+
+// UpdateOrgMetadataFields replaces the metadata schema of the organization,
+// values that no longer match the schema are dropped with it.
+func UpdateOrgMetadataFields(ctx context.Context, orgId string, fields []types.MetadataField, email string) error {
+	isAdmin, err := isOrgAdmin(ctx, orgId, email)
+	if err != nil {
+		return err
+	}
+
+	if !isAdmin {
+		return errors.New("org metadata fields can be updated via admins only")
 	}
 
-	for _, org := range user.Orgs {
-		if org == orgId {
-			return errors.New("user already exists in this organization")
+	keys := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		if !metadataKeyRegex.MatchString(field.Key) {
+			return fmt.Errorf("invalid metadata key %q", field.Key)
+		}
+
+		if keys[field.Key] {
+			return fmt.Errorf("duplicate metadata key %q", field.Key)
 		}
+		keys[field.Key] = true
 	}
 
-	member.Name = user.Name
+	org, err := orgRepo.ReadOrg(ctx, orgId)
+	if err != nil {
+		return err
+	}
+
+	metadata := make(map[string]interface{})
+	for key, value := range org.Metadata {
+		if validateMetadataValue(fields, key, value) == nil {
+			metadata[key] = value
+		}
+	}
 
-	return database.InviteUserToOrg(orgId, member)
+	return orgRepo.UpdateOrgMetadata(ctx, orgId, fields, metadata)
 }
 
-// ================ Private helper functions ================ //
+func UpdateOrgMetadata(ctx context.Context, orgId string, metadata map[string]interface{}, email string) error {
+	isAdmin, err := isOrgAdmin(ctx, orgId, email)
+	if err != nil {
+		return err
+	}
 
-func hashPassword(password string) (string, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if !isAdmin {
+		return errors.New("org metadata can be updated via admins only")
+	}
+
+	org, err := orgRepo.ReadOrg(ctx, orgId)
 	if err != nil {
-		return "", err
+		return err
 	}
-	return string(hash), nil
+
+	for key, value := range metadata {
+		err = validateMetadataValue(org.MetadataFields, key, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return orgRepo.UpdateOrgMetadata(ctx, orgId, org.MetadataFields, metadata)
 }
 
-func verifyPassword(inputPassword, hashedPassword string) error {
-	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(inputPassword))
-	return err
+// MoveOrg attaches the organization under a new parent, or makes it a root
+// when the parent is empty, refusing moves that would create a cycle.
+func MoveOrg(ctx context.Context, orgId, parentId, email string) error {
+	isAdmin, err := isOrgAdmin(ctx, orgId, email)
+	if err != nil {
+		return err
+	}
+
+	if !isAdmin {
+		return errors.New("orgs can be moved via admins only")
+	}
+
+	org, err := orgRepo.ReadOrg(ctx, orgId)
+	if err != nil {
+		return err
+	}
+
+	// an org leaves the control of its current parent only with the consent
+	// of the parent's admins
+	if org.ParentId != "" {
+		isAdmin, err = isOrgAdmin(ctx, org.ParentId, email)
+		if err != nil {
+			return err
+		}
+
+		if !isAdmin {
+			return errors.New("orgs can be moved out of their parent via the parent admins only")
+		}
+	}
+
+	if parentId == "" {
+		return orgRepo.MoveOrg(ctx, orgId, parentId)
+	}
+
+	isAdmin, err = isOrgAdmin(ctx, parentId, email)
+	if err != nil {
+		return err
+	}
+
+	if !isAdmin {
+		return errors.New("orgs can be moved only under orgs administrated by the same user")
+	}
+
+	if parentId == orgId {
+		return errors.New("an org cannot be its own parent")
+	}
+
+	descendants, err := orgRepo.ReadOrgDescendants(ctx, orgId)
+	if err != nil {
+		return err
+	}
+
+	for _, descendant := range descendants {
+		if descendant.OrgId == parentId {
+			return errors.New("an org cannot be moved under one of its descendants")
+		}
+	}
+
+	return orgRepo.MoveOrg(ctx, orgId, parentId)
+}
+
+// ReadOrgAncestors returns the ancestors from the parent up to the root, the
+// ones the user cannot read are reduced to their ID, name and slug.
+func ReadOrgAncestors(ctx context.Context, orgId, email string) ([]types.Org, error) {
+	err := checkOrgReader(ctx, orgId, email)
+	if err != nil {
+		return nil, err
+	}
+
+	ancestors, err := orgRepo.ReadOrgAncestors(ctx, orgId)
+	if err != nil {
+		return nil, err
+	}
+
+	// the admins of an org can read every org below it
+	adminAbove := false
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		ancestor := ancestors[i]
+		member, isMember, err := readOrgMember(ctx, ancestor.OrgId, email)
+		if err != nil {
+			return nil, err
+		}
+
+		readable := adminAbove || isMember
+		adminAbove = adminAbove || member.AccessLevel == types.ACCESS_LEVEL_ADMIN
+
+		if !readable {
+			ancestors[i] = types.Org{OrgInfo: types.OrgInfo{
+				OrgId: ancestor.OrgId,
+				Name:  ancestor.Name,
+				Slug:  ancestor.Slug,
+			}}
+		}
+	}
+
+	return ancestors, nil
+}
+
+func ReadOrgDescendants(ctx context.Context, orgId, email string) ([]types.Org, error) {
+	err := checkOrgReader(ctx, orgId, email)
+	if err != nil {
+		return nil, err
+	}
+
+	return orgRepo.ReadOrgDescendants(ctx, orgId)
+}
+
+func AddOrgTags(ctx context.Context, orgId, email string, tags []string) ([]string, error) {
+	isAdmin, err := isOrgAdmin(ctx, orgId, email)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isAdmin {
+		return nil, errors.New("org tags can be managed via admins only")
+	}
+
+	tags = normalizeTags(tags)
+	for _, tag := range tags {
+		if !tagRegex.MatchString(tag) {
+			return nil, fmt.Errorf("invalid tag %q, use a label or a key:value pair", tag)
+		}
+	}
+
+	err = orgRepo.AddOrgTags(ctx, orgId, tags, MAX_ORG_TAGS)
+	if err != nil {
+		return nil, err
+	}
+
+	org, err := orgRepo.ReadOrg(ctx, orgId)
+	if err != nil {
+		return nil, err
+	}
+
+	return org.Tags, nil
+}
+
+func RemoveOrgTag(ctx context.Context, orgId, email, tag string) error {
+	isAdmin, err := isOrgAdmin(ctx, orgId, email)
+	if err != nil {
+		return err
+	}
+
+	if !isAdmin {
+		return errors.New("org tags can be managed via admins only")
+	}
+
+	return orgRepo.RemoveOrgTag(ctx, orgId, strings.ToLower(strings.TrimSpace(tag)))
+}
+
+func UpdateOrgVisibility(ctx context.Context, orgId, visibility, email string) error {
+	isAdmin, err := isOrgAdmin(ctx, orgId, email)
+	if err != nil {
+		return err
+	}
+
+	if !isAdmin {
+		return errors.New("org visibility can be updated via admins only")
+	}
+
+	return orgRepo.UpdateOrgVisibility(ctx, orgId, visibility)
+}
+
+func DiscoverOrgs(ctx context.Context, query string) ([]types.Org, error) {
+	return orgRepo.SearchDiscoverableOrgs(ctx, query, MAX_DISCOVER_RESULTS)
+}
+
+func RequestToJoinOrg(ctx context.Context, orgId, email string) (string, error) {
+	org, err := orgRepo.ReadOrg(ctx, orgId)
+	if err != nil {
+		return "", err
+	}
+
+	if org.Visibility != types.ORG_VISIBILITY_DISCOVERABLE {
+		return "", errors.New("this org doesn't accept join requests")
+	}
+
+	if orgRepo.IsOrgMember(ctx, orgId, email) {
+		return "", errors.New("user already exists in this organization")
+	}
+
+	if orgRepo.HasPendingJoinRequest(ctx, orgId, email) {
+		return "", errors.New("user already requested to join this organization")
+	}
+
+	user, err := userRepo.ReadUser(ctx, email)
+	if err != nil {
+		return "", err
+	}
+
+	return orgRepo.CreateJoinRequest(ctx, types.JoinRequest{
+		OrgId:     orgId,
+		UserInfo:  user.UserInfo,
+		Status:    types.JOIN_REQUEST_STATUS_PENDING,
+		CreatedAt: time.Now(),
+	})
+}
+
+func ReadJoinRequests(ctx context.Context, orgId, email string) ([]types.JoinRequest, error) {
+	isAdmin, err := isOrgAdmin(ctx, orgId, email)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isAdmin {
+		return nil, errors.New("join requests can be listed via admins only")
+	}
+
+	return orgRepo.ReadPendingJoinRequests(ctx, orgId)
+}
+
+// ApproveJoinRequest resolves the request first so that concurrent approvals
+// cannot add the member twice, and reopens it if the membership fails.
+func ApproveJoinRequest(ctx context.Context, orgId, requestId, email string) error {
+	isAdmin, err := isOrgAdmin(ctx, orgId, email)
+	if err != nil {
+		return err
+	}
+
+	if !isAdmin {
+		return errors.New("join requests can be approved via admins only")
+	}
+
+	joinRequest, err := orgRepo.SetJoinRequestStatus(ctx, orgId, requestId,
+		types.JOIN_REQUEST_STATUS_PENDING, types.JOIN_REQUEST_STATUS_APPROVED)
+	if err != nil {
+		return err
+	}
+
+	member := types.OrgMember{
+		UserInfo: joinRequest.UserInfo,
+	}
+
+	err = addMemberToOrg(ctx, orgId, member, 0)
+	if err != nil {
+		orgRepo.SetJoinRequestStatus(ctx, orgId, requestId,
+			types.JOIN_REQUEST_STATUS_APPROVED, types.JOIN_REQUEST_STATUS_PENDING)
+		return err
+	}
+
+	return nil
+}
+
+func RejectJoinRequest(ctx context.Context, orgId, requestId, email string) error {
+	isAdmin, err := isOrgAdmin(ctx, orgId, email)
+	if err != nil {
+		return err
+	}
+
+	if !isAdmin {
+		return errors.New("join requests can be rejected via admins only")
+	}
+
+	_, err = orgRepo.SetJoinRequestStatus(ctx, orgId, requestId,
+		types.JOIN_REQUEST_STATUS_PENDING, types.JOIN_REQUEST_STATUS_REJECTED)
+
+	return err
+}
+
+func UpdateOrg(ctx context.Context, orgInfo types.OrgInfo, email string) (types.OrgInfo, error) {
+	isAdmin, err := isOrgAdmin(ctx, orgInfo.OrgId, email)
+	if err != nil {
+		return types.OrgInfo{}, err
+	}
+
+	if !isAdmin {
+		return types.OrgInfo{}, errors.New("orgs can be updated via admins only")
+	}
+
+	orgInfo.Slug = slugify(orgInfo.Name)
+
+	return orgRepo.UpdateOrg(ctx, orgInfo)
+}
+
+// ResolveOrg maps an organization ID or slug to the organization ID, it also
+// returns the current slug when the given one is a previous slug of the
+// organization so clients can be redirected to it.
+func ResolveOrg(ctx context.Context, idOrSlug string) (string, string, error) {
+	if objectIdRegex.MatchString(idOrSlug) {
+		return idOrSlug, "", nil
+	}
+
+	orgId, slug, err := orgRepo.ResolveOrgSlug(ctx, strings.ToLower(idOrSlug))
+	if err != nil {
+		return "", "", err
+	}
+
+	if slug == strings.ToLower(idOrSlug) {
+		return orgId, "", nil
+	}
+
+	return orgId, slug, nil
+}
+
+func DeleteOrg(ctx context.Context, orgId, email string) error {
+	isAdmin, err := isOrgAdmin(ctx, orgId, email)
+	if err != nil {
+		return err
+	}
+
+	if !isAdmin {
+		return errors.New("orgs can be deleted via admins only")
+	}
+
+	return orgRepo.DeleteOrg(ctx, orgId)
+}
+
+// RestoreOrg brings back an archived organization as long as its retention
+// period is not over yet.
+func RestoreOrg(ctx context.Context, orgId, email string) error {
+	org, err := orgRepo.ReadArchivedOrg(ctx, orgId)
+	if err != nil {
+		return err
+	}
+
+	isAdmin, err := hasAdminAccess(ctx, org.OrgId, email)
+	if err != nil {
+		return err
+	}
+
+	if !isAdmin {
+		isAdmin, err = isAncestorAdmin(ctx, orgId, email)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !isAdmin {
+		return errors.New("orgs can be restored via admins only")
+	}
+
+	return orgRepo.RestoreOrg(ctx, orgId, time.Now().Add(-orgRetentionPeriod))
+}
+
+// StartOrgPurger periodically hard deletes the organizations whose retention
+// period is over, it returns immediately and runs until the context is done.
+func StartOrgPurger(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(orgPurgeInterval)
+		defer ticker.Stop()
+
+		for {
+			purged, err := orgRepo.PurgeArchivedOrgs(ctx, time.Now().Add(-orgRetentionPeriod))
+			if err != nil {
+				log.Println("purging archived orgs:", err)
+			}
+			for _, org := range purged {
+				deleteLogoBlobs(org.Logo)
+			}
+			if len(purged) > 0 {
+				log.Printf("purged %d archived orgs", len(purged))
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// SetBlobStore sets the store logos are kept in, it must be called before
+// serving any request.
+func SetBlobStore(store blob.Store) {
+	blobStore = store
+}
+
+func BlobStore() blob.Store {
+	return blobStore
+}
+
+// UploadOrgLogo validates the uploaded image and stores it resized to every
+// size of LOGO_SIZES, the previous logo is deleted once the new one is saved.
+func UploadOrgLogo(ctx context.Context, orgId, email string, data []byte) (map[string]string, error) {
+	isAdmin, err := isOrgAdmin(ctx, orgId, email)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isAdmin {
+		return nil, errors.New("org logo can be updated via admins only")
+	}
+
+	if len(data) > MAX_LOGO_SIZE {
+		return nil, fmt.Errorf("logo must not exceed %d bytes", MAX_LOGO_SIZE)
+	}
+
+	img, err := imaging.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// every upload gets its own keys, so clients and CDNs never serve a
+	// cached copy of the previous logo
+	version := uuid.NewString()
+	logo := make(map[string]string)
+	for name, size := range LOGO_SIZES {
+		resized, err := imaging.EncodePNG(imaging.Square(img, size))
+		if err != nil {
+			deleteLogoBlobs(logo)
+			return nil, err
+		}
+
+		key := fmt.Sprintf("orgs/%s/logo/%s/%s.png", orgId, version, name)
+		err = blobStore.Put(key, "image/png", resized)
+		if err != nil {
+			deleteLogoBlobs(logo)
+			return nil, err
+		}
+		logo[name] = key
+	}
+
+	previous, err := orgRepo.UpdateOrgLogo(ctx, orgId, logo)
+	if err != nil {
+		deleteLogoBlobs(logo)
+		return nil, err
+	}
+	deleteLogoBlobs(previous)
+
+	return LogoURLs(logo), nil
+}
+
+func DeleteOrgLogo(ctx context.Context, orgId, email string) error {
+	isAdmin, err := isOrgAdmin(ctx, orgId, email)
+	if err != nil {
+		return err
+	}
+
+	if !isAdmin {
+		return errors.New("org logo can be deleted via admins only")
+	}
+
+	previous, err := orgRepo.UpdateOrgLogo(ctx, orgId, nil)
+	if err != nil {
+		return err
+	}
+	deleteLogoBlobs(previous)
+
+	return nil
+}
+
+// LogoURLs maps the stored logo keys to their public URLs.
+func LogoURLs(logo map[string]string) map[string]string {
+	if len(logo) == 0 {
+		return nil
+	}
+
+	urls := make(map[string]string, len(logo))
+	for name, key := range logo {
+		urls[name] = blobStore.URL(key)
+	}
+
+	return urls
+}
+
+// InviteUserToOrg adds the member, or invites them until they sign up. A
+// non-zero version must be the current version of the organization.
+func InviteUserToOrg(ctx context.Context, orgId, email string, member types.OrgMember, version int) error {
+	isAdmin, err := isOrgAdmin(ctx, orgId, email)
+	if err != nil {
+		return err
+	}
+
+	if !isAdmin {
+		return errors.New("inviting users to orgs can done only be admins")
+	}
+
+	return addMemberToOrg(ctx, orgId, member, version)
+}
+
+// ReadOrgMembers returns one page of the member directory and the cursor of
+// the next page, which is empty on the last page.
+func ReadOrgMembers(ctx context.Context, orgId, email string, query types.MemberQuery, cursor string) ([]types.OrgMember, string, error) {
+	err := checkOrgReader(ctx, orgId, email)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if query.SortBy == "" {
+		query.SortBy = "name"
+	}
+
+	if query.Limit == 0 {
+		query.Limit = DEFAULT_MEMBERS_PAGE_SIZE
+	}
+
+	if cursor != "" {
+		query.After, err = decodeMemberCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	// one extra member tells whether there is a next page
+	limit := query.Limit
+	query.Limit++
+	members, err := orgRepo.ReadOrgMembers(ctx, orgId, query)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(members) <= limit {
+		return members, "", nil
+	}
+
+	members = members[:limit]
+	last := members[limit-1]
+	key := last.Name
+	if query.SortBy == "email" {
+		key = last.Email
+	}
+
+	nextCursor, err := encodeMemberCursor(types.MemberCursor{Key: key, Email: last.Email})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return members, nextCursor, nil
+}
+
+// RemoveUserFromOrg lets admins remove any member and members leave on their
+// own, an organization always keeps at least one admin. The removal only
+// applies to the version the admins were counted on, a concurrent change is
+// retried unless the caller asked for a version of its own.
+func RemoveUserFromOrg(ctx context.Context, orgId, email, memberEmail string, version int) error {
+	memberEmail = normalizeEmail(memberEmail)
+
+	isAdmin, err := isOrgAdmin(ctx, orgId, email)
+	if err != nil {
+		return err
+	}
+
+	if !isAdmin && email != memberEmail {
+		return errors.New("removing users from orgs can done only be admins")
+	}
+
+	for attempt := 1; ; attempt++ {
+		org, err := orgRepo.ReadOrg(ctx, orgId)
+		if err != nil {
+			return err
+		}
+
+		if version != 0 && version != org.Version {
+			return database.ErrOrgModified
+		}
+
+		removedMember, found, err := readOrgMember(ctx, orgId, memberEmail)
+		if err != nil {
+			return err
+		}
+
+		if !found {
+			return errors.New("this user is not an org member")
+		}
+
+		if removedMember.AccessLevel == types.ACCESS_LEVEL_ADMIN {
+			// a second admin is all it takes to remove one
+			admins, err := orgRepo.ReadOrgMembers(ctx, orgId, types.MemberQuery{
+				Role:   types.ACCESS_LEVEL_ADMIN,
+				SortBy: "email",
+				Limit:  2,
+			})
+			if err != nil {
+				return err
+			}
+
+			if len(admins) == 1 {
+				return errors.New("cannot remove the last admin of an organization")
+			}
+		}
+
+		err = orgRepo.RemoveUserFromOrg(ctx, orgId, memberEmail, org.Version)
+		if errors.Is(err, database.ErrOrgModified) && version == 0 && attempt < MAX_MEMBERSHIP_ATTEMPTS {
+			continue
+		}
+
+		return err
+	}
+}
+
+// ImportMembersToOrg validates every row on its own and reports an outcome per
+// row, the valid rows are then applied together in a single transaction so an
+// exceeded quota leaves nothing half imported. A non-zero version must be the
+// current version of the organization.
+func ImportMembersToOrg(ctx context.Context, orgId, email string, members []types.OrgMember, version int) ([]types.ImportResult, error) {
+	isAdmin, err := isOrgAdmin(ctx, orgId, email)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isAdmin {
+		return nil, errors.New("importing members to orgs can done only be admins")
+	}
+
+	if len(members) > MAX_IMPORT_ROWS {
+		return nil, fmt.Errorf("cannot import more than %d members at once", MAX_IMPORT_ROWS)
+	}
+
+	for i := range members {
+		members[i].Email = normalizeEmail(members[i].Email)
+	}
+
+	org, err := orgRepo.ReadOrg(ctx, orgId)
+	if err != nil {
+		return nil, err
+	}
+
+	if version != 0 && version != org.Version {
+		return nil, database.ErrOrgModified
+	}
+
+	invitations, err := orgRepo.ReadOrgInvitations(ctx, orgId)
+	if err != nil {
+		return nil, err
+	}
+
+	emails := make([]string, len(members))
+	for i, member := range members {
+		emails[i] = member.Email
+	}
+
+	users, err := userRepo.ReadUsers(ctx, emails)
+	if err != nil {
+		return nil, err
+	}
+
+	existingUsers := make(map[string]types.User, len(users))
+	for _, user := range users {
+		existingUsers[user.Email] = user
+	}
+
+	orgMembers := make(map[string]bool)
+	if len(emails) > 0 {
+		existingMembers, err := orgRepo.ReadOrgMembers(ctx, orgId, types.MemberQuery{
+			Emails: emails,
+			SortBy: "email",
+			Limit:  len(emails),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, member := range existingMembers {
+			orgMembers[member.Email] = true
+		}
+	}
+
+	invitedEmails := make(map[string]bool, len(invitations))
+	for _, invitation := range invitations {
+		invitedEmails[invitation.Email] = true
+	}
+
+	results := make([]types.ImportResult, len(members))
+	seen := make(map[string]bool, len(members))
+	var newMembers []types.OrgMember
+	var newInvitations []types.Invitation
+
+	for i, member := range members {
+		if member.AccessLevel == "" {
+			member.AccessLevel = defaultMemberRole(org)
+		}
+
+		results[i] = types.ImportResult{
+			Email:       member.Email,
+			AccessLevel: member.AccessLevel,
+		}
+
+		if reason := validateImportRow(member); reason != "" {
+			results[i].Status = types.IMPORT_STATUS_INVALID
+			results[i].Reason = reason
+			continue
+		}
+
+		if seen[member.Email] {
+			results[i].Status = types.IMPORT_STATUS_INVALID
+			results[i].Reason = "duplicate row"
+			continue
+		}
+		seen[member.Email] = true
+
+		if orgMembers[member.Email] {
+			results[i].Status = types.IMPORT_STATUS_ALREADY_MEMBER
+			continue
+		}
+
+		user, exists := existingUsers[member.Email]
+		if !exists {
+			results[i].Status = types.IMPORT_STATUS_INVITED
+			if !invitedEmails[member.Email] {
+				newInvitations = append(newInvitations, types.Invitation{
+					OrgId:       orgId,
+					Email:       member.Email,
+					AccessLevel: member.AccessLevel,
+				})
+			}
+			continue
+		}
+
+		member.Name = user.Name
+		newMembers = append(newMembers, member)
+		results[i].Status = types.IMPORT_STATUS_ADDED
+	}
+
+	err = orgRepo.ImportMembersToOrg(ctx, orgId, newMembers, newInvitations, version)
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func CreateInviteLink(ctx context.Context, link types.InviteLink, email string) (types.InviteLink, error) {
+	isAdmin, err := isOrgAdmin(ctx, link.OrgId, email)
+	if err != nil {
+		return types.InviteLink{}, err
+	}
+
+	if !isAdmin {
+		return types.InviteLink{}, errors.New("invite links can be created via admins only")
+	}
+
+	if !link.ExpiresAt.After(time.Now()) {
+		return types.InviteLink{}, errors.New("invite link expiry must be in the future")
+	}
+
+	link.Code = uuid.New().String()
+	link.Uses = 0
+
+	err = orgRepo.CreateInviteLink(ctx, link)
+	if err != nil {
+		return types.InviteLink{}, err
+	}
+
+	return link, nil
+}
+
+func ReadInviteLinks(ctx context.Context, orgId, email string) ([]types.InviteLink, error) {
+	isAdmin, err := isOrgAdmin(ctx, orgId, email)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isAdmin {
+		return nil, errors.New("invite links can be listed via admins only")
+	}
+
+	return orgRepo.ReadInviteLinks(ctx, orgId)
+}
+
+func RevokeInviteLink(ctx context.Context, orgId, code, email string) error {
+	isAdmin, err := isOrgAdmin(ctx, orgId, email)
+	if err != nil {
+		return err
+	}
+
+	if !isAdmin {
+		return errors.New("invite links can be revoked via admins only")
+	}
+
+	return orgRepo.DeleteInviteLink(ctx, orgId, code)
+}
+
+func JoinOrgByInviteLink(ctx context.Context, code, email string) (string, error) {
+	link, err := orgRepo.RedeemInviteLink(ctx, code)
+	if err != nil {
+		return "", err
+	}
+
+	member := types.OrgMember{
+		UserInfo: types.UserInfo{
+			Email: email,
+		},
+		AccessLevel: link.AccessLevel,
+	}
+
+	err = addMemberToOrg(ctx, link.OrgId, member, 0)
+	if err != nil {
+		orgRepo.ReleaseInviteLink(ctx, code)
+		return "", err
+	}
+
+	return link.OrgId, nil
+}
+
+func CreateTeam(ctx context.Context, team types.Team, email string) (string, error) {
+	isAdmin, err := isOrgAdmin(ctx, team.OrgId, email)
+	if err != nil {
+		return "", err
+	}
+
+	if !isAdmin {
+		return "", errors.New("teams can be created via admins only")
+	}
+
+	team.Members = nil
+
+	return orgRepo.CreateTeam(ctx, team)
+}
+
+func ReadTeams(ctx context.Context, orgId, email string) ([]types.Team, error) {
+	if !orgRepo.IsOrgMember(ctx, orgId, email) {
+		return nil, errors.New("this user is not an org member")
+	}
+
+	return orgRepo.ReadOrgsTeams(ctx, []string{orgId})
+}
+
+func ReadTeam(ctx context.Context, orgId, teamId, email string) (types.Team, error) {
+	if !orgRepo.IsOrgMember(ctx, orgId, email) {
+		return types.Team{}, errors.New("this user is not an org member")
+	}
+
+	return orgRepo.ReadTeam(ctx, orgId, teamId)
+}
+
+func UpdateTeam(ctx context.Context, team types.Team, email string) error {
+	isAdmin, err := isOrgAdmin(ctx, team.OrgId, email)
+	if err != nil {
+		return err
+	}
+
+	if !isAdmin {
+		return errors.New("teams can be updated via admins only")
+	}
+
+	return orgRepo.UpdateTeam(ctx, team)
+}
+
+func DeleteTeam(ctx context.Context, orgId, teamId, email string) error {
+	isAdmin, err := isOrgAdmin(ctx, orgId, email)
+	if err != nil {
+		return err
+	}
+
+	if !isAdmin {
+		return errors.New("teams can be deleted via admins only")
+	}
+
+	return orgRepo.DeleteTeam(ctx, orgId, teamId)
+}
+
+// AddTeamMember only accepts existing org members, it can be invoked by org
+// admins and by the leads of the team.
+func AddTeamMember(ctx context.Context, orgId, teamId, email string, member types.TeamMember) error {
+	member.Email = normalizeEmail(member.Email)
+
+	err := checkTeamManager(ctx, orgId, teamId, email)
+	if err != nil {
+		return err
+	}
+
+	orgMember, found, err := readOrgMember(ctx, orgId, member.Email)
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		return errors.New("only org members can be added to teams")
+	}
+
+	member.Name = orgMember.Name
+
+	return orgRepo.AddTeamMember(ctx, orgId, teamId, member)
+}
+
+func RemoveTeamMember(ctx context.Context, orgId, teamId, email, memberEmail string) error {
+	memberEmail = normalizeEmail(memberEmail)
+
+	err := checkTeamManager(ctx, orgId, teamId, email)
+	if err != nil {
+		return err
+	}
+
+	return orgRepo.RemoveTeamMember(ctx, orgId, teamId, memberEmail)
+}
+
+// CreateProject lets any org member create a project, the creator gets an
+// admin override so they can manage the project they created.
+func CreateProject(ctx context.Context, project types.Project, email string) (string, error) {
+	err := checkOrgReader(ctx, project.OrgId, email)
+	if err != nil {
+		return "", err
+	}
+
+	user, err := userRepo.ReadUser(ctx, email)
+	if err != nil {
+		return "", err
+	}
+
+	project.Members = []types.ProjectMember{{
+		UserInfo:    types.UserInfo{Name: user.Name, Email: user.Email},
+		AccessLevel: types.ACCESS_LEVEL_ADMIN,
+	}}
+
+	return orgRepo.CreateProject(ctx, project)
+}
+
+func ReadProjects(ctx context.Context, orgId, email string) ([]types.Project, error) {
+	err := checkOrgReader(ctx, orgId, email)
+	if err != nil {
+		return nil, err
+	}
+
+	return orgRepo.ReadOrgProjects(ctx, orgId)
+}
+
+func ReadProject(ctx context.Context, orgId, projectId, email string) (types.Project, error) {
+	err := checkOrgReader(ctx, orgId, email)
+	if err != nil {
+		return types.Project{}, err
+	}
+
+	return orgRepo.ReadProject(ctx, orgId, projectId)
+}
+
+func UpdateProject(ctx context.Context, project types.Project, email string) error {
+	err := checkProjectAdmin(ctx, project.OrgId, project.ProjectId, email)
+	if err != nil {
+		return err
+	}
+
+	return orgRepo.UpdateProject(ctx, project)
+}
+
+func DeleteProject(ctx context.Context, orgId, projectId, email string) error {
+	err := checkProjectAdmin(ctx, orgId, projectId, email)
+	if err != nil {
+		return err
+	}
+
+	return orgRepo.DeleteProject(ctx, orgId, projectId)
+}
+
+// SetProjectMember overrides the access level of an org member for the
+// project, e.g. to let a regular member administrate a single project.
+func SetProjectMember(ctx context.Context, orgId, projectId, email string, member types.ProjectMember) error {
+	member.Email = normalizeEmail(member.Email)
+
+	err := checkProjectAdmin(ctx, orgId, projectId, email)
+	if err != nil {
+		return err
+	}
+
+	orgMember, found, err := readOrgMember(ctx, orgId, member.Email)
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		return errors.New("only org members can be added to projects")
+	}
+
+	member.Name = orgMember.Name
+
+	return orgRepo.SetProjectMember(ctx, orgId, projectId, member)
+}
+
+func RemoveProjectMember(ctx context.Context, orgId, projectId, email, memberEmail string) error {
+	memberEmail = normalizeEmail(memberEmail)
+
+	err := checkProjectAdmin(ctx, orgId, projectId, email)
+	if err != nil {
+		return err
+	}
+
+	return orgRepo.RemoveProjectMember(ctx, orgId, projectId, memberEmail)
+}
+
+// ================ Private helper functions ================ //
+
+// normalizeEmail lowercases emails before they are stored or looked up, so an
+// address gets the same account whatever its case.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// isOrgAdmin reports whether the user administrates the organization either
+// directly or through any of its ancestors.
+func isOrgAdmin(ctx context.Context, orgId, email string) (bool, error) {
+	isAdmin, err := orgRepo.IsOrgAdmin(ctx, orgId, email)
+	if err != nil || isAdmin {
+		return isAdmin, err
+	}
+
+	return isAncestorAdmin(ctx, orgId, email)
+}
+
+func isAncestorAdmin(ctx context.Context, orgId, email string) (bool, error) {
+	ancestors, err := orgRepo.ReadOrgAncestors(ctx, orgId)
+	if err != nil {
+		return false, err
+	}
+
+	for _, ancestor := range ancestors {
+		isAdmin, err := hasAdminAccess(ctx, ancestor.OrgId, email)
+		if err != nil || isAdmin {
+			return isAdmin, err
+		}
+	}
+
+	return false, nil
+}
+
+// readAllOrgMembers pages through every member of the organization sorted by
+// email, it only backs the deprecated members of the organization reads.
+func readAllOrgMembers(ctx context.Context, orgId string) ([]types.OrgMember, error) {
+	var members []types.OrgMember
+	query := types.MemberQuery{SortBy: "email", Limit: DEFAULT_MEMBERS_PAGE_SIZE}
+	for {
+		page, err := orgRepo.ReadOrgMembers(ctx, orgId, query)
+		if err != nil {
+			return nil, err
+		}
+
+		members = append(members, page...)
+		if len(page) < query.Limit {
+			return members, nil
+		}
+
+		last := page[len(page)-1]
+		query.After = &types.MemberCursor{Key: last.Email, Email: last.Email}
+	}
+}
+
+// hasAdminAccess reports whether the user is a direct admin of the
+// organization, archived or not.
+func hasAdminAccess(ctx context.Context, orgId, email string) (bool, error) {
+	member, found, err := readOrgMember(ctx, orgId, email)
+
+	return found && member.AccessLevel == types.ACCESS_LEVEL_ADMIN, err
+}
+
+// readOrgMember reads the single member of the organization with the email,
+// found is false when the user isn't a member.
+func readOrgMember(ctx context.Context, orgId, email string) (types.OrgMember, bool, error) {
+	members, err := orgRepo.ReadOrgMembers(ctx, orgId, types.MemberQuery{
+		Emails: []string{email},
+		SortBy: "email",
+		Limit:  1,
+	})
+	if err != nil || len(members) == 0 {
+		return types.OrgMember{}, false, err
+	}
+
+	return members[0], true, nil
+}
+
+func checkOrgReader(ctx context.Context, orgId, email string) error {
+	if orgRepo.IsOrgMember(ctx, orgId, email) {
+		return nil
+	}
+
+	isAdmin, err := isOrgAdmin(ctx, orgId, email)
+	if err != nil {
+		return err
+	}
+
+	if !isAdmin {
+		return errors.New("this user is not an org member")
+	}
+
+	return nil
+}
+
+func checkTeamManager(ctx context.Context, orgId, teamId, email string) error {
+	isAdmin, err := isOrgAdmin(ctx, orgId, email)
+	if err != nil {
+		return err
+	}
+
+	team, err := orgRepo.ReadTeam(ctx, orgId, teamId)
+	if err != nil {
+		return err
+	}
+
+	if isAdmin {
+		return nil
+	}
+
+	for _, member := range team.Members {
+		if member.Email == email && member.Role == types.TEAM_ROLE_LEAD {
+			return nil
+		}
+	}
+
+	return errors.New("team members can be managed via admins and team leads only")
+}
+
+// projectAccessLevel resolves the access level of the user on the project,
+// org admins are always project admins, other org members get their project
+// override if any and the user access level otherwise.
+func projectAccessLevel(ctx context.Context, orgId, projectId, email string) (string, error) {
+	isAdmin, err := isOrgAdmin(ctx, orgId, email)
+	if err != nil {
+		return "", err
+	}
+
+	project, err := orgRepo.ReadProject(ctx, orgId, projectId)
+	if err != nil {
+		return "", err
+	}
+
+	if isAdmin {
+		return types.ACCESS_LEVEL_ADMIN, nil
+	}
+
+	if !orgRepo.IsOrgMember(ctx, orgId, email) {
+		return "", errors.New("this user is not an org member")
+	}
+
+	for _, member := range project.Members {
+		if member.Email == email {
+			return member.AccessLevel, nil
+		}
+	}
+
+	return types.ACCESS_LEVEL_USER, nil
+}
+
+func checkProjectAdmin(ctx context.Context, orgId, projectId, email string) error {
+	accessLevel, err := projectAccessLevel(ctx, orgId, projectId, email)
+	if err != nil {
+		return err
+	}
+
+	if accessLevel != types.ACCESS_LEVEL_ADMIN {
+		return errors.New("projects can be managed via org admins and project admins only")
+	}
+
+	return nil
+}
+
+func defaultMemberRole(org types.Org) string {
+	if org.DefaultMemberRole == "" {
+		return types.ACCESS_LEVEL_USER
+	}
+
+	return org.DefaultMemberRole
+}
+
+func validateOrgSettings(settings types.OrgSettings) error {
+	if settings.Timezone != "" {
+		if _, err := time.LoadLocation(settings.Timezone); err != nil {
+			return fmt.Errorf("invalid timezone %q", settings.Timezone)
+		}
+	}
+
+	if settings.Locale != "" {
+		if _, err := language.Parse(settings.Locale); err != nil {
+			return fmt.Errorf("invalid locale %q", settings.Locale)
+		}
+	}
+
+	if settings.LogoURL != "" {
+		logoURL, err := url.ParseRequestURI(settings.LogoURL)
+		if err != nil || (logoURL.Scheme != "http" && logoURL.Scheme != "https") || logoURL.Host == "" {
+			return fmt.Errorf("invalid logo url %q", settings.LogoURL)
+		}
+	}
+
+	return nil
+}
+
+func validateMetadataValue(fields []types.MetadataField, key string, value interface{}) error {
+	for _, field := range fields {
+		if field.Key != key {
+			continue
+		}
+
+		valid := false
+		switch field.Type {
+		case types.METADATA_TYPE_STRING:
+			_, valid = value.(string)
+		case types.METADATA_TYPE_NUMBER:
+			switch value.(type) {
+			case float64, float32, int, int32, int64:
+				valid = true
+			}
+		case types.METADATA_TYPE_BOOLEAN:
+			_, valid = value.(bool)
+		}
+
+		if !valid {
+			return fmt.Errorf("metadata %q must be a %s", key, field.Type)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("unknown metadata key %q", key)
+}
+
+func validateImportRow(member types.OrgMember) string {
+	address, err := mail.ParseAddress(member.Email)
+	if err != nil || address.Address != member.Email {
+		return "invalid email address"
+	}
+
+	if member.AccessLevel != types.ACCESS_LEVEL_ADMIN && member.AccessLevel != types.ACCESS_LEVEL_USER {
+		return "invalid access level"
+	}
+
+	return ""
+}
+
+// addMemberToOrg is the single path every membership goes through, whether it
+// comes from an admin invitation or from redeeming an invite link. A non-zero
+// version must be the current version of the organization.
+func addMemberToOrg(ctx context.Context, orgId string, member types.OrgMember, version int) error {
+	member.Email = normalizeEmail(member.Email)
+
+	if member.AccessLevel == "" || version != 0 {
+		org, err := orgRepo.ReadOrg(ctx, orgId)
+		if err != nil {
+			return err
+		}
+
+		if version != 0 && version != org.Version {
+			return database.ErrOrgModified
+		}
+
+		if member.AccessLevel == "" {
+			member.AccessLevel = defaultMemberRole(org)
+		}
+	}
+
+	user, err := userRepo.ReadUser(ctx, member.Email)
+	if err != nil {
+		return err
+	}
+
+	if user.Email != member.Email {
+		return inviteUnknownUser(ctx, orgId, member)
+	}
+
+	if orgRepo.IsOrgMember(ctx, orgId, member.Email) {
+		return errors.New("user already exists in this organization")
+	}
+
+	member.Name = user.Name
+
+	return orgRepo.InviteUserToOrg(ctx, orgId, member, version)
+}
+
+// inviteUnknownUser stores an invitation for an email that has no account yet,
+// it is turned into a membership by acceptInvitations once the user signs up.
+func inviteUnknownUser(ctx context.Context, orgId string, member types.OrgMember) error {
+	if orgRepo.IsInvitedToOrg(ctx, orgId, member.Email) {
+		return errors.New("user already invited to this organization")
+	}
+
+	return orgRepo.CreateInvitation(ctx, types.Invitation{
+		OrgId:       orgId,
+		Email:       member.Email,
+		AccessLevel: member.AccessLevel,
+	})
+}
+
+// joinDomainOrgs adds the user to every organization that verified the domain
+// of their email, with the access level configured on that domain.
+func joinDomainOrgs(ctx context.Context, user types.User) error {
+	orgs, err := orgRepo.ReadOrgsByVerifiedDomain(ctx, domain.OfEmail(user.Email))
+	if err != nil {
+		return err
+	}
+
+	for _, org := range orgs {
+		if orgRepo.IsOrgMember(ctx, org.OrgId, user.Email) {
+			continue
+		}
+
+		for _, orgDomain := range org.Domains {
+			if orgDomain.Domain != domain.OfEmail(user.Email) {
+				continue
+			}
+
+			// claims made before admin was forbidden still only grant user
+			member := types.OrgMember{
+				UserInfo:    user.UserInfo,
+				AccessLevel: types.ACCESS_LEVEL_USER,
+			}
+
+			err = orgRepo.InviteUserToOrg(ctx, org.OrgId, member, 0)
+			if err != nil && !errors.Is(err, database.ErrQuotaExceeded) {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func acceptInvitations(ctx context.Context, user types.User) error {
+	invitations, err := orgRepo.ReadInvitations(ctx, user.Email)
+	if err != nil {
+		return err
+	}
+
+	for _, invitation := range invitations {
+		if !orgRepo.IsOrgMember(ctx, invitation.OrgId, user.Email) {
+			member := types.OrgMember{
+				UserInfo: types.UserInfo{
+					Name:  user.Name,
+					Email: user.Email,
+				},
+				AccessLevel: invitation.AccessLevel,
+			}
+
+			err = orgRepo.InviteUserToOrg(ctx, invitation.OrgId, member, 0)
+			if errors.Is(err, database.ErrQuotaExceeded) {
+				// keep the invitation pending until the org has room again
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		err = orgRepo.DeleteInvitation(ctx, invitation.OrgId, user.Email)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func sendEmailVerification(email, newEmail string) error {
+	token, err := auth.GenerateEmailToken(email, newEmail)
+	if err != nil {
+		return err
+	}
+
+	body := "Verify " + newEmail + " by sending this token to POST /user/email/verify:\n\n" + token +
+		"\n\nThe token expires in " + auth.EMAIL_TOKEN_EXPIRATION.String() + "."
+
+	return mailSender.Send(newEmail, "Verify your email", body)
+}
+
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func verifyPassword(inputPassword, hashedPassword string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(inputPassword))
+	return err
+}
+
+func intFromEnv(key string) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return 0
+	}
+
+	return value
+}
+
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+
+	return value
+}
+
+// slugify derives the URL slug of an organization from its name, slugs that
+// look like organization IDs get a suffix so lookups stay unambiguous.
+func slugify(name string) string {
+	slug := slugSepRegex.ReplaceAllString(strings.ToLower(name), "-")
+	slug = strings.Trim(slug, "-")
+	if len(slug) > MAX_SLUG_LENGTH {
+		slug = strings.TrimRight(slug[:MAX_SLUG_LENGTH], "-")
+	}
+
+	if slug == "" {
+		return "org"
+	}
+
+	if objectIdRegex.MatchString(slug) {
+		return slug + "-org"
+	}
+
+	return slug
+}
+
+// deleteLogoBlobs deletes the blobs of a logo, failures only leave orphan
+// blobs behind so they are logged instead of failing the request.
+func deleteLogoBlobs(logo map[string]string) {
+	for _, key := range logo {
+		if err := blobStore.Delete(key); err != nil {
+			log.Println("deleting logo blob:", err)
+		}
+	}
+}
+
+// normalizeTags lowercases and trims the tags and drops duplicates.
+func normalizeTags(tags []string) []string {
+	var normalized []string
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+
+	return normalized
+}
+
+func filterOrgsByTags(orgs []types.Org, tags []string) []types.Org {
+	var filtered []types.Org
+	for _, org := range orgs {
+		orgTags := make(map[string]bool)
+		for _, tag := range org.Tags {
+			orgTags[tag] = true
+		}
+
+		hasAll := true
+		for _, tag := range tags {
+			if !orgTags[tag] {
+				hasAll = false
+				break
+			}
+		}
+
+		if hasAll {
+			filtered = append(filtered, org)
+		}
+	}
+
+	return filtered
+}
+
+// member directory cursors are opaque to clients, they hold the position of
+// the last member of a page
+func encodeMemberCursor(cursor types.MemberCursor) (string, error) {
+	data, err := json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeMemberCursor(cursor string) (*types.MemberCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+
+	var memberCursor types.MemberCursor
+	err = json.Unmarshal(data, &memberCursor)
+	if err != nil || memberCursor.Email == "" {
+		return nil, errors.New("invalid cursor")
+	}
+
+	return &memberCursor, nil
 }
@@ -2,7 +2,13 @@ package database
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/zaher1307/IDEANEST-project-assignment/internal/types"
@@ -10,15 +16,33 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 )
 
+// notArchived filters out archived organizations.
+var notArchived = bson.M{"$exists": false}
+
+// memberNameStages turn membership documents into members, the name of each
+// member is read from their user.
+var memberNameStages = bson.A{
+	bson.M{"$lookup": bson.M{
+		"from":         types.USER_COLL,
+		"localField":   "email",
+		"foreignField": "email",
+		"as":           "user",
+	}},
+	bson.M{"$set": bson.M{"name": bson.M{"$ifNull": bson.A{bson.M{"$arrayElemAt": bson.A{"$user.name", 0}}, ""}}}},
+	bson.M{"$unset": "user"},
+}
+
 var (
-	client    *mongo.Client
-	ctx       context.Context
-	mongoUser string
-	mongoPass string
-	mongoDB   string
-	mongoHost string
+	operationTimeout time.Duration
+	mongoUser        string
+	mongoPass        string
+	mongoDB          string
+	mongoHost        string
+	postgresURL      string
+	sqlitePath       string
 )
 
 func init() {
@@ -28,40 +52,76 @@ func init() {
 	mongoPass = os.Getenv("MONGO_INITDB_ROOT_PASSWORD")
 	mongoDB = os.Getenv("DATABASE_NAME")
 	mongoHost = os.Getenv("DATABASE_HOST")
+	postgresURL = os.Getenv("POSTGRES_URL")
+
+	sqlitePath = os.Getenv("SQLITE_PATH")
+	if sqlitePath == "" {
+		sqlitePath = "ideanest.db"
+	}
+
+	operationTimeout = 10 * time.Second
+	if timeout, err := time.ParseDuration(os.Getenv("DATABASE_OPERATION_TIMEOUT")); err == nil && timeout > 0 {
+		operationTimeout = timeout
+	}
 }
 
-func ConnectDB() error {
-	uri := "mongodb://" + mongoUser + ":" + mongoPass + "@" + mongoHost + ":27017/"
+// Mongo is the MongoDB implementation of the repositories, every entity is
+// stored in its own collection of the DATABASE_NAME database.
+type Mongo struct {
+	client *mongo.Client
+	db     *mongo.Database
+}
 
-	ctx = context.Background()
+// NewMongo connects to the MongoDB server configured through the environment
+// and makes sure the indexes the repositories rely on exist. Every query is
+// bound to the context of its caller and, unless that context has an earlier
+// deadline, to DATABASE_OPERATION_TIMEOUT.
+func NewMongo(ctx context.Context) (*Mongo, error) {
+	// a single host is configured, so connect to it directly instead of
+	// discovering the replica set members by the names they advertise
+	uri := "mongodb://" + mongoUser + ":" + mongoPass + "@" + mongoHost + ":27017/?directConnection=true"
 
-	clientOptions := options.Client().ApplyURI(uri)
+	clientOptions := options.Client().ApplyURI(uri).SetTimeout(operationTimeout)
 
-	var err error
-	client, err = mongo.Connect(ctx, clientOptions)
+	client, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	err = client.Ping(ctx, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	m := &Mongo{client: client, db: client.Database(mongoDB)}
+
+	err = m.checkTransactions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.bootstrapSchema(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
 }
 
-func DisconnectDB() error {
-	if err := client.Disconnect(ctx); err != nil {
+func (m *Mongo) Close() error {
+	if err := m.client.Disconnect(context.Background()); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func CreateUser(user types.User) error {
-	collection := client.Database(mongoDB).Collection(types.USER_COLL)
+func (m *Mongo) CreateUser(ctx context.Context, user types.User) error {
+	collection := m.db.Collection(types.USER_COLL)
 	_, err := collection.InsertOne(ctx, user)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrEmailExists
+	}
 	if err != nil {
 		return err
 	}
@@ -69,28 +129,105 @@ func CreateUser(user types.User) error {
 	return nil
 }
 
-func ReadUser(email string) (types.User, error) {
-	collection := client.Database(mongoDB).Collection(types.USER_COLL)
+func (m *Mongo) ReadUser(ctx context.Context, email string) (types.User, error) {
+	collection := m.db.Collection(types.USER_COLL)
 	filter := bson.M{"email": email}
 
 	var user types.User
-	collection.FindOne(ctx, filter).Decode(&user)
+	err := collection.FindOne(ctx, filter).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return types.User{}, nil
+	}
+	if err != nil {
+		return types.User{}, err
+	}
 
 	return user, nil
 }
 
-func CreateOrg(orgInfo types.OrgInfo, user types.User) (string, error) {
-	collection := client.Database(mongoDB).Collection(types.ORG_COLL)
+// UpdateUserEmail renames the user and every copy of their email stored in
+// memberships, teams, projects and join requests, in one transaction.
+func (m *Mongo) UpdateUserEmail(ctx context.Context, email, newEmail string) error {
+	return m.withTransaction(ctx, func(ctx mongo.SessionContext) error {
+		collection := m.db.Collection(types.USER_COLL)
+		filter := bson.M{"email": email}
+		update := bson.M{"$set": bson.M{"email": newEmail}}
+
+		_, err := collection.UpdateOne(ctx, filter, update)
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrEmailExists
+		}
+		if err != nil {
+			return err
+		}
+
+		collection = m.db.Collection(types.MEMBERSHIP_COLL)
+
+		_, err = collection.UpdateMany(ctx, filter, update)
+		if err != nil {
+			return err
+		}
+
+		arrayFilters := options.Update().SetArrayFilters(options.ArrayFilters{
+			Filters: []interface{}{bson.M{"member.email": email}},
+		})
+
+		collection = m.db.Collection(types.TEAM_COLL)
+		filter = bson.M{"team_members.email": email}
+		update = bson.M{"$set": bson.M{"team_members.$[member].email": newEmail}}
+
+		_, err = collection.UpdateMany(ctx, filter, update, arrayFilters)
+		if err != nil {
+			return err
+		}
+
+		collection = m.db.Collection(types.PROJECT_COLL)
+		filter = bson.M{"project_members.email": email}
+		update = bson.M{"$set": bson.M{"project_members.$[member].email": newEmail}}
+
+		_, err = collection.UpdateMany(ctx, filter, update, arrayFilters)
+		if err != nil {
+			return err
+		}
+
+		collection = m.db.Collection(types.JOIN_REQUEST_COLL)
+		filter = bson.M{"email": email}
+		update = bson.M{"$set": bson.M{"email": newEmail}}
+
+		_, err = collection.UpdateMany(ctx, filter, update)
+		if err != nil {
+			return err
+		}
 
-	result, err := collection.InsertOne(ctx, types.Org{
-		OrgInfo: orgInfo,
+		return nil
 	})
+}
+
+func (m *Mongo) ReadUsers(ctx context.Context, emails []string) ([]types.User, error) {
+	collection := m.db.Collection(types.USER_COLL)
+	filter := bson.M{"email": bson.M{"$in": emails}}
+
+	cursor, err := collection.Find(ctx, filter)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	defer cursor.Close(ctx)
 
-	id := result.InsertedID.(primitive.ObjectID).Hex()
+	var users []types.User
+	err = cursor.All(ctx, &users)
+	if err != nil {
+		return nil, err
+	}
 
+	return users, nil
+}
+
+// CreateOrg stores the organization under the first free variant of its slug,
+// retrying with the next one when a concurrent insert takes it first.
+func (m *Mongo) CreateOrg(ctx context.Context, org types.Org, user types.User) (string, error) {
+	collection := m.db.Collection(types.ORG_COLL)
+
+	baseSlug := org.Slug
 	member := types.OrgMember{
 		UserInfo: types.UserInfo{
 			Name:  user.Name,
@@ -99,133 +236,310 @@ func CreateOrg(orgInfo types.OrgInfo, user types.User) (string, error) {
 		AccessLevel: types.ACCESS_LEVEL_ADMIN,
 	}
 
-	InviteUserToOrg(id, member)
+	var id string
+	for attempt := 0; ; attempt++ {
+		var err error
+		org.Slug, err = m.availableSlug(ctx, baseSlug, "")
+		if err != nil {
+			return "", err
+		}
+
+		// the organization never exists without its admin
+		err = m.withTransaction(ctx, func(ctx mongo.SessionContext) error {
+			result, err := collection.InsertOne(ctx, org)
+			if err != nil {
+				return err
+			}
 
-	return id, nil
+			id = result.InsertedID.(primitive.ObjectID).Hex()
+
+			return m.inviteUsersToOrg(ctx, id, []types.OrgMember{member}, 0)
+		})
+		if mongo.IsDuplicateKeyError(err) && attempt < MAX_SLUG_ATTEMPTS {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		return id, nil
+	}
 }
 
-func UpdateOrg(orgInfo types.OrgInfo) (types.OrgInfo, error) {
-	collection := client.Database(mongoDB).Collection(types.ORG_COLL)
+// UpdateOrg renames the organization, when the new name leads to a different
+// slug the current one is kept in the slug history so old links still resolve,
+// and a slug of the history is reclaimed when the name leads back to it.
+// The update is conditional on the version the slug was computed from, without
+// an expected version a concurrent write makes it start over.
+func (m *Mongo) UpdateOrg(ctx context.Context, orgInfo types.OrgInfo) (types.OrgInfo, error) {
+	collection := m.db.Collection(types.ORG_COLL)
 	id, err := primitive.ObjectIDFromHex(orgInfo.OrgId)
 	if err != nil {
 		return types.OrgInfo{}, err
 	}
-	filter := bson.M{"_id": id}
-	update := bson.D{
-		{Key: "$set", Value: bson.D{
-			{Key: "name", Value: orgInfo.Name},
-			{Key: "description", Value: orgInfo.Description},
-		}},
+
+	for attempt := 0; ; attempt++ {
+		org, err := m.ReadOrg(ctx, orgInfo.OrgId)
+		if err != nil {
+			return types.OrgInfo{}, err
+		}
+
+		if orgInfo.Version != 0 && orgInfo.Version != org.Version {
+			return types.OrgInfo{}, ErrOrgModified
+		}
+
+		slug := org.Slug
+		filter := bson.M{"_id": id, "version": orgVersionFilter(org.Version)}
+		update := bson.M{
+			"$set": bson.M{
+				"name":        orgInfo.Name,
+				"description": orgInfo.Description,
+			},
+			"$inc": bson.M{"version": 1},
+		}
+
+		if orgInfo.Slug != org.Slug {
+			slug, err = m.availableSlug(ctx, orgInfo.Slug, org.OrgId)
+			if err != nil {
+				return types.OrgInfo{}, err
+			}
+		}
+
+		if slug != org.Slug {
+			update["$set"].(bson.M)["slug"] = slug
+
+			// an empty history is unset, the sparse unique index would see null
+			history := renamedSlugHistory(org.SlugHistory, org.Slug, slug)
+			if len(history) > 0 {
+				update["$set"].(bson.M)["slug_history"] = history
+			} else {
+				update["$unset"] = bson.M{"slug_history": ""}
+			}
+		}
+
+		result, err := collection.UpdateOne(ctx, filter, update)
+		if mongo.IsDuplicateKeyError(err) && attempt < MAX_SLUG_ATTEMPTS {
+			continue
+		}
+		if err != nil {
+			return types.OrgInfo{}, err
+		}
+
+		if result.MatchedCount == 0 {
+			if orgInfo.Version == 0 && attempt < MAX_SLUG_ATTEMPTS {
+				continue
+			}
+
+			return types.OrgInfo{}, ErrOrgModified
+		}
+
+		return types.OrgInfo{
+			OrgId:       orgInfo.OrgId,
+			Slug:        slug,
+			ParentId:    org.ParentId,
+			Name:        orgInfo.Name,
+			Description: orgInfo.Description,
+			Version:     org.Version + 1,
+		}, nil
 	}
+}
 
-	_, err = collection.UpdateOne(context.Background(), filter, update)
+// ResolveOrgSlug finds the organization that owns the slug, either as its
+// current slug or as one of its previous ones, and returns its ID and current slug.
+func (m *Mongo) ResolveOrgSlug(ctx context.Context, slug string) (string, string, error) {
+	org, err := m.findOrg(ctx, bson.M{"$or": bson.A{
+		bson.M{"slug": slug},
+		bson.M{"slug_history": slug},
+	}})
 	if err != nil {
-		return types.OrgInfo{}, err
+		return "", "", err
 	}
 
-	return types.OrgInfo{
-		OrgId:       orgInfo.OrgId,
-		Name:        orgInfo.Name,
-		Description: orgInfo.Description,
-	}, nil
+	return org.OrgId, org.Slug, nil
 }
 
-func ReadOrg(orgId string) (types.Org, error) {
-	collection := client.Database(mongoDB).Collection(types.ORG_COLL)
+func (m *Mongo) ReadOrg(ctx context.Context, orgId string) (types.Org, error) {
 	id, err := primitive.ObjectIDFromHex(orgId)
 	if err != nil {
 		return types.Org{}, err
 	}
 
-	filter := bson.M{"_id": id}
+	return m.findOrg(ctx, bson.M{"_id": id, "archived_at": notArchived})
+}
 
-	var org types.Org
-	err = collection.FindOne(ctx, filter).Decode(&org)
+func (m *Mongo) ReadArchivedOrg(ctx context.Context, orgId string) (types.Org, error) {
+	id, err := primitive.ObjectIDFromHex(orgId)
 	if err != nil {
 		return types.Org{}, err
 	}
 
-	org.OrgId = orgId
-
-	return org, nil
+	return m.findOrg(ctx, bson.M{"_id": id, "archived_at": bson.M{"$exists": true}})
 }
 
-func ReadOrgAdmin(orgId string) (string, error) {
-	org, err := ReadOrg(orgId)
+func (m *Mongo) IsOrgAdmin(ctx context.Context, orgId, email string) (bool, error) {
+	id, err := primitive.ObjectIDFromHex(orgId)
 	if err != nil {
-		return "", err
+		return false, err
 	}
 
-	for _, member := range org.OrgMembers {
-		if member.AccessLevel == types.ACCESS_LEVEL_ADMIN {
-			return member.Email, nil
-		}
+	count, err := m.db.Collection(types.ORG_COLL).CountDocuments(ctx, bson.M{"_id": id, "archived_at": notArchived})
+	if err != nil {
+		return false, err
 	}
 
-	return "", nil
+	if count == 0 {
+		return false, ErrOrgNotFound
+	}
+
+	collection := m.db.Collection(types.MEMBERSHIP_COLL)
+	filter := bson.M{"organization_id": orgId, "email": email, "access_level": types.ACCESS_LEVEL_ADMIN}
+
+	count, err = collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
 }
 
-func ReadAllOrgsInfo(email string) ([]types.Org, error) {
-	user, err := ReadUser(email)
+func (m *Mongo) ReadAllOrgsInfo(ctx context.Context, email string) ([]types.Org, error) {
+	collection := m.db.Collection(types.MEMBERSHIP_COLL)
+
+	orgIds, err := collection.Distinct(ctx, "organization_id", bson.M{"email": email})
 	if err != nil {
 		return nil, err
 	}
 
-	orgsId := make([]primitive.ObjectID, len(user.Orgs))
-
-	for i, orgId := range user.Orgs {
-		orgsId[i], err = primitive.ObjectIDFromHex(orgId)
+	ids := make([]primitive.ObjectID, len(orgIds))
+	for i, orgId := range orgIds {
+		ids[i], err = primitive.ObjectIDFromHex(orgId.(string))
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	collection := client.Database(mongoDB).Collection(types.ORG_COLL)
-	filter := bson.M{"_id": bson.M{"$in": orgsId}}
+	return m.findOrgs(ctx, bson.M{"_id": bson.M{"$in": ids}, "archived_at": notArchived})
+}
 
-	cursor, err := collection.Find(context.Background(), filter)
+// ReadOrgAncestors walks up the parent references of an organization and
+// returns its ancestors ordered from the direct parent up to the root,
+// archived ancestors are walked through but not returned.
+func (m *Mongo) ReadOrgAncestors(ctx context.Context, orgId string) ([]types.Org, error) {
+	org, err := m.readAnyOrg(ctx, orgId)
 	if err != nil {
 		return nil, err
 	}
-	defer cursor.Close(context.Background())
 
-	var orgs []types.Org
-	for cursor.Next(ctx) {
-		var mapForExtractId map[string]interface{}
-		err := cursor.Decode(&mapForExtractId)
+	var ancestors []types.Org
+	visited := map[string]bool{orgId: true}
+	for org.ParentId != "" && !visited[org.ParentId] {
+		visited[org.ParentId] = true
+
+		org, err = m.readAnyOrg(ctx, org.ParentId)
 		if err != nil {
 			return nil, err
 		}
 
-		var org types.Org
-		err = cursor.Decode(&org)
+		if org.ArchivedAt == nil {
+			ancestors = append(ancestors, org)
+		}
+	}
+
+	return ancestors, nil
+}
+
+// ReadOrgDescendants returns every organization below the given one, the tree
+// is visited level by level with a single query per level and archived
+// organizations are walked through but not returned.
+func (m *Mongo) ReadOrgDescendants(ctx context.Context, orgId string) ([]types.Org, error) {
+	var descendants []types.Org
+	visited := map[string]bool{orgId: true}
+	level := []string{orgId}
+	for len(level) > 0 {
+		children, err := m.findOrgs(ctx, bson.M{"parent_id": bson.M{"$in": level}})
 		if err != nil {
 			return nil, err
 		}
 
-		org.OrgId = mapForExtractId["_id"].(primitive.ObjectID).Hex()
-
-		orgs = append(orgs, org)
+		level = nil
+		for _, child := range children {
+			if visited[child.OrgId] {
+				continue
+			}
+			visited[child.OrgId] = true
+			level = append(level, child.OrgId)
+			if child.ArchivedAt == nil {
+				descendants = append(descendants, child)
+			}
+		}
 	}
 
-	return orgs, nil
+	return descendants, nil
 }
 
-func DeleteOrg(orgId string) error {
-	collection := client.Database(mongoDB).Collection(types.ORG_COLL)
+// UpdateOrgSettings replaces the whole settings document, empty settings are
+// removed from the organization.
+func (m *Mongo) UpdateOrgSettings(ctx context.Context, orgId string, settings types.OrgSettings) error {
+	collection := m.db.Collection(types.ORG_COLL)
 	id, err := primitive.ObjectIDFromHex(orgId)
 	if err != nil {
 		return err
 	}
 
+	fields := bson.M{
+		"timezone":            settings.Timezone,
+		"locale":              settings.Locale,
+		"logo_url":            settings.LogoURL,
+		"default_member_role": settings.DefaultMemberRole,
+		"visibility":          settings.Visibility,
+	}
+
+	set := bson.M{}
+	unset := bson.M{}
+	for key, value := range fields {
+		if value == "" {
+			unset[key] = ""
+		} else {
+			set[key] = value
+		}
+	}
+
+	update := bson.M{}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	update["$inc"] = bson.M{"version": 1}
+
 	filter := bson.M{"_id": id}
 
-	_, err = collection.DeleteOne(ctx, filter)
+	_, err = collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *Mongo) UpdateOrgMetadata(ctx context.Context, orgId string, fields []types.MetadataField, metadata map[string]interface{}) error {
+	collection := m.db.Collection(types.ORG_COLL)
+	id, err := primitive.ObjectIDFromHex(orgId)
 	if err != nil {
 		return err
 	}
 
-	err = removeOrgFromUsers(orgId)
+	filter := bson.M{"_id": id}
+	update := bson.M{
+		"$set": bson.M{
+			"metadata_fields": fields,
+			"metadata":        metadata,
+		},
+		"$inc": bson.M{"version": 1},
+	}
+
+	_, err = collection.UpdateOne(ctx, filter, update)
 	if err != nil {
 		return err
 	}
@@ -233,123 +547,1629 @@ func DeleteOrg(orgId string) error {
 	return nil
 }
 
-func InviteUserToOrg(orgId string, member types.OrgMember) error {
-	org, err := ReadOrg(orgId)
+// AddOrgTags adds the tags that the organization doesn't have yet, the update
+// only matches while the resulting set of tags stays within maxTags.
+func (m *Mongo) AddOrgTags(ctx context.Context, orgId string, tags []string, maxTags int) error {
+	collection := m.db.Collection(types.ORG_COLL)
+	id, err := primitive.ObjectIDFromHex(orgId)
 	if err != nil {
 		return err
 	}
-	org.OrgMembers = append(org.OrgMembers, member)
 
-	err = updateOrgMembers(org.OrgMembers, orgId)
+	filter := bson.M{
+		"_id":         id,
+		"archived_at": notArchived,
+		"$expr": bson.M{"$lte": bson.A{
+			bson.M{"$size": bson.M{"$setUnion": bson.A{bson.M{"$ifNull": bson.A{"$tags", bson.A{}}}, tags}}},
+			maxTags,
+		}},
+	}
+	update := bson.M{
+		"$addToSet": bson.M{"tags": bson.M{"$each": tags}},
+		"$inc":      bson.M{"version": 1},
+	}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
 	if err != nil {
 		return err
 	}
 
-	addOrgToUser(member, orgId)
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("org doesn't exists or would exceed %d tags", maxTags)
+	}
 
 	return nil
 }
 
-func IsOrgMember(orgId, email string) bool {
-	collection := client.Database(mongoDB).Collection(types.USER_COLL)
-	filter := bson.M{"email": email}
+func (m *Mongo) RemoveOrgTag(ctx context.Context, orgId, tag string) error {
+	collection := m.db.Collection(types.ORG_COLL)
+	id, err := primitive.ObjectIDFromHex(orgId)
+	if err != nil {
+		return err
+	}
 
-	var fetchedUser types.User
-	collection.FindOne(ctx, filter).Decode(&fetchedUser)
+	filter := bson.M{"_id": id, "tags": tag}
+	update := bson.M{"$pull": bson.M{"tags": tag}, "$inc": bson.M{"version": 1}}
 
-	for _, memberOrgId := range fetchedUser.Orgs {
-		if orgId == memberOrgId {
-			return true
-		}
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
 	}
 
-	return false
-}
+	if result.ModifiedCount == 0 {
+		return errors.New("org doesn't have this tag")
+	}
 
-// ====================== helper private function ====================== //
+	return nil
+}
 
-func updateOrgMembers(members []types.OrgMember, orgId string) error {
-	collection := client.Database(mongoDB).Collection(types.ORG_COLL)
+func (m *Mongo) UpdateOrgVisibility(ctx context.Context, orgId, visibility string) error {
+	collection := m.db.Collection(types.ORG_COLL)
 	id, err := primitive.ObjectIDFromHex(orgId)
 	if err != nil {
 		return err
 	}
+
 	filter := bson.M{"_id": id}
-	update := bson.D{
-		{Key: "$set", Value: bson.D{
-			{Key: "organization_members", Value: members},
-		}},
+	update := bson.M{"$set": bson.M{"visibility": visibility}, "$inc": bson.M{"version": 1}}
+
+	_, err = collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateOrgLogo replaces the blob keys of the organization logo sizes and
+// returns the previous ones so their blobs can be deleted, a nil logo removes it.
+func (m *Mongo) UpdateOrgLogo(ctx context.Context, orgId string, logo map[string]string) (map[string]string, error) {
+	collection := m.db.Collection(types.ORG_COLL)
+	id, err := primitive.ObjectIDFromHex(orgId)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{"_id": id, "archived_at": notArchived}
+	update := bson.M{"$set": bson.M{"logo": logo}}
+	if logo == nil {
+		update = bson.M{"$unset": bson.M{"logo": ""}}
+	}
+	update["$inc"] = bson.M{"version": 1}
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.Before).
+		SetProjection(bson.M{"logo": 1})
+
+	var org types.Org
+	err = collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&org)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrOrgNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return org.Logo, nil
+}
+
+// SearchDiscoverableOrgs matches the query as a case-insensitive substring of
+// the organization name, private organizations are never returned.
+func (m *Mongo) SearchDiscoverableOrgs(ctx context.Context, query string, limit int) ([]types.Org, error) {
+	filter := bson.M{
+		"visibility":  types.ORG_VISIBILITY_DISCOVERABLE,
+		"name":        primitive.Regex{Pattern: regexp.QuoteMeta(query), Options: "i"},
+		"archived_at": notArchived,
 	}
+	opts := options.Find().SetSort(bson.M{"name": 1}).SetLimit(int64(limit))
+
+	return m.findOrgs(ctx, filter, opts)
+}
+
+func (m *Mongo) AddOrgDomain(ctx context.Context, orgId string, orgDomain types.OrgDomain) error {
+	collection := m.db.Collection(types.ORG_COLL)
+	id, err := primitive.ObjectIDFromHex(orgId)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{"_id": id, "domains.domain": bson.M{"$ne": orgDomain.Domain}}
+	update := bson.M{"$push": bson.M{"domains": orgDomain}, "$inc": bson.M{"version": 1}}
 
-	_, err = collection.UpdateOne(context.Background(), filter, update)
+	result, err := collection.UpdateOne(ctx, filter, update)
 	if err != nil {
 		return err
 	}
 
+	if result.MatchedCount == 0 {
+		return errors.New("domain already claimed by this organization")
+	}
+
 	return nil
 }
 
-func addOrgToUser(member types.OrgMember, orgId string) error {
-	collection := client.Database(mongoDB).Collection(types.USER_COLL)
-	filter := bson.M{"email": member.Email}
+func (m *Mongo) VerifyOrgDomain(ctx context.Context, orgId, domain string) error {
+	collection := m.db.Collection(types.ORG_COLL)
+	id, err := primitive.ObjectIDFromHex(orgId)
+	if err != nil {
+		return err
+	}
 
-	var fetchedUser types.User
-	collection.FindOne(ctx, filter).Decode(&fetchedUser)
+	filter := bson.M{"_id": id, "domains.domain": domain}
+	update := bson.M{"$set": bson.M{"domains.$.verified": true}, "$inc": bson.M{"version": 1}}
 
-	fetchedUser.Orgs = append(fetchedUser.Orgs, orgId)
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
 
-	update := bson.D{
-		{Key: "$set", Value: bson.D{
-			{Key: "organizations", Value: fetchedUser.Orgs},
-		}},
+	if result.MatchedCount == 0 {
+		return errors.New("domain is not claimed by this organization")
 	}
 
-	_, err := collection.UpdateOne(ctx, filter, update)
+	return nil
+}
+
+func (m *Mongo) RemoveOrgDomain(ctx context.Context, orgId, domain string) error {
+	collection := m.db.Collection(types.ORG_COLL)
+	id, err := primitive.ObjectIDFromHex(orgId)
 	if err != nil {
-		return nil
+		return err
+	}
+
+	filter := bson.M{"_id": id, "domains.domain": domain}
+	update := bson.M{"$pull": bson.M{"domains": bson.M{"domain": domain}}, "$inc": bson.M{"version": 1}}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if result.ModifiedCount == 0 {
+		return errors.New("domain is not claimed by this organization")
 	}
 
 	return nil
 }
 
-func removeOrgFromUsers(orgId string) error {
-	collection := client.Database(mongoDB).Collection(types.USER_COLL)
+func (m *Mongo) ReadOrgsByVerifiedDomain(ctx context.Context, domain string) ([]types.Org, error) {
+	filter := bson.M{
+		"domains":     bson.M{"$elemMatch": bson.M{"domain": domain, "verified": true}},
+		"archived_at": notArchived,
+	}
+
+	return m.findOrgs(ctx, filter)
+}
 
-	cursor, err := collection.Find(ctx, bson.M{})
+func (m *Mongo) UpdateOrgQuota(ctx context.Context, orgId string, quota types.OrgQuota) error {
+	collection := m.db.Collection(types.ORG_COLL)
+	id, err := primitive.ObjectIDFromHex(orgId)
 	if err != nil {
 		return err
 	}
 
-	var users []types.User
-	for cursor.Next(context.Background()) {
-		var user types.User
-		err := cursor.Decode(&user)
-		if err != nil {
-			return err
-		}
-		users = append(users, user)
+	filter := bson.M{"_id": id}
+	update := bson.M{"$set": bson.M{"quota": quota}, "$inc": bson.M{"version": 1}}
+
+	_, err = collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
 	}
 
-	var updateModels []mongo.WriteModel
+	return nil
+}
+
+func (m *Mongo) MoveOrg(ctx context.Context, orgId, parentId string) error {
+	collection := m.db.Collection(types.ORG_COLL)
+	id, err := primitive.ObjectIDFromHex(orgId)
+	if err != nil {
+		return err
+	}
 
-	for i, user := range users {
-		for j, org := range user.Orgs {
-			if org == orgId {
-				orgs := append(users[i].Orgs[:j], users[i].Orgs[j+1:]...)
-				update := bson.D{
-					{Key: "$set", Value: bson.D{
-						{Key: "organizations", Value: orgs},
-					}},
-				}
-				updateModels = append(updateModels, mongo.NewUpdateOneModel().SetFilter(bson.M{"email": user.Email}).SetUpdate(update))
-			}
-		}
+	filter := bson.M{"_id": id}
+	update := bson.M{"$set": bson.M{"parent_id": parentId}}
+	if parentId == "" {
+		update = bson.M{"$unset": bson.M{"parent_id": ""}}
 	}
+	update["$inc"] = bson.M{"version": 1}
 
-	_, err = collection.BulkWrite(context.Background(), updateModels)
+	_, err = collection.UpdateOne(ctx, filter, update)
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// DeleteOrg archives the organization, it disappears from every read until it
+// is restored, or purged for good by PurgeArchivedOrgs.
+func (m *Mongo) DeleteOrg(ctx context.Context, orgId string) error {
+	collection := m.db.Collection(types.ORG_COLL)
+	id, err := primitive.ObjectIDFromHex(orgId)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{"_id": id, "archived_at": notArchived}
+	update := bson.M{"$set": bson.M{"archived_at": time.Now()}, "$inc": bson.M{"version": 1}}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrOrgNotFound
+	}
+
+	return nil
+}
+
+// RestoreOrg brings back an organization archived after the given time.
+func (m *Mongo) RestoreOrg(ctx context.Context, orgId string, archivedAfter time.Time) error {
+	collection := m.db.Collection(types.ORG_COLL)
+	id, err := primitive.ObjectIDFromHex(orgId)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{"_id": id, "archived_at": bson.M{"$gte": archivedAfter}}
+	update := bson.M{"$unset": bson.M{"archived_at": ""}, "$inc": bson.M{"version": 1}}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return errors.New("org is not archived or its retention period is over")
+	}
+
+	return nil
+}
+
+// PurgeArchivedOrgs permanently deletes the organizations archived before the
+// given time and returns the purged organizations.
+func (m *Mongo) PurgeArchivedOrgs(ctx context.Context, archivedBefore time.Time) ([]types.Org, error) {
+	orgs, err := m.findOrgs(ctx, bson.M{"archived_at": bson.M{"$lt": archivedBefore}})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, org := range orgs {
+		err = m.purgeOrg(ctx, org.OrgId)
+		if err != nil {
+			return orgs[:i], err
+		}
+	}
+
+	return orgs, nil
+}
+
+// InviteUserToOrg reserves room in the member quota and stores the membership
+// in one transaction, so concurrent invitations can neither exceed the quota
+// nor add the same member twice.
+func (m *Mongo) InviteUserToOrg(ctx context.Context, orgId string, member types.OrgMember, version int) error {
+	return m.withTransaction(ctx, func(ctx mongo.SessionContext) error {
+		return m.inviteUsersToOrg(ctx, orgId, []types.OrgMember{member}, version)
+	})
+}
+
+// InviteUsersToOrg adds many members at once with a single quota reservation
+// and a single insert of all the memberships, both in one transaction.
+func (m *Mongo) InviteUsersToOrg(ctx context.Context, orgId string, members []types.OrgMember) error {
+	if len(members) == 0 {
+		return nil
+	}
+
+	return m.withTransaction(ctx, func(ctx mongo.SessionContext) error {
+		return m.inviteUsersToOrg(ctx, orgId, members, 0)
+	})
+}
+
+func (m *Mongo) inviteUsersToOrg(ctx context.Context, orgId string, members []types.OrgMember, version int) error {
+	err := m.bumpOrgVersion(ctx, orgId, version)
+	if err != nil {
+		return err
+	}
+
+	err = m.reserveOrgUsage(ctx, orgId, "members_count", "max_members", len(members))
+	if err != nil {
+		return err
+	}
+
+	names, err := m.lowerUserNames(ctx, members)
+	if err != nil {
+		return err
+	}
+
+	documents := make([]interface{}, len(members))
+	for i, member := range members {
+		documents[i] = types.Membership{
+			OrgId:       orgId,
+			Email:       member.Email,
+			AccessLevel: member.AccessLevel,
+			NameLower:   names[member.Email],
+		}
+	}
+
+	collection := m.db.Collection(types.MEMBERSHIP_COLL)
+
+	_, err = collection.InsertMany(ctx, documents)
+	if mongo.IsDuplicateKeyError(err) {
+		return errors.New("user already exists in this organization")
+	}
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RemoveUserFromOrg drops the membership and the member from every team and
+// project of that organization, in one transaction.
+func (m *Mongo) RemoveUserFromOrg(ctx context.Context, orgId, email string, version int) error {
+	return m.withTransaction(ctx, func(ctx mongo.SessionContext) error {
+		err := m.bumpOrgVersion(ctx, orgId, version)
+		if err != nil {
+			return err
+		}
+
+		collection := m.db.Collection(types.MEMBERSHIP_COLL)
+		filter := bson.M{"organization_id": orgId, "email": email}
+
+		result, err := collection.DeleteOne(ctx, filter)
+		if err != nil {
+			return err
+		}
+
+		err = m.releaseOrgUsage(ctx, orgId, "members_count", int(result.DeletedCount))
+		if err != nil {
+			return err
+		}
+
+		collection = m.db.Collection(types.TEAM_COLL)
+		filter = bson.M{"organization_id": orgId}
+		update := bson.M{"$pull": bson.M{"team_members": bson.M{"email": email}}}
+
+		_, err = collection.UpdateMany(ctx, filter, update)
+		if err != nil {
+			return err
+		}
+
+		collection = m.db.Collection(types.PROJECT_COLL)
+		update = bson.M{"$pull": bson.M{"project_members": bson.M{"email": email}}}
+
+		_, err = collection.UpdateMany(ctx, filter, update)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
+
+// ReadOrgMembers returns one page of the members of the organization matching
+// the query. Members are compared and sorted case-insensitively and the email
+// breaks ties, so the pages stay stable while members are added or removed.
+func (m *Mongo) ReadOrgMembers(ctx context.Context, orgId string, query types.MemberQuery) ([]types.OrgMember, error) {
+	collection := m.db.Collection(types.MEMBERSHIP_COLL)
+
+	order, after := 1, "$gt"
+	if query.Descending {
+		order, after = -1, "$lt"
+	}
+
+	// names are matched and sorted on the lowercased copy stored with each
+	// membership, so a page is read through the organization_id indexes and
+	// the names are only joined for the members it holds
+	sortKey := "name_lower"
+	if query.SortBy == "email" {
+		sortKey = "email"
+	}
+
+	conditions := bson.A{bson.M{"organization_id": orgId}}
+	if query.Role != "" {
+		conditions = append(conditions, bson.M{"access_level": query.Role})
+	}
+
+	if len(query.Emails) > 0 {
+		conditions = append(conditions, bson.M{"email": bson.M{"$in": query.Emails}})
+	}
+
+	if query.Prefix != "" {
+		prefix := primitive.Regex{Pattern: "^" + regexp.QuoteMeta(strings.ToLower(query.Prefix))}
+		conditions = append(conditions, bson.M{"$or": bson.A{
+			bson.M{"name_lower": prefix},
+			bson.M{"email": prefix},
+		}})
+	}
+
+	// emails are unique within the organization, they need no tie-breaker
+	sort := bson.D{{Key: sortKey, Value: order}, {Key: "email", Value: order}}
+	if sortKey == "email" {
+		sort = bson.D{{Key: "email", Value: order}}
+	}
+
+	if query.After != nil && sortKey == "email" {
+		conditions = append(conditions, bson.M{"email": bson.M{after: strings.ToLower(query.After.Email)}})
+	} else if query.After != nil {
+		key, email := strings.ToLower(query.After.Key), strings.ToLower(query.After.Email)
+		conditions = append(conditions, bson.M{"$or": bson.A{
+			bson.M{sortKey: bson.M{after: key}},
+			bson.M{sortKey: key, "email": bson.M{after: email}},
+		}})
+	}
+
+	pipeline := append(bson.A{
+		bson.M{"$match": bson.M{"$and": conditions}},
+		bson.M{"$sort": sort},
+		bson.M{"$limit": query.Limit},
+	}, memberNameStages...)
+
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	members := []types.OrgMember{}
+	err = cursor.All(ctx, &members)
+	if err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+func (m *Mongo) IsOrgMember(ctx context.Context, orgId, email string) bool {
+	collection := m.db.Collection(types.MEMBERSHIP_COLL)
+	filter := bson.M{"organization_id": orgId, "email": email}
+
+	count, err := collection.CountDocuments(ctx, filter)
+
+	return err == nil && count > 0
+}
+
+func (m *Mongo) CreateInvitation(ctx context.Context, invitation types.Invitation) error {
+	return m.CreateInvitations(ctx, []types.Invitation{invitation})
+}
+
+func (m *Mongo) CreateInvitations(ctx context.Context, invitations []types.Invitation) error {
+	if len(invitations) == 0 {
+		return nil
+	}
+
+	return m.withTransaction(ctx, func(ctx mongo.SessionContext) error {
+		return m.createInvitations(ctx, invitations[0].OrgId, invitations)
+	})
+}
+
+// ImportMembersToOrg adds the members and creates the invitations of an import
+// in one transaction, a quota exceeded by either leaves nothing written.
+func (m *Mongo) ImportMembersToOrg(ctx context.Context, orgId string, members []types.OrgMember, invitations []types.Invitation, version int) error {
+	return m.withTransaction(ctx, func(ctx mongo.SessionContext) error {
+		if len(members) > 0 {
+			err := m.inviteUsersToOrg(ctx, orgId, members, version)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(invitations) > 0 {
+			return m.createInvitations(ctx, orgId, invitations)
+		}
+
+		return nil
+	})
+}
+
+func (m *Mongo) createInvitations(ctx context.Context, orgId string, invitations []types.Invitation) error {
+	err := checkInvitationsOrg(orgId, invitations)
+	if err != nil {
+		return err
+	}
+
+	err = m.reserveOrgUsage(ctx, orgId, "pending_invites", "max_pending_invites", len(invitations))
+	if err != nil {
+		return err
+	}
+
+	documents := make([]interface{}, len(invitations))
+	for i, invitation := range invitations {
+		documents[i] = invitation
+	}
+
+	collection := m.db.Collection(types.INVITATION_COLL)
+	_, err = collection.InsertMany(ctx, documents)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *Mongo) ReadOrgInvitations(ctx context.Context, orgId string) ([]types.Invitation, error) {
+	collection := m.db.Collection(types.INVITATION_COLL)
+	filter := bson.M{"organization_id": orgId}
+
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var invitations []types.Invitation
+	err = cursor.All(ctx, &invitations)
+	if err != nil {
+		return nil, err
+	}
+
+	return invitations, nil
+}
+
+func (m *Mongo) ReadInvitations(ctx context.Context, email string) ([]types.Invitation, error) {
+	collection := m.db.Collection(types.INVITATION_COLL)
+	filter := bson.M{"email": email}
+
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var invitations []types.Invitation
+	err = cursor.All(ctx, &invitations)
+	if err != nil {
+		return nil, err
+	}
+
+	return invitations, nil
+}
+
+func (m *Mongo) IsInvitedToOrg(ctx context.Context, orgId, email string) bool {
+	collection := m.db.Collection(types.INVITATION_COLL)
+	filter := bson.M{"organization_id": orgId, "email": email}
+
+	count, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return false
+	}
+
+	return count > 0
+}
+
+// DeleteInvitation drops the invitations of the email and releases their
+// pending invites usage, in one transaction.
+func (m *Mongo) DeleteInvitation(ctx context.Context, orgId, email string) error {
+	collection := m.db.Collection(types.INVITATION_COLL)
+	filter := bson.M{"organization_id": orgId, "email": email}
+
+	return m.withTransaction(ctx, func(ctx mongo.SessionContext) error {
+		result, err := collection.DeleteMany(ctx, filter)
+		if err != nil {
+			return err
+		}
+
+		return m.releaseOrgUsage(ctx, orgId, "pending_invites", int(result.DeletedCount))
+	})
+}
+
+func (m *Mongo) CreateInviteLink(ctx context.Context, link types.InviteLink) error {
+	collection := m.db.Collection(types.INVITE_LINK_COLL)
+	_, err := collection.InsertOne(ctx, link)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *Mongo) ReadInviteLinks(ctx context.Context, orgId string) ([]types.InviteLink, error) {
+	collection := m.db.Collection(types.INVITE_LINK_COLL)
+	filter := bson.M{"organization_id": orgId}
+
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var links []types.InviteLink
+	err = cursor.All(ctx, &links)
+	if err != nil {
+		return nil, err
+	}
+
+	return links, nil
+}
+
+func (m *Mongo) DeleteInviteLink(ctx context.Context, orgId, code string) error {
+	collection := m.db.Collection(types.INVITE_LINK_COLL)
+	filter := bson.M{"organization_id": orgId, "code": code}
+
+	result, err := collection.DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return errors.New("invite link doesn't exists")
+	}
+
+	return nil
+}
+
+// RedeemInviteLink consumes one use of the link in a single conditional update,
+// so concurrent redemptions can never exceed the link's maximum number of uses.
+func (m *Mongo) RedeemInviteLink(ctx context.Context, code string) (types.InviteLink, error) {
+	collection := m.db.Collection(types.INVITE_LINK_COLL)
+	filter := bson.M{
+		"code":       code,
+		"expires_at": bson.M{"$gt": time.Now()},
+		"$expr":      bson.M{"$lt": bson.A{"$uses", "$max_uses"}},
+	}
+	update := bson.M{"$inc": bson.M{"uses": 1}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var link types.InviteLink
+	err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&link)
+	if err == mongo.ErrNoDocuments {
+		return types.InviteLink{}, errors.New("invite link is invalid, expired or used up")
+	}
+	if err != nil {
+		return types.InviteLink{}, err
+	}
+
+	return link, nil
+}
+
+func (m *Mongo) ReleaseInviteLink(ctx context.Context, code string) error {
+	collection := m.db.Collection(types.INVITE_LINK_COLL)
+	filter := bson.M{"code": code, "uses": bson.M{"$gt": 0}}
+	update := bson.M{"$inc": bson.M{"uses": -1}}
+
+	_, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CreateTeam reserves a team of the quota and inserts the team, in one
+// transaction.
+func (m *Mongo) CreateTeam(ctx context.Context, team types.Team) (string, error) {
+	collection := m.db.Collection(types.TEAM_COLL)
+
+	if team.Members == nil {
+		team.Members = []types.TeamMember{}
+	}
+
+	var id string
+	err := m.withTransaction(ctx, func(ctx mongo.SessionContext) error {
+		err := m.reserveOrgUsage(ctx, team.OrgId, "teams_count", "max_teams", 1)
+		if err != nil {
+			return err
+		}
+
+		result, err := collection.InsertOne(ctx, team)
+		if err != nil {
+			return err
+		}
+
+		id = result.InsertedID.(primitive.ObjectID).Hex()
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+func (m *Mongo) ReadTeam(ctx context.Context, orgId, teamId string) (types.Team, error) {
+	collection := m.db.Collection(types.TEAM_COLL)
+	id, err := primitive.ObjectIDFromHex(teamId)
+	if err != nil {
+		return types.Team{}, err
+	}
+
+	filter := bson.M{"_id": id, "organization_id": orgId}
+
+	var team types.Team
+	err = collection.FindOne(ctx, filter).Decode(&team)
+	if err == mongo.ErrNoDocuments {
+		return types.Team{}, errors.New("team doesn't exists")
+	}
+	if err != nil {
+		return types.Team{}, err
+	}
+
+	team.TeamId = teamId
+
+	return team, nil
+}
+
+func (m *Mongo) ReadOrgsTeams(ctx context.Context, orgIds []string) ([]types.Team, error) {
+	collection := m.db.Collection(types.TEAM_COLL)
+	filter := bson.M{"organization_id": bson.M{"$in": orgIds}}
+
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var teams []types.Team
+	for cursor.Next(ctx) {
+		var document struct {
+			Id         primitive.ObjectID `bson:"_id"`
+			types.Team `bson:",inline"`
+		}
+		err := cursor.Decode(&document)
+		if err != nil {
+			return nil, err
+		}
+
+		document.Team.TeamId = document.Id.Hex()
+
+		teams = append(teams, document.Team)
+	}
+
+	return teams, nil
+}
+
+func (m *Mongo) UpdateTeam(ctx context.Context, team types.Team) error {
+	collection := m.db.Collection(types.TEAM_COLL)
+	id, err := primitive.ObjectIDFromHex(team.TeamId)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{"_id": id, "organization_id": team.OrgId}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "name", Value: team.Name},
+			{Key: "description", Value: team.Description},
+		}},
+	}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return errors.New("team doesn't exists")
+	}
+
+	return nil
+}
+
+// DeleteTeam drops the team and releases its quota usage, in one transaction.
+func (m *Mongo) DeleteTeam(ctx context.Context, orgId, teamId string) error {
+	collection := m.db.Collection(types.TEAM_COLL)
+	id, err := primitive.ObjectIDFromHex(teamId)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{"_id": id, "organization_id": orgId}
+
+	return m.withTransaction(ctx, func(ctx mongo.SessionContext) error {
+		result, err := collection.DeleteOne(ctx, filter)
+		if err != nil {
+			return err
+		}
+
+		if result.DeletedCount == 0 {
+			return errors.New("team doesn't exists")
+		}
+
+		return m.releaseOrgUsage(ctx, orgId, "teams_count", 1)
+	})
+}
+
+func (m *Mongo) AddTeamMember(ctx context.Context, orgId, teamId string, member types.TeamMember) error {
+	collection := m.db.Collection(types.TEAM_COLL)
+	id, err := primitive.ObjectIDFromHex(teamId)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{
+		"_id":                id,
+		"organization_id":    orgId,
+		"team_members.email": bson.M{"$ne": member.Email},
+	}
+	update := bson.M{"$push": bson.M{"team_members": member}}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return errors.New("user already exists in this team")
+	}
+
+	return nil
+}
+
+func (m *Mongo) RemoveTeamMember(ctx context.Context, orgId, teamId, email string) error {
+	collection := m.db.Collection(types.TEAM_COLL)
+	id, err := primitive.ObjectIDFromHex(teamId)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{"_id": id, "organization_id": orgId}
+	update := bson.M{"$pull": bson.M{"team_members": bson.M{"email": email}}}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if result.ModifiedCount == 0 {
+		return errors.New("user is not a team member")
+	}
+
+	return nil
+}
+
+func (m *Mongo) CreateProject(ctx context.Context, project types.Project) (string, error) {
+	collection := m.db.Collection(types.PROJECT_COLL)
+
+	if project.Members == nil {
+		project.Members = []types.ProjectMember{}
+	}
+
+	result, err := collection.InsertOne(ctx, project)
+	if err != nil {
+		return "", err
+	}
+
+	return result.InsertedID.(primitive.ObjectID).Hex(), nil
+}
+
+func (m *Mongo) ReadProject(ctx context.Context, orgId, projectId string) (types.Project, error) {
+	collection := m.db.Collection(types.PROJECT_COLL)
+	id, err := primitive.ObjectIDFromHex(projectId)
+	if err != nil {
+		return types.Project{}, err
+	}
+
+	filter := bson.M{"_id": id, "organization_id": orgId}
+
+	var project types.Project
+	err = collection.FindOne(ctx, filter).Decode(&project)
+	if err == mongo.ErrNoDocuments {
+		return types.Project{}, errors.New("project doesn't exists")
+	}
+	if err != nil {
+		return types.Project{}, err
+	}
+
+	project.ProjectId = projectId
+
+	return project, nil
+}
+
+func (m *Mongo) ReadOrgProjects(ctx context.Context, orgId string) ([]types.Project, error) {
+	collection := m.db.Collection(types.PROJECT_COLL)
+	filter := bson.M{"organization_id": orgId}
+
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var projects []types.Project
+	for cursor.Next(ctx) {
+		var document struct {
+			Id            primitive.ObjectID `bson:"_id"`
+			types.Project `bson:",inline"`
+		}
+		err := cursor.Decode(&document)
+		if err != nil {
+			return nil, err
+		}
+
+		document.Project.ProjectId = document.Id.Hex()
+
+		projects = append(projects, document.Project)
+	}
+
+	return projects, nil
+}
+
+func (m *Mongo) UpdateProject(ctx context.Context, project types.Project) error {
+	collection := m.db.Collection(types.PROJECT_COLL)
+	id, err := primitive.ObjectIDFromHex(project.ProjectId)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{"_id": id, "organization_id": project.OrgId}
+	update := bson.M{"$set": bson.M{
+		"name":        project.Name,
+		"description": project.Description,
+	}}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return errors.New("project doesn't exists")
+	}
+
+	return nil
+}
+
+func (m *Mongo) DeleteProject(ctx context.Context, orgId, projectId string) error {
+	collection := m.db.Collection(types.PROJECT_COLL)
+	id, err := primitive.ObjectIDFromHex(projectId)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{"_id": id, "organization_id": orgId}
+
+	result, err := collection.DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return errors.New("project doesn't exists")
+	}
+
+	return nil
+}
+
+// SetProjectMember adds or replaces the access level override of a member,
+// the pull and the push run in one update so the member is never listed twice.
+func (m *Mongo) SetProjectMember(ctx context.Context, orgId, projectId string, member types.ProjectMember) error {
+	collection := m.db.Collection(types.PROJECT_COLL)
+	id, err := primitive.ObjectIDFromHex(projectId)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{"_id": id, "organization_id": orgId}
+	update := bson.A{
+		bson.M{"$set": bson.M{"project_members": bson.M{"$concatArrays": bson.A{
+			bson.M{"$filter": bson.M{
+				"input": "$project_members",
+				"cond":  bson.M{"$ne": bson.A{"$$this.email", member.Email}},
+			}},
+			bson.A{bson.M{"$literal": member}},
+		}}}},
+	}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return errors.New("project doesn't exists")
+	}
+
+	return nil
+}
+
+func (m *Mongo) RemoveProjectMember(ctx context.Context, orgId, projectId, email string) error {
+	collection := m.db.Collection(types.PROJECT_COLL)
+	id, err := primitive.ObjectIDFromHex(projectId)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{"_id": id, "organization_id": orgId}
+	update := bson.M{"$pull": bson.M{"project_members": bson.M{"email": email}}}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if result.ModifiedCount == 0 {
+		return errors.New("user has no override in this project")
+	}
+
+	return nil
+}
+
+func (m *Mongo) CreateJoinRequest(ctx context.Context, joinRequest types.JoinRequest) (string, error) {
+	collection := m.db.Collection(types.JOIN_REQUEST_COLL)
+
+	result, err := collection.InsertOne(ctx, joinRequest)
+	if err != nil {
+		return "", err
+	}
+
+	return result.InsertedID.(primitive.ObjectID).Hex(), nil
+}
+
+func (m *Mongo) ReadPendingJoinRequests(ctx context.Context, orgId string) ([]types.JoinRequest, error) {
+	collection := m.db.Collection(types.JOIN_REQUEST_COLL)
+	filter := bson.M{"organization_id": orgId, "status": types.JOIN_REQUEST_STATUS_PENDING}
+
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var joinRequests []types.JoinRequest
+	for cursor.Next(ctx) {
+		var document struct {
+			Id                primitive.ObjectID `bson:"_id"`
+			types.JoinRequest `bson:",inline"`
+		}
+		err := cursor.Decode(&document)
+		if err != nil {
+			return nil, err
+		}
+
+		document.JoinRequest.RequestId = document.Id.Hex()
+
+		joinRequests = append(joinRequests, document.JoinRequest)
+	}
+
+	return joinRequests, nil
+}
+
+func (m *Mongo) HasPendingJoinRequest(ctx context.Context, orgId, email string) bool {
+	collection := m.db.Collection(types.JOIN_REQUEST_COLL)
+	filter := bson.M{
+		"organization_id": orgId,
+		"email":           email,
+		"status":          types.JOIN_REQUEST_STATUS_PENDING,
+	}
+
+	count, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return false
+	}
+
+	return count > 0
+}
+
+// SetJoinRequestStatus moves a join request from the expected status to the new
+// one in a single conditional update, so a request is resolved at most once.
+func (m *Mongo) SetJoinRequestStatus(ctx context.Context, orgId, requestId, from, to string) (types.JoinRequest, error) {
+	collection := m.db.Collection(types.JOIN_REQUEST_COLL)
+	id, err := primitive.ObjectIDFromHex(requestId)
+	if err != nil {
+		return types.JoinRequest{}, err
+	}
+
+	filter := bson.M{"_id": id, "organization_id": orgId, "status": from}
+	update := bson.M{"$set": bson.M{"status": to}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var joinRequest types.JoinRequest
+	err = collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&joinRequest)
+	if err == mongo.ErrNoDocuments {
+		return types.JoinRequest{}, errors.New("join request doesn't exists or is already resolved")
+	}
+	if err != nil {
+		return types.JoinRequest{}, err
+	}
+
+	joinRequest.RequestId = requestId
+
+	return joinRequest, nil
+}
+
+// ====================== helper private function ====================== //
+
+// purgeOrg hard deletes an organization and everything that belongs to it, the
+// organization document goes last and all in one transaction so a failed purge
+// leaves no orphaned documents.
+func (m *Mongo) purgeOrg(ctx context.Context, orgId string) error {
+	return m.withTransaction(ctx, func(ctx mongo.SessionContext) error {
+		// the parent is read again, purging an ancestor first may have changed it
+		org, err := m.readAnyOrg(ctx, orgId)
+		if err != nil {
+			return err
+		}
+
+		err = m.reparentOrgChildren(ctx, orgId, org.ParentId)
+		if err != nil {
+			return err
+		}
+
+		err = m.removeOrgMemberships(ctx, orgId)
+		if err != nil {
+			return err
+		}
+
+		err = m.removeOrgInvitations(ctx, orgId)
+		if err != nil {
+			return err
+		}
+
+		err = m.removeOrgInviteLinks(ctx, orgId)
+		if err != nil {
+			return err
+		}
+
+		err = m.removeOrgTeams(ctx, orgId)
+		if err != nil {
+			return err
+		}
+
+		err = m.removeOrgProjects(ctx, orgId)
+		if err != nil {
+			return err
+		}
+
+		err = m.removeOrgJoinRequests(ctx, orgId)
+		if err != nil {
+			return err
+		}
+
+		id, err := primitive.ObjectIDFromHex(orgId)
+		if err != nil {
+			return err
+		}
+
+		collection := m.db.Collection(types.ORG_COLL)
+		_, err = collection.DeleteOne(ctx, bson.M{"_id": id})
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
+
+func (m *Mongo) findOrg(ctx context.Context, filter interface{}) (types.Org, error) {
+	collection := m.db.Collection(types.ORG_COLL)
+
+	var document struct {
+		Id        primitive.ObjectID `bson:"_id"`
+		types.Org `bson:",inline"`
+	}
+	err := collection.FindOne(ctx, filter).Decode(&document)
+	if err == mongo.ErrNoDocuments {
+		return types.Org{}, ErrOrgNotFound
+	}
+	if err != nil {
+		return types.Org{}, err
+	}
+
+	document.Org.OrgId = document.Id.Hex()
+
+	return document.Org, nil
+}
+
+func (m *Mongo) readAnyOrg(ctx context.Context, orgId string) (types.Org, error) {
+	id, err := primitive.ObjectIDFromHex(orgId)
+	if err != nil {
+		return types.Org{}, err
+	}
+
+	return m.findOrg(ctx, bson.M{"_id": id})
+}
+
+// withTransaction runs fn in a transaction, the driver retries the whole
+// transaction on transient errors and the commit on unknown commit results.
+func (m *Mongo) withTransaction(ctx context.Context, fn func(ctx mongo.SessionContext) error) error {
+	session, err := m.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	transactionOptions := options.Transaction().SetWriteConcern(writeconcern.Majority())
+	_, err = session.WithTransaction(ctx, func(sessionCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessionCtx)
+	}, transactionOptions)
+
+	return err
+}
+
+// checkTransactions fails when the server cannot run transactions, which
+// only replica sets and sharded clusters support.
+func (m *Mongo) checkTransactions(ctx context.Context) error {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+
+	err := m.client.Database("admin").RunCommand(ctx, bson.M{"hello": 1}).Decode(&hello)
+	if err != nil {
+		return err
+	}
+
+	if hello.SetName == "" && hello.Msg != "isdbgrid" {
+		return errors.New("mongo must run as a replica set or sharded cluster to support transactions")
+	}
+
+	return nil
+}
+
+func (m *Mongo) removeOrgMemberships(ctx context.Context, orgId string) error {
+	collection := m.db.Collection(types.MEMBERSHIP_COLL)
+	filter := bson.M{"organization_id": orgId}
+
+	_, err := collection.DeleteMany(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *Mongo) removeOrgInvitations(ctx context.Context, orgId string) error {
+	collection := m.db.Collection(types.INVITATION_COLL)
+	filter := bson.M{"organization_id": orgId}
+
+	_, err := collection.DeleteMany(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *Mongo) removeOrgInviteLinks(ctx context.Context, orgId string) error {
+	collection := m.db.Collection(types.INVITE_LINK_COLL)
+	filter := bson.M{"organization_id": orgId}
+
+	_, err := collection.DeleteMany(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *Mongo) removeOrgTeams(ctx context.Context, orgId string) error {
+	collection := m.db.Collection(types.TEAM_COLL)
+	filter := bson.M{"organization_id": orgId}
+
+	_, err := collection.DeleteMany(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *Mongo) removeOrgProjects(ctx context.Context, orgId string) error {
+	collection := m.db.Collection(types.PROJECT_COLL)
+	filter := bson.M{"organization_id": orgId}
+
+	_, err := collection.DeleteMany(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *Mongo) findOrgs(ctx context.Context, filter interface{}, opts ...*options.FindOptions) ([]types.Org, error) {
+	collection := m.db.Collection(types.ORG_COLL)
+
+	cursor, err := collection.Find(ctx, filter, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var orgs []types.Org
+	for cursor.Next(ctx) {
+		var document struct {
+			Id        primitive.ObjectID `bson:"_id"`
+			types.Org `bson:",inline"`
+		}
+		err := cursor.Decode(&document)
+		if err != nil {
+			return nil, err
+		}
+
+		document.Org.OrgId = document.Id.Hex()
+
+		orgs = append(orgs, document.Org)
+	}
+
+	return orgs, cursor.Err()
+}
+
+// reparentOrgChildren attaches the children of a deleted organization to its
+// own parent, so deleting a node never orphans a whole subtree.
+func (m *Mongo) reparentOrgChildren(ctx context.Context, orgId, parentId string) error {
+	collection := m.db.Collection(types.ORG_COLL)
+	filter := bson.M{"parent_id": orgId}
+	update := bson.M{"$set": bson.M{"parent_id": parentId}}
+	if parentId == "" {
+		update = bson.M{"$unset": bson.M{"parent_id": ""}}
+	}
+	update["$inc"] = bson.M{"version": 1}
+
+	_, err := collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *Mongo) removeOrgJoinRequests(ctx context.Context, orgId string) error {
+	collection := m.db.Collection(types.JOIN_REQUEST_COLL)
+	filter := bson.M{"organization_id": orgId}
+
+	_, err := collection.DeleteMany(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// withinQuota builds an aggregation expression that holds when adding n to the
+// given usage stays within the quota limit, a missing or zero limit always holds.
+func withinQuota(limit string, usage interface{}, n int) bson.M {
+	maxUsage := bson.M{"$ifNull": bson.A{"$quota." + limit, 0}}
+
+	return bson.M{"$or": bson.A{
+		bson.M{"$lte": bson.A{maxUsage, 0}},
+		bson.M{"$lte": bson.A{bson.M{"$add": bson.A{usage, n}}, maxUsage}},
+	}}
+}
+
+// lowerUserNames maps the email of each member to the lowercased name of their
+// user, the sort key stored with their membership.
+func (m *Mongo) lowerUserNames(ctx context.Context, members []types.OrgMember) (map[string]string, error) {
+	emails := make([]string, len(members))
+	for i, member := range members {
+		emails[i] = member.Email
+	}
+
+	users, err := m.ReadUsers(ctx, emails)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]string, len(users))
+	for _, user := range users {
+		names[user.Email] = strings.ToLower(user.Name)
+	}
+
+	return names, nil
+}
+
+// reserveOrgUsage increments a usage counter of the organization only if the
+// result stays within its quota, in a single conditional update. Counters
+// aren't part of the version, they change with every invitation and team.
+func (m *Mongo) reserveOrgUsage(ctx context.Context, orgId, counter, limit string, n int) error {
+	collection := m.db.Collection(types.ORG_COLL)
+	id, err := primitive.ObjectIDFromHex(orgId)
+	if err != nil {
+		return err
+	}
+
+	usage := bson.M{"$ifNull": bson.A{"$" + counter, 0}}
+	filter := bson.M{"_id": id, "archived_at": notArchived, "$expr": withinQuota(limit, usage, n)}
+	update := bson.M{"$inc": bson.M{counter: n}}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		org, err := m.ReadOrg(ctx, orgId)
+		if err != nil {
+			return err
+		}
+
+		return quotaError(org.Quota, limit)
+	}
+
+	return nil
+}
+
+// bumpOrgVersion increments the version of the organization. A non-zero
+// version must be the current one, ErrOrgModified is returned otherwise.
+func (m *Mongo) bumpOrgVersion(ctx context.Context, orgId string, version int) error {
+	collection := m.db.Collection(types.ORG_COLL)
+	id, err := primitive.ObjectIDFromHex(orgId)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{"_id": id}
+	if version != 0 {
+		filter["version"] = orgVersionFilter(version)
+	}
+
+	result, err := collection.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"version": 1}})
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 && version != 0 {
+		return ErrOrgModified
+	}
+
+	return nil
+}
+
+// orgVersionFilter matches the version, organizations written before versions
+// existed have no version field and match version 0.
+func orgVersionFilter(version int) interface{} {
+	if version == 0 {
+		return bson.M{"$in": bson.A{0, nil}}
+	}
+
+	return version
+}
+
+// quotaError describes the limit of the quota that a reservation exceeded.
+func quotaError(quota types.OrgQuota, limit string) error {
+	maxUsage := quota.MaxTeams
+	switch limit {
+	case "max_members":
+		maxUsage = quota.MaxMembers
+	case "max_pending_invites":
+		maxUsage = quota.MaxPendingInvites
+	}
+
+	resource := strings.ReplaceAll(strings.TrimPrefix(limit, "max_"), "_", " ")
+
+	return fmt.Errorf("%w, the organization allows at most %d %s", ErrQuotaExceeded, maxUsage, resource)
+}
+
+func (m *Mongo) releaseOrgUsage(ctx context.Context, orgId, counter string, n int) error {
+	if n == 0 {
+		return nil
+	}
+
+	collection := m.db.Collection(types.ORG_COLL)
+	id, err := primitive.ObjectIDFromHex(orgId)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{"_id": id, counter: bson.M{"$gte": n}}
+	update := bson.M{"$inc": bson.M{counter: -n}}
+
+	_, err = collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// bootstrapSchema creates the indexes the repositories rely on, both for
+// uniqueness and for the lookups they run. It is idempotent and runs on every
+// connection. The unique index on the user emails is built by a migration, the
+// accounts stored before it may have to be merged first.
+func (m *Mongo) bootstrapSchema(ctx context.Context) error {
+	indexes := map[string][]mongo.IndexModel{
+		types.ORG_COLL: {
+			{
+				Keys: bson.D{{Key: "slug", Value: 1}},
+				Options: options.Index().SetUnique(true).
+					SetPartialFilterExpression(bson.M{"slug": bson.M{"$exists": true}}),
+			},
+			{
+				Keys:    bson.D{{Key: "slug_history", Value: 1}},
+				Options: options.Index().SetUnique(true).SetSparse(true),
+			},
+			{
+				Keys: bson.D{{Key: "parent_id", Value: 1}},
+			},
+			{
+				Keys: bson.D{{Key: "domains.domain", Value: 1}},
+			},
+			{
+				Keys:    bson.D{{Key: "archived_at", Value: 1}},
+				Options: options.Index().SetSparse(true),
+			},
+		},
+		types.MEMBERSHIP_COLL: {
+			// a user is a member of an organization at most once
+			{
+				Keys:    bson.D{{Key: "organization_id", Value: 1}, {Key: "email", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			{
+				Keys: bson.D{{Key: "email", Value: 1}},
+			},
+			// the member directory sorted by name
+			{
+				Keys: bson.D{{Key: "organization_id", Value: 1}, {Key: "name_lower", Value: 1}, {Key: "email", Value: 1}},
+			},
+		},
+		types.INVITATION_COLL: {
+			{
+				Keys: bson.D{{Key: "organization_id", Value: 1}, {Key: "email", Value: 1}},
+			},
+			{
+				Keys: bson.D{{Key: "email", Value: 1}},
+			},
+		},
+		types.INVITE_LINK_COLL: {
+			{
+				Keys:    bson.D{{Key: "code", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			{
+				Keys: bson.D{{Key: "organization_id", Value: 1}},
+			},
+		},
+		types.TEAM_COLL: {
+			{
+				Keys: bson.D{{Key: "organization_id", Value: 1}},
+			},
+		},
+		types.PROJECT_COLL: {
+			{
+				Keys: bson.D{{Key: "organization_id", Value: 1}},
+			},
+		},
+		types.JOIN_REQUEST_COLL: {
+			{
+				Keys: bson.D{{Key: "organization_id", Value: 1}, {Key: "status", Value: 1}},
+			},
+		},
+	}
+
+	for coll, models := range indexes {
+		_, err := m.db.Collection(coll).Indexes().CreateMany(ctx, models)
+		if err != nil {
+			return fmt.Errorf("creating %s indexes: %w", coll, err)
+		}
+	}
+
+	return nil
+}
+
+// slugRegex matches the base slug and its numbered variants (base-2, base-3...).
+func slugRegex(baseSlug string) *regexp.Regexp {
+	return regexp.MustCompile("^" + regexp.QuoteMeta(baseSlug) + "(-[0-9]+)?$")
+}
+
+// availableSlug returns the base slug, or its first numbered variant that is
+// neither the current nor a previous slug of another organization than orgId.
+func (m *Mongo) availableSlug(ctx context.Context, baseSlug, orgId string) (string, error) {
+	pattern := primitive.Regex{Pattern: slugRegex(baseSlug).String()}
+	orgs, err := m.findOrgs(ctx, bson.M{"$or": bson.A{
+		bson.M{"slug": pattern},
+		bson.M{"slug_history": pattern},
+	}})
+	if err != nil {
+		return "", err
+	}
+
+	taken := make(map[string]bool)
+	for _, org := range orgs {
+		if org.OrgId == orgId {
+			continue
+		}
+
+		taken[org.Slug] = true
+		for _, slug := range org.SlugHistory {
+			taken[slug] = true
+		}
+	}
+
+	slug := baseSlug
+	for n := 2; taken[slug]; n++ {
+		slug = baseSlug + "-" + strconv.Itoa(n)
+	}
+
+	return slug, nil
+}
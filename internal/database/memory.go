@@ -0,0 +1,1442 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zaher1307/IDEANEST-project-assignment/internal/types"
+)
+
+// Memory keeps every repository in process memory, it is meant for tests and
+// the --storage=memory development mode and loses everything on restart.
+// A single mutex serializes all operations, which also makes every operation
+// atomic like the conditional updates of the Mongo implementation.
+type Memory struct {
+	mu           sync.Mutex
+	lastId       int
+	users        map[string]*types.User
+	orgs         map[string]*types.Org
+	memberships  map[string][]types.Membership // by organization ID
+	invitations  []types.Invitation
+	inviteLinks  map[string]*types.InviteLink
+	teams        map[string]*types.Team
+	projects     map[string]*types.Project
+	joinRequests map[string]*types.JoinRequest
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		users:        make(map[string]*types.User),
+		orgs:         make(map[string]*types.Org),
+		memberships:  make(map[string][]types.Membership),
+		inviteLinks:  make(map[string]*types.InviteLink),
+		teams:        make(map[string]*types.Team),
+		projects:     make(map[string]*types.Project),
+		joinRequests: make(map[string]*types.JoinRequest),
+	}
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
+
+func (m *Memory) CreateUser(ctx context.Context, user types.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[user.Email]; ok {
+		return ErrEmailExists
+	}
+
+	m.users[user.Email] = &user
+
+	return nil
+}
+
+func (m *Memory) ReadUser(ctx context.Context, email string) (types.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[email]
+	if !ok {
+		return types.User{}, nil
+	}
+
+	return *user, nil
+}
+
+func (m *Memory) ReadUsers(ctx context.Context, emails []string) ([]types.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var users []types.User
+	for _, email := range emails {
+		if user, ok := m.users[email]; ok {
+			users = append(users, *user)
+		}
+	}
+
+	return users, nil
+}
+
+func (m *Memory) UpdateUserEmail(ctx context.Context, email, newEmail string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[newEmail]; ok {
+		return ErrEmailExists
+	}
+
+	user, ok := m.users[email]
+	if ok {
+		delete(m.users, email)
+		user.Email = newEmail
+		m.users[newEmail] = user
+	}
+
+	for _, memberships := range m.memberships {
+		for i := range memberships {
+			if memberships[i].Email == email {
+				memberships[i].Email = newEmail
+			}
+		}
+	}
+
+	for _, team := range m.teams {
+		for i := range team.Members {
+			if team.Members[i].Email == email {
+				team.Members[i].Email = newEmail
+			}
+		}
+	}
+
+	for _, project := range m.projects {
+		for i := range project.Members {
+			if project.Members[i].Email == email {
+				project.Members[i].Email = newEmail
+			}
+		}
+	}
+
+	for _, joinRequest := range m.joinRequests {
+		if joinRequest.Email == email {
+			joinRequest.Email = newEmail
+		}
+	}
+
+	return nil
+}
+
+func (m *Memory) CreateOrg(ctx context.Context, org types.Org, user types.User) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	org = cloneOrg(org)
+	org.OrgId = m.newId()
+	org.Slug = m.availableSlug(org.Slug, "")
+	m.orgs[org.OrgId] = &org
+
+	member := types.OrgMember{
+		UserInfo: types.UserInfo{
+			Name:  user.Name,
+			Email: user.Email,
+		},
+		AccessLevel: types.ACCESS_LEVEL_ADMIN,
+	}
+
+	err := m.inviteUsersToOrg(org.OrgId, []types.OrgMember{member}, 0)
+	if err != nil {
+		delete(m.orgs, org.OrgId)
+		return "", err
+	}
+
+	return org.OrgId, nil
+}
+
+func (m *Memory) UpdateOrg(ctx context.Context, orgInfo types.OrgInfo) (types.OrgInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	org, err := m.org(orgInfo.OrgId)
+	if err != nil {
+		return types.OrgInfo{}, err
+	}
+
+	if orgInfo.Version != 0 && orgInfo.Version != org.Version {
+		return types.OrgInfo{}, ErrOrgModified
+	}
+
+	org.Version++
+	org.Name = orgInfo.Name
+	org.Description = orgInfo.Description
+
+	if orgInfo.Slug != org.Slug {
+		slug := m.availableSlug(orgInfo.Slug, org.OrgId)
+		if slug != org.Slug {
+			org.SlugHistory = renamedSlugHistory(org.SlugHistory, org.Slug, slug)
+			org.Slug = slug
+		}
+	}
+
+	return types.OrgInfo{
+		OrgId:       orgInfo.OrgId,
+		Slug:        org.Slug,
+		ParentId:    org.ParentId,
+		Name:        orgInfo.Name,
+		Description: orgInfo.Description,
+		Version:     org.Version,
+	}, nil
+}
+
+func (m *Memory) ResolveOrgSlug(ctx context.Context, slug string) (string, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, org := range m.sortedOrgs() {
+		if org.Slug == slug || contains(org.SlugHistory, slug) {
+			return org.OrgId, org.Slug, nil
+		}
+	}
+
+	return "", "", ErrOrgNotFound
+}
+
+func (m *Memory) ReadOrg(ctx context.Context, orgId string) (types.Org, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	org, err := m.org(orgId)
+	if err != nil {
+		return types.Org{}, err
+	}
+
+	return cloneOrg(*org), nil
+}
+
+func (m *Memory) ReadArchivedOrg(ctx context.Context, orgId string) (types.Org, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	org, ok := m.orgs[orgId]
+	if !ok || org.ArchivedAt == nil {
+		return types.Org{}, ErrOrgNotFound
+	}
+
+	return cloneOrg(*org), nil
+}
+
+func (m *Memory) IsOrgAdmin(ctx context.Context, orgId, email string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := m.org(orgId); err != nil {
+		return false, err
+	}
+
+	membership := m.membership(orgId, email)
+
+	return membership != nil && membership.AccessLevel == types.ACCESS_LEVEL_ADMIN, nil
+}
+
+func (m *Memory) ReadAllOrgsInfo(ctx context.Context, email string) ([]types.Org, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.findOrgs(func(org *types.Org) bool {
+		return org.ArchivedAt == nil && m.membership(org.OrgId, email) != nil
+	}), nil
+}
+
+func (m *Memory) ReadOrgAncestors(ctx context.Context, orgId string) ([]types.Org, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	org, ok := m.orgs[orgId]
+	if !ok {
+		return nil, ErrOrgNotFound
+	}
+
+	var ancestors []types.Org
+	visited := map[string]bool{orgId: true}
+	for org.ParentId != "" && !visited[org.ParentId] {
+		visited[org.ParentId] = true
+
+		org, ok = m.orgs[org.ParentId]
+		if !ok {
+			return nil, ErrOrgNotFound
+		}
+
+		if org.ArchivedAt == nil {
+			ancestors = append(ancestors, cloneOrg(*org))
+		}
+	}
+
+	return ancestors, nil
+}
+
+func (m *Memory) ReadOrgDescendants(ctx context.Context, orgId string) ([]types.Org, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var descendants []types.Org
+	visited := map[string]bool{orgId: true}
+	level := []string{orgId}
+	for len(level) > 0 {
+		parents := level
+		children := m.findOrgs(func(org *types.Org) bool {
+			return contains(parents, org.ParentId)
+		})
+
+		level = nil
+		for _, child := range children {
+			if visited[child.OrgId] {
+				continue
+			}
+			visited[child.OrgId] = true
+			level = append(level, child.OrgId)
+			if child.ArchivedAt == nil {
+				descendants = append(descendants, child)
+			}
+		}
+	}
+
+	return descendants, nil
+}
+
+func (m *Memory) UpdateOrgSettings(ctx context.Context, orgId string, settings types.OrgSettings) error {
+	return m.updateAnyOrg(orgId, func(org *types.Org) error {
+		org.OrgSettings = settings
+		return nil
+	})
+}
+
+func (m *Memory) UpdateOrgMetadata(ctx context.Context, orgId string, fields []types.MetadataField, metadata map[string]interface{}) error {
+	return m.updateAnyOrg(orgId, func(org *types.Org) error {
+		org.MetadataFields = append([]types.MetadataField(nil), fields...)
+		org.Metadata = cloneMetadata(metadata)
+		return nil
+	})
+}
+
+func (m *Memory) AddOrgTags(ctx context.Context, orgId string, tags []string, maxTags int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	org, err := m.org(orgId)
+	if err != nil {
+		return fmt.Errorf("org doesn't exists or would exceed %d tags", maxTags)
+	}
+
+	merged := append([]string(nil), org.Tags...)
+	for _, tag := range tags {
+		if !contains(merged, tag) {
+			merged = append(merged, tag)
+		}
+	}
+
+	if len(merged) > maxTags {
+		return fmt.Errorf("org doesn't exists or would exceed %d tags", maxTags)
+	}
+
+	org.Tags = merged
+	org.Version++
+
+	return nil
+}
+
+func (m *Memory) RemoveOrgTag(ctx context.Context, orgId, tag string) error {
+	return m.updateAnyOrg(orgId, func(org *types.Org) error {
+		if !contains(org.Tags, tag) {
+			return errors.New("org doesn't have this tag")
+		}
+
+		org.Tags = remove(org.Tags, tag)
+		return nil
+	})
+}
+
+func (m *Memory) UpdateOrgVisibility(ctx context.Context, orgId, visibility string) error {
+	return m.updateAnyOrg(orgId, func(org *types.Org) error {
+		org.Visibility = visibility
+		return nil
+	})
+}
+
+func (m *Memory) UpdateOrgLogo(ctx context.Context, orgId string, logo map[string]string) (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	org, err := m.org(orgId)
+	if err != nil {
+		return nil, err
+	}
+
+	previous := org.Logo
+	org.Logo = cloneStrings(logo)
+	org.Version++
+
+	return previous, nil
+}
+
+func (m *Memory) SearchDiscoverableOrgs(ctx context.Context, query string, limit int) ([]types.Org, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	orgs := m.findOrgs(func(org *types.Org) bool {
+		return org.ArchivedAt == nil &&
+			org.Visibility == types.ORG_VISIBILITY_DISCOVERABLE &&
+			strings.Contains(strings.ToLower(org.Name), strings.ToLower(query))
+	})
+
+	sort.SliceStable(orgs, func(i, j int) bool {
+		return orgs[i].Name < orgs[j].Name
+	})
+
+	if len(orgs) > limit {
+		orgs = orgs[:limit]
+	}
+
+	return orgs, nil
+}
+
+func (m *Memory) AddOrgDomain(ctx context.Context, orgId string, orgDomain types.OrgDomain) error {
+	return m.updateAnyOrg(orgId, func(org *types.Org) error {
+		for _, claimed := range org.Domains {
+			if claimed.Domain == orgDomain.Domain {
+				return errors.New("domain already claimed by this organization")
+			}
+		}
+
+		org.Domains = append(org.Domains, orgDomain)
+		return nil
+	})
+}
+
+func (m *Memory) VerifyOrgDomain(ctx context.Context, orgId, domain string) error {
+	return m.updateAnyOrg(orgId, func(org *types.Org) error {
+		for i := range org.Domains {
+			if org.Domains[i].Domain == domain {
+				org.Domains[i].Verified = true
+				return nil
+			}
+		}
+
+		return errors.New("domain is not claimed by this organization")
+	})
+}
+
+func (m *Memory) RemoveOrgDomain(ctx context.Context, orgId, domain string) error {
+	return m.updateAnyOrg(orgId, func(org *types.Org) error {
+		for i := range org.Domains {
+			if org.Domains[i].Domain == domain {
+				org.Domains = append(org.Domains[:i:i], org.Domains[i+1:]...)
+				return nil
+			}
+		}
+
+		return errors.New("domain is not claimed by this organization")
+	})
+}
+
+func (m *Memory) ReadOrgsByVerifiedDomain(ctx context.Context, domain string) ([]types.Org, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.findOrgs(func(org *types.Org) bool {
+		if org.ArchivedAt != nil {
+			return false
+		}
+
+		for _, claimed := range org.Domains {
+			if claimed.Domain == domain && claimed.Verified {
+				return true
+			}
+		}
+
+		return false
+	}), nil
+}
+
+func (m *Memory) UpdateOrgQuota(ctx context.Context, orgId string, quota types.OrgQuota) error {
+	return m.updateAnyOrg(orgId, func(org *types.Org) error {
+		org.Quota = quota
+		return nil
+	})
+}
+
+func (m *Memory) MoveOrg(ctx context.Context, orgId, parentId string) error {
+	return m.updateAnyOrg(orgId, func(org *types.Org) error {
+		org.ParentId = parentId
+		return nil
+	})
+}
+
+func (m *Memory) DeleteOrg(ctx context.Context, orgId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	org, err := m.org(orgId)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	org.ArchivedAt = &now
+	org.Version++
+
+	return nil
+}
+
+func (m *Memory) RestoreOrg(ctx context.Context, orgId string, archivedAfter time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	org, ok := m.orgs[orgId]
+	if !ok || org.ArchivedAt == nil || org.ArchivedAt.Before(archivedAfter) {
+		return errors.New("org is not archived or its retention period is over")
+	}
+
+	org.ArchivedAt = nil
+	org.Version++
+
+	return nil
+}
+
+func (m *Memory) PurgeArchivedOrgs(ctx context.Context, archivedBefore time.Time) ([]types.Org, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	orgs := m.findOrgs(func(org *types.Org) bool {
+		return org.ArchivedAt != nil && org.ArchivedAt.Before(archivedBefore)
+	})
+
+	for _, org := range orgs {
+		m.purgeOrg(org)
+	}
+
+	return orgs, nil
+}
+
+func (m *Memory) InviteUserToOrg(ctx context.Context, orgId string, member types.OrgMember, version int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.inviteUsersToOrg(orgId, []types.OrgMember{member}, version)
+}
+
+func (m *Memory) InviteUsersToOrg(ctx context.Context, orgId string, members []types.OrgMember) error {
+	if len(members) == 0 {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.inviteUsersToOrg(orgId, members, 0)
+}
+
+// inviteUsersToOrg adds the members all or nothing, the caller must hold the
+// lock.
+func (m *Memory) inviteUsersToOrg(orgId string, members []types.OrgMember, version int) error {
+	org, err := m.org(orgId)
+	if err != nil {
+		return err
+	}
+
+	if version != 0 && version != org.Version {
+		return ErrOrgModified
+	}
+
+	if !withinMemoryQuota(org.Quota.MaxMembers, org.MembersCount, len(members)) {
+		return fmt.Errorf("%w, the organization allows at most %d members", ErrQuotaExceeded, org.Quota.MaxMembers)
+	}
+
+	for _, member := range members {
+		if m.membership(orgId, member.Email) != nil {
+			return errors.New("user already exists in this organization")
+		}
+	}
+
+	for _, member := range members {
+		m.memberships[orgId] = append(m.memberships[orgId], types.Membership{
+			OrgId:       orgId,
+			Email:       member.Email,
+			AccessLevel: member.AccessLevel,
+		})
+	}
+	org.MembersCount += len(members)
+	org.Version++
+
+	return nil
+}
+
+func (m *Memory) RemoveUserFromOrg(ctx context.Context, orgId, email string, version int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	org, ok := m.orgs[orgId]
+	if version != 0 && (!ok || version != org.Version) {
+		return ErrOrgModified
+	}
+
+	if ok {
+		org.Version++
+	}
+
+	if m.removeMembership(orgId, email) && ok && org.MembersCount > 0 {
+		org.MembersCount--
+	}
+
+	for _, team := range m.teams {
+		if team.OrgId == orgId {
+			team.Members = removeTeamMember(team.Members, email)
+		}
+	}
+
+	for _, project := range m.projects {
+		if project.OrgId == orgId {
+			project.Members = removeProjectMember(project.Members, email)
+		}
+	}
+
+	return nil
+}
+
+func (m *Memory) ReadOrgMembers(ctx context.Context, orgId string, query types.MemberQuery) ([]types.OrgMember, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.orgs[orgId]; !ok {
+		return []types.OrgMember{}, nil
+	}
+
+	sortKey := func(member types.OrgMember) string {
+		if query.SortBy == "email" {
+			return strings.ToLower(member.Email)
+		}
+		return strings.ToLower(member.Name)
+	}
+
+	// compare orders two members by sort key then email, reversed when descending
+	compare := func(keyA, emailA, keyB, emailB string) int {
+		result := strings.Compare(keyA, keyB)
+		if result == 0 {
+			result = strings.Compare(emailA, emailB)
+		}
+		if query.Descending {
+			result = -result
+		}
+		return result
+	}
+
+	prefix := strings.ToLower(query.Prefix)
+	members := []types.OrgMember{}
+	for _, member := range m.members(orgId) {
+		if query.Role != "" && member.AccessLevel != query.Role {
+			continue
+		}
+
+		if len(query.Emails) > 0 && !contains(query.Emails, member.Email) {
+			continue
+		}
+
+		if prefix != "" &&
+			!strings.HasPrefix(strings.ToLower(member.Name), prefix) &&
+			!strings.HasPrefix(strings.ToLower(member.Email), prefix) {
+			continue
+		}
+
+		if query.After != nil && compare(sortKey(member), strings.ToLower(member.Email),
+			strings.ToLower(query.After.Key), strings.ToLower(query.After.Email)) <= 0 {
+			continue
+		}
+
+		members = append(members, member)
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		return compare(sortKey(members[i]), strings.ToLower(members[i].Email),
+			sortKey(members[j]), strings.ToLower(members[j].Email)) < 0
+	})
+
+	if len(members) > query.Limit {
+		members = members[:query.Limit]
+	}
+
+	return members, nil
+}
+
+func (m *Memory) IsOrgMember(ctx context.Context, orgId, email string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.membership(orgId, email) != nil
+}
+
+func (m *Memory) CreateInvitation(ctx context.Context, invitation types.Invitation) error {
+	return m.CreateInvitations(ctx, []types.Invitation{invitation})
+}
+
+func (m *Memory) CreateInvitations(ctx context.Context, invitations []types.Invitation) error {
+	if len(invitations) == 0 {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	org, ok := m.orgs[invitations[0].OrgId]
+	if !ok {
+		return ErrOrgNotFound
+	}
+
+	err := checkInvitationsQuota(org, invitations)
+	if err != nil {
+		return err
+	}
+
+	m.createInvitations(org, invitations)
+
+	return nil
+}
+
+// ImportMembersToOrg adds the members and creates the invitations of an import
+// all or nothing, a quota exceeded by either leaves the organization as it was.
+func (m *Memory) ImportMembersToOrg(ctx context.Context, orgId string, members []types.OrgMember, invitations []types.Invitation, version int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	org, err := m.org(orgId)
+	if err != nil {
+		return err
+	}
+
+	err = checkInvitationsQuota(org, invitations)
+	if err != nil {
+		return err
+	}
+
+	if len(members) > 0 {
+		err = m.inviteUsersToOrg(orgId, members, version)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(invitations) > 0 {
+		m.createInvitations(org, invitations)
+	}
+
+	return nil
+}
+
+func (m *Memory) ReadOrgInvitations(ctx context.Context, orgId string) ([]types.Invitation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var invitations []types.Invitation
+	for _, invitation := range m.invitations {
+		if invitation.OrgId == orgId {
+			invitations = append(invitations, invitation)
+		}
+	}
+
+	return invitations, nil
+}
+
+func (m *Memory) ReadInvitations(ctx context.Context, email string) ([]types.Invitation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var invitations []types.Invitation
+	for _, invitation := range m.invitations {
+		if invitation.Email == email {
+			invitations = append(invitations, invitation)
+		}
+	}
+
+	return invitations, nil
+}
+
+func (m *Memory) IsInvitedToOrg(ctx context.Context, orgId, email string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, invitation := range m.invitations {
+		if invitation.OrgId == orgId && invitation.Email == email {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *Memory) DeleteInvitation(ctx context.Context, orgId, email string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kept := m.invitations[:0]
+	deleted := 0
+	for _, invitation := range m.invitations {
+		if invitation.OrgId == orgId && invitation.Email == email {
+			deleted++
+			continue
+		}
+		kept = append(kept, invitation)
+	}
+	m.invitations = kept
+
+	if org, ok := m.orgs[orgId]; ok && deleted > 0 && org.PendingInvites >= deleted {
+		org.PendingInvites -= deleted
+	}
+
+	return nil
+}
+
+func (m *Memory) CreateInviteLink(ctx context.Context, link types.InviteLink) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.inviteLinks[link.Code] = &link
+
+	return nil
+}
+
+func (m *Memory) ReadInviteLinks(ctx context.Context, orgId string) ([]types.InviteLink, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var links []types.InviteLink
+	for _, link := range m.inviteLinks {
+		if link.OrgId == orgId {
+			links = append(links, *link)
+		}
+	}
+
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].Code < links[j].Code
+	})
+
+	return links, nil
+}
+
+func (m *Memory) DeleteInviteLink(ctx context.Context, orgId, code string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	link, ok := m.inviteLinks[code]
+	if !ok || link.OrgId != orgId {
+		return errors.New("invite link doesn't exists")
+	}
+
+	delete(m.inviteLinks, code)
+
+	return nil
+}
+
+func (m *Memory) RedeemInviteLink(ctx context.Context, code string) (types.InviteLink, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	link, ok := m.inviteLinks[code]
+	if !ok || !link.ExpiresAt.After(time.Now()) || link.Uses >= link.MaxUses {
+		return types.InviteLink{}, errors.New("invite link is invalid, expired or used up")
+	}
+
+	link.Uses++
+
+	return *link, nil
+}
+
+func (m *Memory) ReleaseInviteLink(ctx context.Context, code string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if link, ok := m.inviteLinks[code]; ok && link.Uses > 0 {
+		link.Uses--
+	}
+
+	return nil
+}
+
+func (m *Memory) CreateTeam(ctx context.Context, team types.Team) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	org, ok := m.orgs[team.OrgId]
+	if !ok {
+		return "", ErrOrgNotFound
+	}
+
+	if !withinMemoryQuota(org.Quota.MaxTeams, org.TeamsCount, 1) {
+		return "", fmt.Errorf("%w, the organization allows at most %d teams", ErrQuotaExceeded, org.Quota.MaxTeams)
+	}
+	org.TeamsCount++
+
+	team.TeamId = m.newId()
+	team.Members = append([]types.TeamMember{}, team.Members...)
+	m.teams[team.TeamId] = &team
+
+	return team.TeamId, nil
+}
+
+func (m *Memory) ReadTeam(ctx context.Context, orgId, teamId string) (types.Team, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	team, ok := m.teams[teamId]
+	if !ok || team.OrgId != orgId {
+		return types.Team{}, errors.New("team doesn't exists")
+	}
+
+	return cloneTeam(*team), nil
+}
+
+func (m *Memory) ReadOrgsTeams(ctx context.Context, orgIds []string) ([]types.Team, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var teams []types.Team
+	for _, id := range sortedKeys(m.teams) {
+		if contains(orgIds, m.teams[id].OrgId) {
+			teams = append(teams, cloneTeam(*m.teams[id]))
+		}
+	}
+
+	return teams, nil
+}
+
+func (m *Memory) UpdateTeam(ctx context.Context, team types.Team) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.teams[team.TeamId]
+	if !ok || stored.OrgId != team.OrgId {
+		return errors.New("team doesn't exists")
+	}
+
+	stored.Name = team.Name
+	stored.Description = team.Description
+
+	return nil
+}
+
+func (m *Memory) DeleteTeam(ctx context.Context, orgId, teamId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	team, ok := m.teams[teamId]
+	if !ok || team.OrgId != orgId {
+		return errors.New("team doesn't exists")
+	}
+
+	delete(m.teams, teamId)
+
+	if org, ok := m.orgs[orgId]; ok && org.TeamsCount > 0 {
+		org.TeamsCount--
+	}
+
+	return nil
+}
+
+func (m *Memory) AddTeamMember(ctx context.Context, orgId, teamId string, member types.TeamMember) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	team, ok := m.teams[teamId]
+	if !ok || team.OrgId != orgId {
+		return errors.New("user already exists in this team")
+	}
+
+	for _, teamMember := range team.Members {
+		if teamMember.Email == member.Email {
+			return errors.New("user already exists in this team")
+		}
+	}
+
+	team.Members = append(team.Members, member)
+
+	return nil
+}
+
+func (m *Memory) RemoveTeamMember(ctx context.Context, orgId, teamId, email string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	team, ok := m.teams[teamId]
+	if !ok || team.OrgId != orgId {
+		return errors.New("user is not a team member")
+	}
+
+	members := removeTeamMember(team.Members, email)
+	if len(members) == len(team.Members) {
+		return errors.New("user is not a team member")
+	}
+	team.Members = members
+
+	return nil
+}
+
+func (m *Memory) CreateProject(ctx context.Context, project types.Project) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	project.ProjectId = m.newId()
+	project.Members = append([]types.ProjectMember{}, project.Members...)
+	m.projects[project.ProjectId] = &project
+
+	return project.ProjectId, nil
+}
+
+func (m *Memory) ReadProject(ctx context.Context, orgId, projectId string) (types.Project, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	project, ok := m.projects[projectId]
+	if !ok || project.OrgId != orgId {
+		return types.Project{}, errors.New("project doesn't exists")
+	}
+
+	return cloneProject(*project), nil
+}
+
+func (m *Memory) ReadOrgProjects(ctx context.Context, orgId string) ([]types.Project, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var projects []types.Project
+	for _, id := range sortedKeys(m.projects) {
+		if m.projects[id].OrgId == orgId {
+			projects = append(projects, cloneProject(*m.projects[id]))
+		}
+	}
+
+	return projects, nil
+}
+
+func (m *Memory) UpdateProject(ctx context.Context, project types.Project) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.projects[project.ProjectId]
+	if !ok || stored.OrgId != project.OrgId {
+		return errors.New("project doesn't exists")
+	}
+
+	stored.Name = project.Name
+	stored.Description = project.Description
+
+	return nil
+}
+
+func (m *Memory) DeleteProject(ctx context.Context, orgId, projectId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	project, ok := m.projects[projectId]
+	if !ok || project.OrgId != orgId {
+		return errors.New("project doesn't exists")
+	}
+
+	delete(m.projects, projectId)
+
+	return nil
+}
+
+func (m *Memory) SetProjectMember(ctx context.Context, orgId, projectId string, member types.ProjectMember) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	project, ok := m.projects[projectId]
+	if !ok || project.OrgId != orgId {
+		return errors.New("project doesn't exists")
+	}
+
+	project.Members = append(removeProjectMember(project.Members, member.Email), member)
+
+	return nil
+}
+
+func (m *Memory) RemoveProjectMember(ctx context.Context, orgId, projectId, email string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	project, ok := m.projects[projectId]
+	if !ok || project.OrgId != orgId {
+		return errors.New("user has no override in this project")
+	}
+
+	members := removeProjectMember(project.Members, email)
+	if len(members) == len(project.Members) {
+		return errors.New("user has no override in this project")
+	}
+	project.Members = members
+
+	return nil
+}
+
+func (m *Memory) CreateJoinRequest(ctx context.Context, joinRequest types.JoinRequest) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	joinRequest.RequestId = m.newId()
+	m.joinRequests[joinRequest.RequestId] = &joinRequest
+
+	return joinRequest.RequestId, nil
+}
+
+func (m *Memory) ReadPendingJoinRequests(ctx context.Context, orgId string) ([]types.JoinRequest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var joinRequests []types.JoinRequest
+	for _, id := range sortedKeys(m.joinRequests) {
+		joinRequest := m.joinRequests[id]
+		if joinRequest.OrgId == orgId && joinRequest.Status == types.JOIN_REQUEST_STATUS_PENDING {
+			joinRequests = append(joinRequests, *joinRequest)
+		}
+	}
+
+	return joinRequests, nil
+}
+
+func (m *Memory) HasPendingJoinRequest(ctx context.Context, orgId, email string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, joinRequest := range m.joinRequests {
+		if joinRequest.OrgId == orgId && joinRequest.Email == email &&
+			joinRequest.Status == types.JOIN_REQUEST_STATUS_PENDING {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *Memory) SetJoinRequestStatus(ctx context.Context, orgId, requestId, from, to string) (types.JoinRequest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	joinRequest, ok := m.joinRequests[requestId]
+	if !ok || joinRequest.OrgId != orgId || joinRequest.Status != from {
+		return types.JoinRequest{}, errors.New("join request doesn't exists or is already resolved")
+	}
+
+	joinRequest.Status = to
+
+	return *joinRequest, nil
+}
+
+// ====================== helper private function ====================== //
+
+// newId returns IDs shaped like Mongo ObjectIDs, so they are never mistaken
+// for slugs, that also sort in creation order.
+func (m *Memory) newId() string {
+	m.lastId++
+	return fmt.Sprintf("%024x", m.lastId)
+}
+
+// createInvitations stores invitations that fit in the quota of the
+// organization, the caller must hold the lock.
+func (m *Memory) createInvitations(org *types.Org, invitations []types.Invitation) {
+	org.PendingInvites += len(invitations)
+	m.invitations = append(m.invitations, invitations...)
+}
+
+// org returns the stored organization unless it is missing or archived, the
+// caller must hold the lock.
+func (m *Memory) org(orgId string) (*types.Org, error) {
+	org, ok := m.orgs[orgId]
+	if !ok || org.ArchivedAt != nil {
+		return nil, ErrOrgNotFound
+	}
+
+	return org, nil
+}
+
+// updateAnyOrg applies the update to the organization whether it is archived
+// or not, like the updates of the Mongo implementation that only filter by ID,
+// and increments its version when the update succeeds.
+func (m *Memory) updateAnyOrg(orgId string, update func(org *types.Org) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	org, ok := m.orgs[orgId]
+	if !ok {
+		return ErrOrgNotFound
+	}
+
+	err := update(org)
+	if err != nil {
+		return err
+	}
+	org.Version++
+
+	return nil
+}
+
+// members returns the members of the organization in the order they joined,
+// with the name of their user, the caller must hold the lock.
+func (m *Memory) members(orgId string) []types.OrgMember {
+	members := []types.OrgMember{}
+	for _, membership := range m.memberships[orgId] {
+		member := types.OrgMember{
+			UserInfo:    types.UserInfo{Email: membership.Email},
+			AccessLevel: membership.AccessLevel,
+		}
+		if user, ok := m.users[membership.Email]; ok {
+			member.Name = user.Name
+		}
+
+		members = append(members, member)
+	}
+
+	return members
+}
+
+func (m *Memory) membership(orgId, email string) *types.Membership {
+	memberships := m.memberships[orgId]
+	for i := range memberships {
+		if memberships[i].Email == email {
+			return &memberships[i]
+		}
+	}
+
+	return nil
+}
+
+// removeMembership reports whether the user was a member of the
+// organization, the caller must hold the lock.
+func (m *Memory) removeMembership(orgId, email string) bool {
+	memberships := m.memberships[orgId]
+	for i := range memberships {
+		if memberships[i].Email == email {
+			m.memberships[orgId] = append(memberships[:i:i], memberships[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// sortedOrgs returns the stored organizations in creation order, the caller
+// must hold the lock.
+func (m *Memory) sortedOrgs() []*types.Org {
+	orgs := make([]*types.Org, 0, len(m.orgs))
+	for _, id := range sortedKeys(m.orgs) {
+		orgs = append(orgs, m.orgs[id])
+	}
+
+	return orgs
+}
+
+func (m *Memory) findOrgs(match func(org *types.Org) bool) []types.Org {
+	var orgs []types.Org
+	for _, org := range m.sortedOrgs() {
+		if match(org) {
+			orgs = append(orgs, cloneOrg(*org))
+		}
+	}
+
+	return orgs
+}
+
+func (m *Memory) availableSlug(baseSlug, orgId string) string {
+	taken := make(map[string]bool)
+	for _, org := range m.orgs {
+		if org.OrgId == orgId {
+			continue
+		}
+
+		taken[org.Slug] = true
+		for _, slug := range org.SlugHistory {
+			taken[slug] = true
+		}
+	}
+
+	slug := baseSlug
+	for n := 2; taken[slug]; n++ {
+		slug = baseSlug + "-" + strconv.Itoa(n)
+	}
+
+	return slug
+}
+
+// purgeOrg mirrors the Mongo purge, the caller must hold the lock.
+func (m *Memory) purgeOrg(org types.Org) {
+	delete(m.orgs, org.OrgId)
+
+	for _, child := range m.orgs {
+		if child.ParentId == org.OrgId {
+			child.ParentId = org.ParentId
+			child.Version++
+		}
+	}
+
+	delete(m.memberships, org.OrgId)
+
+	kept := m.invitations[:0]
+	for _, invitation := range m.invitations {
+		if invitation.OrgId != org.OrgId {
+			kept = append(kept, invitation)
+		}
+	}
+	m.invitations = kept
+
+	for code, link := range m.inviteLinks {
+		if link.OrgId == org.OrgId {
+			delete(m.inviteLinks, code)
+		}
+	}
+
+	for id, team := range m.teams {
+		if team.OrgId == org.OrgId {
+			delete(m.teams, id)
+		}
+	}
+
+	for id, project := range m.projects {
+		if project.OrgId == org.OrgId {
+			delete(m.projects, id)
+		}
+	}
+
+	for id, joinRequest := range m.joinRequests {
+		if joinRequest.OrgId == org.OrgId {
+			delete(m.joinRequests, id)
+		}
+	}
+}
+
+// withinMemoryQuota mirrors withinQuota, a zero limit means unlimited.
+func withinMemoryQuota(limit, usage, n int) bool {
+	return limit <= 0 || usage+n <= limit
+}
+
+func checkInvitationsQuota(org *types.Org, invitations []types.Invitation) error {
+	err := checkInvitationsOrg(org.OrgId, invitations)
+	if err != nil {
+		return err
+	}
+
+	if !withinMemoryQuota(org.Quota.MaxPendingInvites, org.PendingInvites, len(invitations)) {
+		return fmt.Errorf("%w, the organization allows at most %d pending invites", ErrQuotaExceeded, org.Quota.MaxPendingInvites)
+	}
+
+	return nil
+}
+
+func sortedKeys[V any](items map[string]V) []string {
+	keys := make([]string, 0, len(items))
+	for key := range items {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func remove(values []string, value string) []string {
+	var kept []string
+	for _, v := range values {
+		if v != value {
+			kept = append(kept, v)
+		}
+	}
+
+	return kept
+}
+
+func removeTeamMember(members []types.TeamMember, email string) []types.TeamMember {
+	kept := []types.TeamMember{}
+	for _, member := range members {
+		if member.Email != email {
+			kept = append(kept, member)
+		}
+	}
+
+	return kept
+}
+
+func removeProjectMember(members []types.ProjectMember, email string) []types.ProjectMember {
+	kept := []types.ProjectMember{}
+	for _, member := range members {
+		if member.Email != email {
+			kept = append(kept, member)
+		}
+	}
+
+	return kept
+}
+
+// the clone helpers copy slices and maps so callers never share memory with
+// the stored entities, like documents decoded from Mongo
+
+func cloneOrg(org types.Org) types.Org {
+	org.SlugHistory = append([]string(nil), org.SlugHistory...)
+	org.MetadataFields = append([]types.MetadataField(nil), org.MetadataFields...)
+	org.Metadata = cloneMetadata(org.Metadata)
+	org.Logo = cloneStrings(org.Logo)
+	org.Tags = append([]string(nil), org.Tags...)
+	org.Domains = append([]types.OrgDomain(nil), org.Domains...)
+	org.Teams = nil
+	if org.ArchivedAt != nil {
+		archivedAt := *org.ArchivedAt
+		org.ArchivedAt = &archivedAt
+	}
+
+	return org
+}
+
+func cloneTeam(team types.Team) types.Team {
+	team.Members = append([]types.TeamMember{}, team.Members...)
+	return team
+}
+
+func cloneProject(project types.Project) types.Project {
+	project.Members = append([]types.ProjectMember{}, project.Members...)
+	return project
+}
+
+func cloneMetadata(metadata map[string]interface{}) map[string]interface{} {
+	if metadata == nil {
+		return nil
+	}
+
+	cloned := make(map[string]interface{}, len(metadata))
+	for key, value := range metadata {
+		cloned[key] = value
+	}
+
+	return cloned
+}
+
+func cloneStrings(values map[string]string) map[string]string {
+	if values == nil {
+		return nil
+	}
+
+	cloned := make(map[string]string, len(values))
+	for key, value := range values {
+		cloned[key] = value
+	}
+
+	return cloned
+}
@@ -0,0 +1,782 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/zaher1307/IDEANEST-project-assignment/internal/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MIGRATION_LOCK_TIMEOUT is how long the lock of a migration run stays valid
+// without being renewed before another run may take it over, in case the run
+// holding it crashed. A run renews it every third of the timeout.
+const MIGRATION_LOCK_TIMEOUT = 15 * time.Minute
+
+// MIGRATION_BATCH_SIZE is how many documents a migration reads and rewrites
+// at once, so no single write spans a whole collection.
+const MIGRATION_BATCH_SIZE = 500
+
+var ErrMigrationLocked = errors.New("another migration run holds the lock")
+
+// Migration moves the stored documents from the previous schema version to
+// Version, Down reverts what Up did. Each step runs in a transaction together
+// with the record of the migration, unless NonTransactional is set for the
+// steps building indexes or using $merge, which transactions don't allow, and
+// for the steps rewriting whole collections, which would outgrow the time and
+// size limits of a transaction and are split in batches instead: they are
+// recorded right after and must be safe to run again.
+type Migration struct {
+	Version          int
+	Name             string
+	Up               func(ctx context.Context, db *mongo.Database) error
+	Down             func(ctx context.Context, db *mongo.Database) error
+	NonTransactional bool
+}
+
+// Migrator is implemented by the storage backends whose schema is versioned
+// by migrations.
+type Migrator interface {
+	MigrationStatus(ctx context.Context) ([]MigrationStatus, error)
+	MigrateUp(ctx context.Context) error
+	MigrateDown(ctx context.Context) error
+	MigrateTo(ctx context.Context, version int) error
+}
+
+// MigrationStatus reports when a migration was applied, AppliedAt is nil
+// while it is pending.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+// migrations are applied in version order, a schema change is a new migration
+// appended with the next version, released migrations are never edited.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "lowercase_emails",
+		Up:      lowercaseEmails,
+		// the original case is lost, the lowercased emails are kept
+		Down: func(ctx context.Context, db *mongo.Database) error { return nil },
+		// the emails are rewritten by batches, a rerun skips the lowercased ones
+		NonTransactional: true,
+	},
+	{
+		Version: 2,
+		Name:    "membership_collection",
+		Up:      moveMembersToMemberships,
+		Down:    moveMembershipsToMembers,
+		// the members are copied with $merge, by batches of organizations
+		NonTransactional: true,
+	},
+	{
+		Version: 3,
+		Name:    "org_versions",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			filter := bson.M{"version": bson.M{"$exists": false}}
+			return updateInBatches(ctx, db.Collection(types.ORG_COLL), filter, bson.M{"$set": bson.M{"version": 1}})
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			filter := bson.M{"version": bson.M{"$exists": true}}
+			return updateInBatches(ctx, db.Collection(types.ORG_COLL), filter, bson.M{"$unset": bson.M{"version": ""}})
+		},
+		// the organizations are rewritten by batches, a rerun skips the done ones
+		NonTransactional: true,
+	},
+	{
+		Version: 4,
+		Name:    "unique_user_emails",
+		Up:      uniqueUserEmails,
+		// the deleted accounts are lost, only the index is dropped
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection(types.USER_COLL).Indexes().DropOne(ctx, "email_1")
+			return err
+		},
+		NonTransactional: true,
+	},
+	{
+		Version: 5,
+		Name:    "membership_name_keys",
+		Up:      storeMemberNameKeys,
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			filter := bson.M{"name_lower": bson.M{"$exists": true}}
+			return updateInBatches(ctx, db.Collection(types.MEMBERSHIP_COLL), filter, bson.M{"$unset": bson.M{"name_lower": ""}})
+		},
+		// the memberships are rewritten by batches, a rerun skips the done ones
+		NonTransactional: true,
+	},
+}
+
+type migrationRecord struct {
+	Version   int       `bson:"_id"`
+	Name      string    `bson:"name"`
+	AppliedAt time.Time `bson:"applied_at"`
+}
+
+// LatestMigrationVersion is the schema version the code expects.
+func LatestMigrationVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+func (m *Mongo) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := m.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, len(migrations))
+	for i, migration := range migrations {
+		statuses[i] = MigrationStatus{
+			Version: migration.Version,
+			Name:    migration.Name,
+		}
+
+		if record, ok := applied[migration.Version]; ok {
+			appliedAt := record.AppliedAt
+			statuses[i].AppliedAt = &appliedAt
+		}
+	}
+
+	return statuses, nil
+}
+
+// MigrateUp applies every pending migration.
+func (m *Mongo) MigrateUp(ctx context.Context) error {
+	return m.MigrateTo(ctx, LatestMigrationVersion())
+}
+
+// MigrateDown reverts the last applied migration.
+func (m *Mongo) MigrateDown(ctx context.Context) error {
+	return m.migrate(ctx, func(current int) int {
+		previous := 0
+		for _, migration := range migrations {
+			if migration.Version < current {
+				previous = migration.Version
+			}
+		}
+
+		return previous
+	})
+}
+
+// MigrateTo applies the pending migrations up to the version and reverts the
+// applied ones above it, version 0 reverts every migration.
+func (m *Mongo) MigrateTo(ctx context.Context, version int) error {
+	return m.migrate(ctx, func(int) int {
+		return version
+	})
+}
+
+// ====================== helper private function ====================== //
+
+// migrate moves the schema to the version target returns for the current
+// version, while holding the migration lock.
+func (m *Mongo) migrate(ctx context.Context, target func(current int) int) (err error) {
+	owner, err := m.lockMigrations(ctx)
+	if err != nil {
+		return err
+	}
+	defer m.unlockMigrations(ctx, owner)
+
+	ctx, release := m.keepMigrationLock(ctx, owner)
+	defer func() {
+		// a lost lock cancels the run, report it rather than the cancellation
+		if cause := context.Cause(ctx); err != nil && errors.Is(cause, ErrMigrationLocked) {
+			err = cause
+		}
+		release()
+	}()
+
+	applied, err := m.appliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	current := 0
+	for version := range applied {
+		if version > current {
+			current = version
+		}
+	}
+
+	version := target(current)
+	if version != 0 && !knownMigration(version) {
+		return fmt.Errorf("unknown migration version %d", version)
+	}
+
+	collection := m.db.Collection(types.MIGRATION_COLL)
+
+	for _, migration := range migrations {
+		if migration.Version > version {
+			break
+		}
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+
+		err = m.renewMigrationLock(ctx, owner)
+		if err != nil {
+			return err
+		}
+
+		err = m.runMigration(ctx, migration, migration.Up, func(ctx context.Context) error {
+			_, err := collection.InsertOne(ctx, migrationRecord{
+				Version:   migration.Version,
+				Name:      migration.Name,
+				AppliedAt: time.Now(),
+			})
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("applying migration %d %s: %w", migration.Version, migration.Name, err)
+		}
+	}
+
+	for i := len(migrations) - 1; i >= 0 && migrations[i].Version > version; i-- {
+		migration := migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+
+		err = m.renewMigrationLock(ctx, owner)
+		if err != nil {
+			return err
+		}
+
+		err = m.runMigration(ctx, migration, migration.Down, func(ctx context.Context) error {
+			_, err := collection.DeleteOne(ctx, bson.M{"_id": migration.Version})
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("reverting migration %d %s: %w", migration.Version, migration.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// runMigration runs a step of the migration and the write recording it in one
+// transaction, or one after the other when the migration is NonTransactional.
+func (m *Mongo) runMigration(ctx context.Context, migration Migration, step func(ctx context.Context, db *mongo.Database) error, record func(ctx context.Context) error) error {
+	if migration.NonTransactional {
+		err := step(ctx, m.db)
+		if err != nil {
+			return err
+		}
+
+		return record(ctx)
+	}
+
+	return m.withTransaction(ctx, func(ctx mongo.SessionContext) error {
+		err := step(ctx, m.db)
+		if err != nil {
+			return err
+		}
+
+		return record(ctx)
+	})
+}
+
+func (m *Mongo) appliedMigrations(ctx context.Context) (map[int]migrationRecord, error) {
+	cursor, err := m.db.Collection(types.MIGRATION_COLL).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	var records []migrationRecord
+	err = cursor.All(ctx, &records)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]migrationRecord, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+
+	return applied, nil
+}
+
+// lockMigrations takes the single lock document, the upsert fails with a
+// duplicate key while another run holds a lock that isn't stale.
+func (m *Mongo) lockMigrations(ctx context.Context) (string, error) {
+	owner := primitive.NewObjectID().Hex()
+	now := time.Now()
+
+	collection := m.db.Collection(types.MIGRATION_LOCK_COLL)
+	filter := bson.M{
+		"_id": "migrations",
+		"$or": bson.A{
+			bson.M{"locked_by": ""},
+			bson.M{"locked_at": bson.M{"$lt": now.Add(-MIGRATION_LOCK_TIMEOUT)}},
+		},
+	}
+	update := bson.M{"$set": bson.M{"locked_by": owner, "locked_at": now}}
+
+	_, err := collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return "", ErrMigrationLocked
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return owner, nil
+}
+
+// renewMigrationLock moves the lock time of the run forward, it fails with
+// ErrMigrationLocked when another run took the lock over.
+func (m *Mongo) renewMigrationLock(ctx context.Context, owner string) error {
+	collection := m.db.Collection(types.MIGRATION_LOCK_COLL)
+	filter := bson.M{"_id": "migrations", "locked_by": owner}
+	update := bson.M{"$set": bson.M{"locked_at": time.Now()}}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("%w, the lock of this run was taken over", ErrMigrationLocked)
+	}
+
+	return nil
+}
+
+// keepMigrationLock renews the lock every third of MIGRATION_LOCK_TIMEOUT until
+// release is called, even while a long migration step runs. The returned
+// context is cancelled, with the cause, as soon as the lock is lost.
+func (m *Mongo) keepMigrationLock(ctx context.Context, owner string) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	done := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(MIGRATION_LOCK_TIMEOUT / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			err := m.renewMigrationLock(ctx, owner)
+			if err != nil {
+				cancel(err)
+				return
+			}
+		}
+	}()
+
+	return ctx, func() {
+		close(done)
+		cancel(nil)
+	}
+}
+
+func (m *Mongo) unlockMigrations(ctx context.Context, owner string) error {
+	collection := m.db.Collection(types.MIGRATION_LOCK_COLL)
+	filter := bson.M{"_id": "migrations", "locked_by": owner}
+	update := bson.M{"$set": bson.M{"locked_by": ""}}
+
+	_, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func knownMigration(version int) bool {
+	for _, migration := range migrations {
+		if migration.Version == version {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lowercaseEmails brings the emails stored before sign ups normalized them to
+// lowercase, wherever a copy of them is kept, by batches of documents.
+func lowercaseEmails(ctx context.Context, db *mongo.Database) error {
+	lowerEmail := bson.M{"email": bson.M{"$toLower": "$email"}}
+	lowerMembers := func(field string) bson.M {
+		return bson.M{field: bson.M{"$map": bson.M{
+			"input": bson.M{"$ifNull": bson.A{"$" + field, bson.A{}}},
+			"as":    "member",
+			"in": bson.M{"$mergeObjects": bson.A{
+				"$$member",
+				bson.M{"email": bson.M{"$toLower": "$$member.email"}},
+			}},
+		}}}
+	}
+
+	updates := []struct {
+		coll  string
+		field string
+		set   bson.M
+	}{
+		{types.USER_COLL, "email", lowerEmail},
+		{types.INVITATION_COLL, "email", lowerEmail},
+		{types.JOIN_REQUEST_COLL, "email", lowerEmail},
+		{types.ORG_COLL, "organization_members.email", lowerMembers("organization_members")},
+		{types.TEAM_COLL, "team_members.email", lowerMembers("team_members")},
+		{types.PROJECT_COLL, "project_members.email", lowerMembers("project_members")},
+	}
+
+	// only the documents still holding an uppercase letter are rewritten
+	upper := primitive.Regex{Pattern: "[A-Z]"}
+	for _, update := range updates {
+		pipeline := mongo.Pipeline{{{Key: "$set", Value: update.set}}}
+
+		err := updateInBatches(ctx, db.Collection(update.coll), bson.M{update.field: upper}, pipeline)
+		if err != nil {
+			return fmt.Errorf("lowercasing %s emails: %w", update.coll, err)
+		}
+	}
+
+	return nil
+}
+
+// uniqueUserEmails keeps the oldest of the accounts sharing an email, e.g.
+// emails that only differed by case before being lowercased, and deletes the
+// newer ones, logging the _id and email of each deleted account. It then builds
+// the unique index keeping concurrent sign ups from creating them again. Every
+// other document refers to the user by email, so it stays with the kept one.
+func uniqueUserEmails(ctx context.Context, db *mongo.Database) error {
+	users := db.Collection(types.USER_COLL)
+
+	cursor, err := users.Aggregate(ctx, bson.A{
+		bson.M{"$sort": bson.M{"_id": 1}},
+		bson.M{"$group": bson.M{"_id": "$email", "ids": bson.M{"$push": "$_id"}}},
+		bson.M{"$match": bson.M{"ids.1": bson.M{"$exists": true}}},
+	})
+	if err != nil {
+		return fmt.Errorf("finding duplicate users: %w", err)
+	}
+
+	var duplicates []struct {
+		Email string `bson:"_id"`
+		Ids   bson.A `bson:"ids"`
+	}
+	err = cursor.All(ctx, &duplicates)
+	if err != nil {
+		return fmt.Errorf("finding duplicate users: %w", err)
+	}
+
+	for _, duplicate := range duplicates {
+		_, err = users.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": duplicate.Ids[1:]}})
+		if err != nil {
+			return fmt.Errorf("deleting duplicate users: %w", err)
+		}
+
+		for _, id := range duplicate.Ids[1:] {
+			log.Printf("deleted duplicate user %v of %s, kept %v", id, duplicate.Email, duplicate.Ids[0])
+		}
+	}
+
+	if len(duplicates) > 0 {
+		log.Printf("deleted the duplicates of %d user emails", len(duplicates))
+	}
+
+	// concurrent sign ups with the same email cannot both succeed
+	_, err = users.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "email", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("creating users email index: %w", err)
+	}
+
+	return nil
+}
+
+// moveMembersToMemberships copies the organization_members arrays into the
+// membership collection, then drops the arrays and the organizations cache of
+// the users, by batches of documents. Memberships that already exist are kept
+// and the organizations already moved are skipped, so a rerun is harmless.
+func moveMembersToMemberships(ctx context.Context, db *mongo.Database) error {
+	orgs := db.Collection(types.ORG_COLL)
+	filter := bson.M{"organization_members": bson.M{"$exists": true}}
+
+	err := forEachBatch(ctx, orgs, filter, func(batch []bson.M) error {
+		ids := batchIds(batch)
+
+		cursor, err := orgs.Aggregate(ctx, bson.A{
+			bson.M{"$match": bson.M{"_id": bson.M{"$in": ids}}},
+			bson.M{"$unwind": "$organization_members"},
+			bson.M{"$project": bson.M{
+				"_id":             0,
+				"organization_id": bson.M{"$toString": "$_id"},
+				"email":           "$organization_members.email",
+				"access_level":    "$organization_members.access_level",
+			}},
+			bson.M{"$merge": bson.M{
+				"into":           types.MEMBERSHIP_COLL,
+				"on":             bson.A{"organization_id", "email"},
+				"whenMatched":    "keepExisting",
+				"whenNotMatched": "insert",
+			}},
+		})
+		if err != nil {
+			return fmt.Errorf("copying organization members: %w", err)
+		}
+		cursor.Close(ctx)
+
+		_, err = orgs.UpdateMany(ctx,
+			bson.M{"_id": bson.M{"$in": ids}},
+			mongo.Pipeline{
+				{{Key: "$set", Value: bson.M{"members_count": bson.M{"$size": "$organization_members"}}}},
+				{{Key: "$unset", Value: "organization_members"}},
+			},
+		)
+		if err != nil {
+			return fmt.Errorf("counting organization members: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	filter = bson.M{"organizations": bson.M{"$exists": true}}
+	err = updateInBatches(ctx, db.Collection(types.USER_COLL), filter, bson.M{"$unset": bson.M{"organizations": ""}})
+	if err != nil {
+		return fmt.Errorf("dropping user organizations: %w", err)
+	}
+
+	return nil
+}
+
+// moveMembershipsToMembers rebuilds the organization_members arrays, with the
+// member names, and the organizations cache of the users from the membership
+// collection, by batches of organizations and users, then drops it. Every
+// batch rewrites the arrays and caches whole, so a rerun is harmless.
+func moveMembershipsToMembers(ctx context.Context, db *mongo.Database) error {
+	memberships := db.Collection(types.MEMBERSHIP_COLL)
+	orgs := db.Collection(types.ORG_COLL)
+
+	err := forEachBatch(ctx, orgs, bson.M{}, func(batch []bson.M) error {
+		ids := batchIds(batch)
+
+		orgIds := bson.A{}
+		for _, id := range ids {
+			if objectId, ok := id.(primitive.ObjectID); ok {
+				orgIds = append(orgIds, objectId.Hex())
+			}
+		}
+
+		pipeline := append(bson.A{
+			bson.M{"$match": bson.M{"organization_id": bson.M{"$in": orgIds}}},
+			bson.M{"$sort": bson.M{"_id": 1}},
+		}, memberNameStages...)
+		pipeline = append(pipeline,
+			bson.M{"$group": bson.M{
+				"_id": bson.M{"$toObjectId": "$organization_id"},
+				"organization_members": bson.M{"$push": bson.M{
+					"name":         "$name",
+					"email":        "$email",
+					"access_level": "$access_level",
+				}},
+			}},
+			bson.M{"$merge": bson.M{
+				"into":           types.ORG_COLL,
+				"on":             "_id",
+				"whenMatched":    "merge",
+				"whenNotMatched": "discard",
+			}},
+		)
+
+		cursor, err := memberships.Aggregate(ctx, pipeline)
+		if err != nil {
+			return fmt.Errorf("rebuilding organization members: %w", err)
+		}
+		cursor.Close(ctx)
+
+		_, err = orgs.UpdateMany(ctx,
+			bson.M{"_id": bson.M{"$in": ids}, "organization_members": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"organization_members": bson.A{}}},
+		)
+		if err != nil {
+			return fmt.Errorf("rebuilding organization members: %w", err)
+		}
+
+		_, err = orgs.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": ids}}, bson.M{"$unset": bson.M{"members_count": ""}})
+		if err != nil {
+			return fmt.Errorf("dropping members count: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = forEachBatch(ctx, db.Collection(types.USER_COLL), bson.M{}, func(batch []bson.M) error {
+		emails := bson.A{}
+		for _, user := range batch {
+			emails = append(emails, user["email"])
+		}
+
+		cursor, err := memberships.Aggregate(ctx, bson.A{
+			bson.M{"$match": bson.M{"email": bson.M{"$in": emails}}},
+			bson.M{"$group": bson.M{
+				"_id":           "$email",
+				"organizations": bson.M{"$addToSet": "$organization_id"},
+			}},
+		})
+		if err != nil {
+			return fmt.Errorf("rebuilding user organizations: %w", err)
+		}
+
+		var caches []struct {
+			Email         string   `bson:"_id"`
+			Organizations []string `bson:"organizations"`
+		}
+		err = cursor.All(ctx, &caches)
+		if err != nil {
+			return fmt.Errorf("rebuilding user organizations: %w", err)
+		}
+
+		if len(caches) == 0 {
+			return nil
+		}
+
+		// users.email is no longer unique once unique_user_emails is reverted,
+		// so the caches are written by email rather than merged on it
+		models := make([]mongo.WriteModel, len(caches))
+		for i, cache := range caches {
+			models[i] = mongo.NewUpdateManyModel().
+				SetFilter(bson.M{"email": cache.Email}).
+				SetUpdate(bson.M{"$set": bson.M{"organizations": cache.Organizations}})
+		}
+
+		_, err = db.Collection(types.USER_COLL).BulkWrite(ctx, models)
+		if err != nil {
+			return fmt.Errorf("rebuilding user organizations: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return memberships.Drop(ctx)
+}
+
+// storeMemberNameKeys stores the lowercased name of the user on each of their
+// memberships, the key of the index sorting the member directory by name. The
+// memberships already holding it are skipped, so a rerun resumes where the
+// previous run stopped.
+func storeMemberNameKeys(ctx context.Context, db *mongo.Database) error {
+	memberships := db.Collection(types.MEMBERSHIP_COLL)
+
+	return forEachBatch(ctx, memberships, bson.M{"name_lower": bson.M{"$exists": false}}, func(batch []bson.M) error {
+		emails := bson.A{}
+		for _, membership := range batch {
+			emails = append(emails, membership["email"])
+		}
+
+		cursor, err := db.Collection(types.USER_COLL).Find(ctx, bson.M{"email": bson.M{"$in": emails}})
+		if err != nil {
+			return fmt.Errorf("reading member names: %w", err)
+		}
+
+		var users []types.User
+		err = cursor.All(ctx, &users)
+		if err != nil {
+			return fmt.Errorf("reading member names: %w", err)
+		}
+
+		names := make(map[string]string, len(users))
+		for _, user := range users {
+			names[user.Email] = strings.ToLower(user.Name)
+		}
+
+		models := make([]mongo.WriteModel, len(batch))
+		for i, membership := range batch {
+			email, _ := membership["email"].(string)
+			models[i] = mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"_id": membership["_id"]}).
+				SetUpdate(bson.M{"$set": bson.M{"name_lower": names[email]}})
+		}
+
+		_, err = memberships.BulkWrite(ctx, models)
+		if err != nil {
+			return fmt.Errorf("storing member names: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// forEachBatch passes the documents matching the filter to fn by batches of
+// MIGRATION_BATCH_SIZE, in _id order, until none is left.
+func forEachBatch(ctx context.Context, coll *mongo.Collection, filter bson.M, fn func(batch []bson.M) error) error {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(MIGRATION_BATCH_SIZE)
+
+	var lastId interface{}
+	for {
+		batchFilter := filter
+		if lastId != nil {
+			batchFilter = bson.M{"$and": bson.A{filter, bson.M{"_id": bson.M{"$gt": lastId}}}}
+		}
+
+		cursor, err := coll.Find(ctx, batchFilter, opts)
+		if err != nil {
+			return err
+		}
+
+		var batch []bson.M
+		err = cursor.All(ctx, &batch)
+		if err != nil {
+			return err
+		}
+
+		if len(batch) == 0 {
+			return nil
+		}
+
+		err = fn(batch)
+		if err != nil {
+			return err
+		}
+
+		lastId = batch[len(batch)-1]["_id"]
+	}
+}
+
+// updateInBatches applies the update to the documents matching the filter by
+// batches of MIGRATION_BATCH_SIZE. The update must be safe to apply twice, an
+// interrupted run starts over.
+func updateInBatches(ctx context.Context, coll *mongo.Collection, filter bson.M, update interface{}) error {
+	return forEachBatch(ctx, coll, filter, func(batch []bson.M) error {
+		_, err := coll.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": batchIds(batch)}}, update)
+		return err
+	})
+}
+
+// batchIds returns the _id of every document of the batch.
+func batchIds(batch []bson.M) bson.A {
+	ids := make(bson.A, len(batch))
+	for i, document := range batch {
+		ids[i] = document["_id"]
+	}
+
+	return ids
+}
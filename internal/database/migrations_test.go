@@ -0,0 +1,145 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMongoMigrations(t *testing.T) {
+	if os.Getenv("STORAGE_TEST_MONGO") == "" {
+		t.Skip("STORAGE_TEST_MONGO is not set")
+	}
+
+	ctx := context.Background()
+
+	store, err := NewMongo(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	testMigrator(t, store, LatestMigrationVersion())
+
+	owner, err := store.lockMigrations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.MigrateDown(ctx); err != ErrMigrationLocked {
+		t.Errorf("Expected ErrMigrationLocked while locked but got %v", err)
+	}
+
+	store.unlockMigrations(ctx, owner)
+
+	if err := store.MigrateUp(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestSQLiteMigrations runs the migration runner against a throwaway SQLite
+// file, it needs no external service.
+func TestSQLiteMigrations(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := NewSQLite(ctx, filepath.Join(t.TempDir(), "migrations.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	migrations := sqliteDialect.migrations
+	testMigrator(t, store, migrations[len(migrations)-1].Version)
+
+	// a failing migration leaves neither its statements nor its record behind
+	broken := &sqlStorage{db: store.db, dialect: sqliteDialect}
+	broken.dialect.migrations = append(append([]sqlMigration{}, migrations...), sqlMigration{
+		Version: 999,
+		Name:    "broken",
+		Up:      `CREATE TABLE broken (id INTEGER); INSERT INTO missing VALUES (1);`,
+		Down:    `DROP TABLE broken;`,
+	})
+
+	if err := broken.MigrateUp(ctx); err == nil {
+		t.Errorf("Expected the broken migration to fail")
+	}
+
+	if n := pendingMigrations(t, broken); n != 1 {
+		t.Errorf("Expected the broken migration to stay pending but got %d pending", n)
+	}
+
+	var name string
+	err = store.db.QueryRowContext(ctx, `SELECT name FROM sqlite_master WHERE name = 'broken'`).Scan(&name)
+	if err != sql.ErrNoRows {
+		t.Errorf("Expected the statements of the broken migration to be rolled back but got %v", err)
+	}
+}
+
+// testMigrator moves a store without any applied migration up, down and back
+// up to the latest version.
+func testMigrator(t *testing.T, store Migrator, latest int) {
+	ctx := context.Background()
+
+	if err := store.MigrateUp(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := pendingMigrations(t, store); n != 0 {
+		t.Errorf("Expected no pending migration after up but got %d", n)
+	}
+
+	if err := store.MigrateUp(ctx); err != nil {
+		t.Errorf("Expected up without pending migrations to succeed but got %v", err)
+	}
+
+	if err := store.MigrateDown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := pendingMigrations(t, store); n != 1 {
+		t.Errorf("Expected 1 pending migration after down but got %d", n)
+	}
+
+	if err := store.MigrateTo(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	statuses, err := store.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := pendingMigrations(t, store); n != len(statuses) {
+		t.Errorf("Expected %d pending migrations after reverting all but got %d", len(statuses), n)
+	}
+
+	if err := store.MigrateTo(ctx, latest+1); err == nil {
+		t.Errorf("Expected unknown version to be rejected")
+	}
+
+	if err := store.MigrateTo(ctx, latest); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := pendingMigrations(t, store); n != 0 {
+		t.Errorf("Expected no pending migration after migrating to %d but got %d", latest, n)
+	}
+}
+
+func pendingMigrations(t *testing.T, store Migrator) int {
+	statuses, err := store.MigrationStatus(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := 0
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			count++
+		}
+	}
+
+	return count
+}
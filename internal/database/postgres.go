@@ -0,0 +1,93 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"net/url"
+	"strconv"
+
+	_ "github.com/lib/pq"
+)
+
+// POSTGRES_MIGRATION_LOCK is the key of the advisory lock held by a migration
+// run, the server releases it when the connection holding it closes so a
+// crashed run never leaves it behind.
+const POSTGRES_MIGRATION_LOCK = 1307202401
+
+//go:embed postgres/*.sql
+var postgresMigrationFiles embed.FS
+
+var postgresDialect = sqlDialect{
+	lockRows:        ` FOR UPDATE`,
+	binaryCollation: `COLLATE "C"`,
+	timestampType:   `TIMESTAMPTZ`,
+	migrations:      mustLoadSQLMigrations(postgresMigrationFiles, "postgres"),
+	lockMigrations:  lockPostgresMigrations,
+}
+
+// Postgres is the PostgreSQL implementation of the repositories, the schema is
+// created and changed by the SQL migrations under postgres/.
+type Postgres struct {
+	*sqlStorage
+}
+
+// NewPostgres connects to the Postgres server at the postgres:// URL. Every
+// query is bound to the context of its caller and the server cancels the
+// statements running longer than DATABASE_OPERATION_TIMEOUT, except those of
+// the migrations.
+func NewPostgres(ctx context.Context, dsn string) (*Postgres, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+		return nil, errors.New("the postgres storage needs a postgres:// URL")
+	}
+
+	// unknown URL parameters are sent to the server as session settings
+	query := u.Query()
+	query.Set("statement_timeout", strconv.FormatInt(operationTimeout.Milliseconds(), 10))
+	u.RawQuery = query.Encode()
+
+	db, err := sql.Open("postgres", u.String())
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.PingContext(ctx)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Postgres{&sqlStorage{db: db, dialect: postgresDialect}}, nil
+}
+
+// ====================== helper private function ====================== //
+
+// lockPostgresMigrations takes the advisory lock on the migration connection
+// and lifts its statement_timeout, a migration rewriting a large table runs
+// longer than any query of the server. Both are restored by the returned unlock.
+func lockPostgresMigrations(ctx context.Context, conn *sql.Conn) (func(), error) {
+	var locked bool
+	err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, POSTGRES_MIGRATION_LOCK).Scan(&locked)
+	if err != nil {
+		return nil, err
+	}
+
+	if !locked {
+		return nil, ErrMigrationLocked
+	}
+
+	unlock := func() {
+		conn.ExecContext(context.Background(), `RESET statement_timeout`)
+		conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, POSTGRES_MIGRATION_LOCK)
+	}
+
+	_, err = conn.ExecContext(ctx, `SET statement_timeout = 0`)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+
+	return unlock, nil
+}
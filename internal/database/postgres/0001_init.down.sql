@@ -0,0 +1,13 @@
+DROP TABLE join_requests;
+DROP TABLE project_members;
+DROP TABLE projects;
+DROP TABLE team_members;
+DROP TABLE teams;
+DROP TABLE invite_links;
+DROP TABLE invitations;
+DROP TABLE memberships;
+DROP TABLE organization_domains;
+DROP TABLE organization_tags;
+DROP TABLE organization_slugs;
+DROP TABLE organizations;
+DROP TABLE users;
@@ -0,0 +1,144 @@
+CREATE TABLE users (
+    email    TEXT PRIMARY KEY,
+    name     TEXT NOT NULL,
+    password TEXT NOT NULL
+);
+
+CREATE TABLE organizations (
+    id                  TEXT PRIMARY KEY,
+    slug                TEXT NOT NULL,
+    parent_id           TEXT REFERENCES organizations (id),
+    name                TEXT NOT NULL,
+    description         TEXT NOT NULL,
+    version             INTEGER NOT NULL DEFAULT 0,
+    timezone            TEXT NOT NULL DEFAULT '',
+    locale              TEXT NOT NULL DEFAULT '',
+    logo_url            TEXT NOT NULL DEFAULT '',
+    default_member_role TEXT NOT NULL DEFAULT '',
+    visibility          TEXT NOT NULL DEFAULT '',
+    metadata_fields     JSONB,
+    metadata            JSONB,
+    logo                JSONB,
+    max_members         INTEGER NOT NULL DEFAULT 0,
+    max_pending_invites INTEGER NOT NULL DEFAULT 0,
+    max_teams           INTEGER NOT NULL DEFAULT 0,
+    members_count       INTEGER NOT NULL DEFAULT 0 CHECK (members_count >= 0),
+    pending_invites     INTEGER NOT NULL DEFAULT 0 CHECK (pending_invites >= 0),
+    teams_count         INTEGER NOT NULL DEFAULT 0 CHECK (teams_count >= 0),
+    archived_at         TIMESTAMPTZ
+);
+
+CREATE INDEX organizations_parent_id_idx ON organizations (parent_id);
+CREATE INDEX organizations_archived_at_idx ON organizations (archived_at) WHERE archived_at IS NOT NULL;
+
+-- every slug an organization ever had, the current one included, so a slug
+-- is never reused and previous slugs still resolve
+CREATE TABLE organization_slugs (
+    id              BIGINT GENERATED ALWAYS AS IDENTITY,
+    slug            TEXT PRIMARY KEY,
+    organization_id TEXT NOT NULL REFERENCES organizations (id) ON DELETE CASCADE
+);
+
+CREATE INDEX organization_slugs_organization_id_idx ON organization_slugs (organization_id);
+
+CREATE TABLE organization_tags (
+    id              BIGINT GENERATED ALWAYS AS IDENTITY,
+    organization_id TEXT NOT NULL REFERENCES organizations (id) ON DELETE CASCADE,
+    tag             TEXT NOT NULL,
+    PRIMARY KEY (organization_id, tag)
+);
+
+CREATE TABLE organization_domains (
+    id              BIGINT GENERATED ALWAYS AS IDENTITY,
+    organization_id TEXT NOT NULL REFERENCES organizations (id) ON DELETE CASCADE,
+    domain          TEXT NOT NULL,
+    token           TEXT NOT NULL,
+    verified        BOOLEAN NOT NULL DEFAULT FALSE,
+    access_level    TEXT NOT NULL,
+    PRIMARY KEY (organization_id, domain)
+);
+
+CREATE INDEX organization_domains_domain_idx ON organization_domains (domain);
+
+CREATE TABLE memberships (
+    id              BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    organization_id TEXT NOT NULL REFERENCES organizations (id) ON DELETE CASCADE,
+    email           TEXT NOT NULL REFERENCES users (email) ON UPDATE CASCADE ON DELETE CASCADE,
+    access_level    TEXT NOT NULL,
+    UNIQUE (organization_id, email)
+);
+
+CREATE INDEX memberships_email_idx ON memberships (email);
+
+-- invitations are addressed to emails without an account yet
+CREATE TABLE invitations (
+    id              BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    organization_id TEXT NOT NULL REFERENCES organizations (id) ON DELETE CASCADE,
+    email           TEXT NOT NULL,
+    access_level    TEXT NOT NULL
+);
+
+CREATE INDEX invitations_organization_id_email_idx ON invitations (organization_id, email);
+CREATE INDEX invitations_email_idx ON invitations (email);
+
+CREATE TABLE invite_links (
+    code            TEXT PRIMARY KEY,
+    organization_id TEXT NOT NULL REFERENCES organizations (id) ON DELETE CASCADE,
+    access_level    TEXT NOT NULL,
+    max_uses        INTEGER NOT NULL,
+    uses            INTEGER NOT NULL DEFAULT 0 CHECK (uses >= 0),
+    expires_at      TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX invite_links_organization_id_idx ON invite_links (organization_id);
+
+CREATE TABLE teams (
+    id              TEXT PRIMARY KEY,
+    organization_id TEXT NOT NULL REFERENCES organizations (id) ON DELETE CASCADE,
+    name            TEXT NOT NULL,
+    description     TEXT NOT NULL
+);
+
+CREATE INDEX teams_organization_id_idx ON teams (organization_id);
+
+CREATE TABLE team_members (
+    id      BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    team_id TEXT NOT NULL REFERENCES teams (id) ON DELETE CASCADE,
+    email   TEXT NOT NULL REFERENCES users (email) ON UPDATE CASCADE ON DELETE CASCADE,
+    name    TEXT NOT NULL,
+    role    TEXT NOT NULL,
+    UNIQUE (team_id, email)
+);
+
+CREATE INDEX team_members_email_idx ON team_members (email);
+
+CREATE TABLE projects (
+    id              TEXT PRIMARY KEY,
+    organization_id TEXT NOT NULL REFERENCES organizations (id) ON DELETE CASCADE,
+    name            TEXT NOT NULL,
+    description     TEXT NOT NULL
+);
+
+CREATE INDEX projects_organization_id_idx ON projects (organization_id);
+
+CREATE TABLE project_members (
+    id           BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    project_id   TEXT NOT NULL REFERENCES projects (id) ON DELETE CASCADE,
+    email        TEXT NOT NULL REFERENCES users (email) ON UPDATE CASCADE ON DELETE CASCADE,
+    name         TEXT NOT NULL,
+    access_level TEXT NOT NULL,
+    UNIQUE (project_id, email)
+);
+
+CREATE INDEX project_members_email_idx ON project_members (email);
+
+CREATE TABLE join_requests (
+    id              TEXT PRIMARY KEY,
+    organization_id TEXT NOT NULL REFERENCES organizations (id) ON DELETE CASCADE,
+    email           TEXT NOT NULL,
+    name            TEXT NOT NULL,
+    status          TEXT NOT NULL,
+    created_at      TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX join_requests_organization_id_status_idx ON join_requests (organization_id, status);
@@ -0,0 +1,3 @@
+-- the member directory filters, sorts and pages on the lowercased email of the
+-- memberships of one organization, in bytes order
+CREATE INDEX memberships_organization_lower_email_idx ON memberships (organization_id, (lower(email)) COLLATE "C");
@@ -0,0 +1,180 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/zaher1307/IDEANEST-project-assignment/internal/types"
+)
+
+// MAX_SLUG_ATTEMPTS bounds the retries when concurrent writes race for a slug.
+const MAX_SLUG_ATTEMPTS = 5
+
+// ErrQuotaExceeded is wrapped by every error caused by an organization quota.
+var ErrQuotaExceeded = errors.New("organization quota exceeded")
+
+var ErrOrgNotFound = errors.New("org doesn't exists")
+
+// ErrEmailExists is returned when a user is created or renamed with an email
+// that already has an account.
+var ErrEmailExists = errors.New("email already exists")
+
+// ErrOrgModified is returned when an organization is updated with a version
+// that is no longer its current one.
+var ErrOrgModified = errors.New("org was modified since it was read")
+
+// ErrMixedOrgInvitations is returned when a batch of invitations spans several
+// organizations, the quota of a batch is reserved on a single organization.
+var ErrMixedOrgInvitations = errors.New("invitations of a batch must belong to one organization")
+
+// UserRepository stores the user accounts.
+type UserRepository interface {
+	// CreateUser returns ErrEmailExists when the email already has an account.
+	CreateUser(ctx context.Context, user types.User) error
+	// ReadUser returns an empty user when the email has no account.
+	ReadUser(ctx context.Context, email string) (types.User, error)
+	ReadUsers(ctx context.Context, emails []string) ([]types.User, error)
+	// UpdateUserEmail renames the user and every copy of their email, it
+	// returns ErrEmailExists when the new email already has an account.
+	UpdateUserEmail(ctx context.Context, email, newEmail string) error
+}
+
+// OrgRepository stores the organizations and everything that belongs to them:
+// members, invitations, invite links, teams, projects and join requests.
+// Archived organizations are hidden from every read unless stated otherwise.
+type OrgRepository interface {
+	// CreateOrg stores the organization under the first free variant of its
+	// slug and adds the user as its admin.
+	CreateOrg(ctx context.Context, org types.Org, user types.User) (string, error)
+	// UpdateOrg only applies when orgInfo.Version is the current version of the
+	// organization, ErrOrgModified is returned otherwise. A zero version
+	// updates whatever the current version is.
+	UpdateOrg(ctx context.Context, orgInfo types.OrgInfo) (types.OrgInfo, error)
+	// ResolveOrgSlug returns the ID and current slug of the organization owning
+	// the slug, as its current or one of its previous slugs, archived or not.
+	ResolveOrgSlug(ctx context.Context, slug string) (string, string, error)
+	ReadOrg(ctx context.Context, orgId string) (types.Org, error)
+	ReadArchivedOrg(ctx context.Context, orgId string) (types.Org, error)
+	IsOrgAdmin(ctx context.Context, orgId, email string) (bool, error)
+	ReadAllOrgsInfo(ctx context.Context, email string) ([]types.Org, error)
+	ReadOrgAncestors(ctx context.Context, orgId string) ([]types.Org, error)
+	ReadOrgDescendants(ctx context.Context, orgId string) ([]types.Org, error)
+	UpdateOrgSettings(ctx context.Context, orgId string, settings types.OrgSettings) error
+	UpdateOrgMetadata(ctx context.Context, orgId string, fields []types.MetadataField, metadata map[string]interface{}) error
+	AddOrgTags(ctx context.Context, orgId string, tags []string, maxTags int) error
+	RemoveOrgTag(ctx context.Context, orgId, tag string) error
+	UpdateOrgVisibility(ctx context.Context, orgId, visibility string) error
+	UpdateOrgLogo(ctx context.Context, orgId string, logo map[string]string) (map[string]string, error)
+	SearchDiscoverableOrgs(ctx context.Context, query string, limit int) ([]types.Org, error)
+	AddOrgDomain(ctx context.Context, orgId string, orgDomain types.OrgDomain) error
+	VerifyOrgDomain(ctx context.Context, orgId, domain string) error
+	RemoveOrgDomain(ctx context.Context, orgId, domain string) error
+	ReadOrgsByVerifiedDomain(ctx context.Context, domain string) ([]types.Org, error)
+	UpdateOrgQuota(ctx context.Context, orgId string, quota types.OrgQuota) error
+	MoveOrg(ctx context.Context, orgId, parentId string) error
+	DeleteOrg(ctx context.Context, orgId string) error
+	RestoreOrg(ctx context.Context, orgId string, archivedAfter time.Time) error
+	PurgeArchivedOrgs(ctx context.Context, archivedBefore time.Time) ([]types.Org, error)
+
+	// InviteUserToOrg and RemoveUserFromOrg increment the version of the
+	// organization, like UpdateOrg they return ErrOrgModified unless a
+	// non-zero version is the current one.
+	InviteUserToOrg(ctx context.Context, orgId string, member types.OrgMember, version int) error
+	InviteUsersToOrg(ctx context.Context, orgId string, members []types.OrgMember) error
+	RemoveUserFromOrg(ctx context.Context, orgId, email string, version int) error
+	// ReadOrgMembers is the only read loading members, the organization
+	// reads leave them out. The members of archived organizations are read too.
+	ReadOrgMembers(ctx context.Context, orgId string, query types.MemberQuery) ([]types.OrgMember, error)
+	IsOrgMember(ctx context.Context, orgId, email string) bool
+
+	CreateInvitation(ctx context.Context, invitation types.Invitation) error
+	// CreateInvitations returns ErrMixedOrgInvitations unless every invitation
+	// belongs to the same organization.
+	CreateInvitations(ctx context.Context, invitations []types.Invitation) error
+	// ImportMembersToOrg adds the members and creates the invitations all or
+	// nothing, the version is checked like in InviteUserToOrg when there are
+	// members to add. Invitations don't change the version.
+	ImportMembersToOrg(ctx context.Context, orgId string, members []types.OrgMember, invitations []types.Invitation, version int) error
+	ReadOrgInvitations(ctx context.Context, orgId string) ([]types.Invitation, error)
+	ReadInvitations(ctx context.Context, email string) ([]types.Invitation, error)
+	IsInvitedToOrg(ctx context.Context, orgId, email string) bool
+	DeleteInvitation(ctx context.Context, orgId, email string) error
+
+	CreateInviteLink(ctx context.Context, link types.InviteLink) error
+	ReadInviteLinks(ctx context.Context, orgId string) ([]types.InviteLink, error)
+	DeleteInviteLink(ctx context.Context, orgId, code string) error
+	RedeemInviteLink(ctx context.Context, code string) (types.InviteLink, error)
+	ReleaseInviteLink(ctx context.Context, code string) error
+
+	CreateTeam(ctx context.Context, team types.Team) (string, error)
+	ReadTeam(ctx context.Context, orgId, teamId string) (types.Team, error)
+	ReadOrgsTeams(ctx context.Context, orgIds []string) ([]types.Team, error)
+	UpdateTeam(ctx context.Context, team types.Team) error
+	DeleteTeam(ctx context.Context, orgId, teamId string) error
+	AddTeamMember(ctx context.Context, orgId, teamId string, member types.TeamMember) error
+	RemoveTeamMember(ctx context.Context, orgId, teamId, email string) error
+
+	CreateProject(ctx context.Context, project types.Project) (string, error)
+	ReadProject(ctx context.Context, orgId, projectId string) (types.Project, error)
+	ReadOrgProjects(ctx context.Context, orgId string) ([]types.Project, error)
+	UpdateProject(ctx context.Context, project types.Project) error
+	DeleteProject(ctx context.Context, orgId, projectId string) error
+	SetProjectMember(ctx context.Context, orgId, projectId string, member types.ProjectMember) error
+	RemoveProjectMember(ctx context.Context, orgId, projectId, email string) error
+
+	CreateJoinRequest(ctx context.Context, joinRequest types.JoinRequest) (string, error)
+	ReadPendingJoinRequests(ctx context.Context, orgId string) ([]types.JoinRequest, error)
+	HasPendingJoinRequest(ctx context.Context, orgId, email string) bool
+	SetJoinRequestStatus(ctx context.Context, orgId, requestId, from, to string) (types.JoinRequest, error)
+}
+
+// Storage is a storage backend implementing every repository.
+type Storage interface {
+	UserRepository
+	OrgRepository
+	Close() error
+}
+
+// Open returns the storage backend of the given kind, "mongo", "postgres",
+// "sqlite" or "memory".
+func Open(ctx context.Context, kind string) (Storage, error) {
+	switch kind {
+	case "mongo":
+		return NewMongo(ctx)
+	case "postgres":
+		return NewPostgres(ctx, postgresURL)
+	case "sqlite":
+		return NewSQLite(ctx, sqlitePath)
+	case "memory":
+		return NewMemory(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage %q", kind)
+	}
+}
+
+// ====================== helper private function ====================== //
+
+// checkInvitationsOrg returns ErrMixedOrgInvitations when an invitation of the
+// batch doesn't belong to the organization.
+func checkInvitationsOrg(orgId string, invitations []types.Invitation) error {
+	for _, invitation := range invitations {
+		if invitation.OrgId != orgId {
+			return ErrMixedOrgInvitations
+		}
+	}
+
+	return nil
+}
+
+// renamedSlugHistory returns the slug history of an organization moving from
+// its current slug to the new one, which is no longer history when reclaimed.
+func renamedSlugHistory(history []string, slug, newSlug string) []string {
+	history = remove(history, newSlug)
+	if slug != "" && !contains(history, slug) {
+		history = append(history, slug)
+	}
+
+	return history
+}
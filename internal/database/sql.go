@@ -0,0 +1,1825 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/zaher1307/IDEANEST-project-assignment/internal/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// MAX_INSERT_ROWS bounds the rows of a single multi-row insert, far below the
+// parameters a Postgres or SQLite statement accepts.
+const MAX_INSERT_ROWS = 1000
+
+const orgColumns = `id, slug, parent_id, name, description, version,
+	timezone, locale, logo_url, default_member_role, visibility,
+	metadata_fields, metadata, logo,
+	max_members, max_pending_invites, max_teams,
+	members_count, pending_invites, teams_count, archived_at`
+
+// sqlStorage implements the repositories on a SQL database, Postgres and
+// SQLite share it and only differ by their sqlDialect. Foreign keys tie
+// everything that belongs to an organization to it, purging an organization
+// deletes all of it through their cascades. Times are written in UTC, SQLite
+// stores them as text and compares them as such.
+type sqlStorage struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+// sqlDialect holds what differs between the SQL databases.
+type sqlDialect struct {
+	// lockRows locks the selected rows until the end of the transaction.
+	lockRows string
+	// binaryCollation orders strings by their bytes, whatever the collation
+	// of the database is.
+	binaryCollation string
+	// timestampType is the column type of the times, the SQLite driver only
+	// parses the times of TIMESTAMP columns back.
+	timestampType string
+	migrations    []sqlMigration
+	// lockMigrations keeps two runs from migrating at once, until unlock.
+	lockMigrations func(ctx context.Context, conn *sql.Conn) (unlock func(), err error)
+}
+
+// sqlQuerier runs queries either directly on the pool or in a transaction.
+type sqlQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func (s *sqlStorage) Close() error {
+	return s.db.Close()
+}
+
+func (s *sqlStorage) CreateUser(ctx context.Context, user types.User) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO users (email, name, password) VALUES ($1, $2, $3)`,
+		user.Email, user.Name, user.Password)
+	if isUniqueViolation(err) {
+		return ErrEmailExists
+	}
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *sqlStorage) ReadUser(ctx context.Context, email string) (types.User, error) {
+	var user types.User
+	err := s.db.QueryRowContext(ctx,
+		`SELECT email, name, password FROM users WHERE email = $1`, email).
+		Scan(&user.Email, &user.Name, &user.Password)
+	if err == sql.ErrNoRows {
+		return types.User{}, nil
+	}
+	if err != nil {
+		return types.User{}, err
+	}
+
+	return user, nil
+}
+
+func (s *sqlStorage) ReadUsers(ctx context.Context, emails []string) ([]types.User, error) {
+	if len(emails) == 0 {
+		return nil, nil
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT email, name, password FROM users WHERE email IN (`+placeholders(1, len(emails))+`)`,
+		stringArgs(emails)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []types.User
+	for rows.Next() {
+		var user types.User
+		err := rows.Scan(&user.Email, &user.Name, &user.Password)
+		if err != nil {
+			return nil, err
+		}
+
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+// UpdateUserEmail renames the user, the foreign keys of the memberships, team
+// members and project members cascade the new email to them. Join requests
+// aren't tied to the user and are renamed in the same transaction.
+func (s *sqlStorage) UpdateUserEmail(ctx context.Context, email, newEmail string) error {
+	return s.withTransaction(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `UPDATE users SET email = $2 WHERE email = $1`, email, newEmail)
+		if isUniqueViolation(err) {
+			return ErrEmailExists
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE join_requests SET email = $2 WHERE email = $1`, email, newEmail)
+		return err
+	})
+}
+
+// CreateOrg stores the organization under the first free variant of its slug,
+// retrying with the next one when a concurrent insert takes it first.
+func (s *sqlStorage) CreateOrg(ctx context.Context, org types.Org, user types.User) (string, error) {
+	baseSlug := org.Slug
+	member := types.OrgMember{
+		UserInfo: types.UserInfo{
+			Name:  user.Name,
+			Email: user.Email,
+		},
+		AccessLevel: types.ACCESS_LEVEL_ADMIN,
+	}
+
+	metadataFields, metadata, logo, err := orgDocuments(org)
+	if err != nil {
+		return "", err
+	}
+
+	for attempt := 0; ; attempt++ {
+		org.Slug, err = s.availableSlug(ctx, baseSlug, "")
+		if err != nil {
+			return "", err
+		}
+
+		id := primitive.NewObjectID().Hex()
+
+		// the organization never exists without its admin
+		err = s.withTransaction(ctx, func(tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, `INSERT INTO organizations (`+orgColumns+`)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14,
+					$15, $16, $17, 0, 0, 0, $18)`,
+				id, org.Slug, nullString(org.ParentId), org.Name, org.Description, org.Version,
+				org.Timezone, org.Locale, org.LogoURL, org.DefaultMemberRole, org.Visibility,
+				metadataFields, metadata, logo,
+				org.Quota.MaxMembers, org.Quota.MaxPendingInvites, org.Quota.MaxTeams, nullTime(org.ArchivedAt))
+			if err != nil {
+				return err
+			}
+
+			err = addOrgSlug(ctx, tx, id, org.Slug)
+			if err != nil {
+				return err
+			}
+
+			for _, tag := range org.Tags {
+				_, err = tx.ExecContext(ctx,
+					`INSERT INTO organization_tags (organization_id, tag) VALUES ($1, $2)`, id, tag)
+				if err != nil {
+					return err
+				}
+			}
+
+			for _, domain := range org.Domains {
+				_, err = tx.ExecContext(ctx, `INSERT INTO organization_domains
+					(organization_id, domain, token, verified, access_level) VALUES ($1, $2, $3, $4, $5)`,
+					id, domain.Domain, domain.Token, domain.Verified, domain.AccessLevel)
+				if err != nil {
+					return err
+				}
+			}
+
+			return inviteUsersToOrg(ctx, tx, id, []types.OrgMember{member}, 0)
+		})
+		if isUniqueViolation(err) && attempt < MAX_SLUG_ATTEMPTS {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		return id, nil
+	}
+}
+
+// UpdateOrg renames the organization, when the new name leads to a different
+// slug the new one is added to the slugs of the organization and the previous
+// ones keep resolving, a previous one is reclaimed when the name leads back to
+// it. The update is conditional on the version the slug was
+// computed from, without an expected version a concurrent write makes it
+// start over.
+func (s *sqlStorage) UpdateOrg(ctx context.Context, orgInfo types.OrgInfo) (types.OrgInfo, error) {
+	for attempt := 0; ; attempt++ {
+		org, err := s.ReadOrg(ctx, orgInfo.OrgId)
+		if err != nil {
+			return types.OrgInfo{}, err
+		}
+
+		if orgInfo.Version != 0 && orgInfo.Version != org.Version {
+			return types.OrgInfo{}, ErrOrgModified
+		}
+
+		slug := org.Slug
+		if orgInfo.Slug != org.Slug {
+			slug, err = s.availableSlug(ctx, orgInfo.Slug, org.OrgId)
+			if err != nil {
+				return types.OrgInfo{}, err
+			}
+		}
+
+		matched := false
+		err = s.withTransaction(ctx, func(tx *sql.Tx) error {
+			result, err := tx.ExecContext(ctx, `UPDATE organizations
+				SET name = $3, description = $4, slug = $5, version = version + 1
+				WHERE id = $1 AND version = $2`,
+				orgInfo.OrgId, org.Version, orgInfo.Name, orgInfo.Description, slug)
+			if err != nil {
+				return err
+			}
+
+			matched, err = affected(result)
+			if err != nil || !matched || slug == org.Slug || contains(org.SlugHistory, slug) {
+				return err
+			}
+
+			return addOrgSlug(ctx, tx, orgInfo.OrgId, slug)
+		})
+		if isUniqueViolation(err) && attempt < MAX_SLUG_ATTEMPTS {
+			continue
+		}
+		if err != nil {
+			return types.OrgInfo{}, err
+		}
+
+		if !matched {
+			if orgInfo.Version == 0 && attempt < MAX_SLUG_ATTEMPTS {
+				continue
+			}
+
+			return types.OrgInfo{}, ErrOrgModified
+		}
+
+		return types.OrgInfo{
+			OrgId:       orgInfo.OrgId,
+			Slug:        slug,
+			ParentId:    org.ParentId,
+			Name:        orgInfo.Name,
+			Description: orgInfo.Description,
+			Version:     org.Version + 1,
+		}, nil
+	}
+}
+
+// ResolveOrgSlug finds the organization that owns the slug, either as its
+// current slug or as one of its previous ones, and returns its ID and current slug.
+func (s *sqlStorage) ResolveOrgSlug(ctx context.Context, slug string) (string, string, error) {
+	var orgId, currentSlug string
+	err := s.db.QueryRowContext(ctx, `SELECT o.id, o.slug
+		FROM organization_slugs s JOIN organizations o ON o.id = s.organization_id
+		WHERE s.slug = $1`, slug).Scan(&orgId, &currentSlug)
+	if err == sql.ErrNoRows {
+		return "", "", ErrOrgNotFound
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	return orgId, currentSlug, nil
+}
+
+func (s *sqlStorage) ReadOrg(ctx context.Context, orgId string) (types.Org, error) {
+	return findOrg(ctx, s.db, `WHERE id = $1 AND archived_at IS NULL`, orgId)
+}
+
+func (s *sqlStorage) ReadArchivedOrg(ctx context.Context, orgId string) (types.Org, error) {
+	return findOrg(ctx, s.db, `WHERE id = $1 AND archived_at IS NOT NULL`, orgId)
+}
+
+func (s *sqlStorage) IsOrgAdmin(ctx context.Context, orgId, email string) (bool, error) {
+	var isAdmin bool
+	err := s.db.QueryRowContext(ctx, `SELECT EXISTS (
+			SELECT 1 FROM memberships
+			WHERE organization_id = o.id AND email = $2 AND access_level = $3
+		)
+		FROM organizations o WHERE o.id = $1 AND o.archived_at IS NULL`,
+		orgId, email, types.ACCESS_LEVEL_ADMIN).Scan(&isAdmin)
+	if err == sql.ErrNoRows {
+		return false, ErrOrgNotFound
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return isAdmin, nil
+}
+
+func (s *sqlStorage) ReadAllOrgsInfo(ctx context.Context, email string) ([]types.Org, error) {
+	return findOrgs(ctx, s.db, `WHERE archived_at IS NULL
+		AND id IN (SELECT organization_id FROM memberships WHERE email = $1)
+		ORDER BY id`, email)
+}
+
+// ReadOrgAncestors walks up the parent references of an organization and
+// returns its ancestors ordered from the direct parent up to the root,
+// archived ancestors are walked through but not returned.
+func (s *sqlStorage) ReadOrgAncestors(ctx context.Context, orgId string) ([]types.Org, error) {
+	org, err := findOrg(ctx, s.db, `WHERE id = $1`, orgId)
+	if err != nil {
+		return nil, err
+	}
+
+	var ancestors []types.Org
+	visited := map[string]bool{orgId: true}
+	for org.ParentId != "" && !visited[org.ParentId] {
+		visited[org.ParentId] = true
+
+		org, err = findOrg(ctx, s.db, `WHERE id = $1`, org.ParentId)
+		if err != nil {
+			return nil, err
+		}
+
+		if org.ArchivedAt == nil {
+			ancestors = append(ancestors, org)
+		}
+	}
+
+	return ancestors, nil
+}
+
+// ReadOrgDescendants returns every organization below the given one, the tree
+// is visited level by level with a single query per level and archived
+// organizations are walked through but not returned.
+func (s *sqlStorage) ReadOrgDescendants(ctx context.Context, orgId string) ([]types.Org, error) {
+	var descendants []types.Org
+	visited := map[string]bool{orgId: true}
+	level := []string{orgId}
+	for len(level) > 0 {
+		children, err := findOrgs(ctx, s.db,
+			`WHERE parent_id IN (`+placeholders(1, len(level))+`) ORDER BY id`, stringArgs(level)...)
+		if err != nil {
+			return nil, err
+		}
+
+		level = nil
+		for _, child := range children {
+			if visited[child.OrgId] {
+				continue
+			}
+			visited[child.OrgId] = true
+			level = append(level, child.OrgId)
+			if child.ArchivedAt == nil {
+				descendants = append(descendants, child)
+			}
+		}
+	}
+
+	return descendants, nil
+}
+
+func (s *sqlStorage) UpdateOrgSettings(ctx context.Context, orgId string, settings types.OrgSettings) error {
+	return updateOrg(ctx, s.db, orgId, `timezone = $2, locale = $3, logo_url = $4,
+		default_member_role = $5, visibility = $6`,
+		settings.Timezone, settings.Locale, settings.LogoURL, settings.DefaultMemberRole, settings.Visibility)
+}
+
+func (s *sqlStorage) UpdateOrgMetadata(ctx context.Context, orgId string, fields []types.MetadataField, metadata map[string]interface{}) error {
+	metadataFields, metadataDocument, _, err := orgDocuments(types.Org{MetadataFields: fields, Metadata: metadata})
+	if err != nil {
+		return err
+	}
+
+	return updateOrg(ctx, s.db, orgId, `metadata_fields = $2, metadata = $3`, metadataFields, metadataDocument)
+}
+
+// AddOrgTags adds the tags that the organization doesn't have yet, the
+// organization is locked so concurrent additions cannot exceed maxTags together.
+func (s *sqlStorage) AddOrgTags(ctx context.Context, orgId string, tags []string, maxTags int) error {
+	errTooManyTags := fmt.Errorf("org doesn't exists or would exceed %d tags", maxTags)
+
+	return s.withTransaction(ctx, func(tx *sql.Tx) error {
+		var id string
+		err := tx.QueryRowContext(ctx,
+			`SELECT id FROM organizations WHERE id = $1 AND archived_at IS NULL`+s.dialect.lockRows, orgId).Scan(&id)
+		if err == sql.ErrNoRows {
+			return errTooManyTags
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, tag := range tags {
+			_, err = tx.ExecContext(ctx, `INSERT INTO organization_tags (organization_id, tag)
+				VALUES ($1, $2) ON CONFLICT DO NOTHING`, orgId, tag)
+			if err != nil {
+				return err
+			}
+		}
+
+		var count int
+		err = tx.QueryRowContext(ctx,
+			`SELECT count(*) FROM organization_tags WHERE organization_id = $1`, orgId).Scan(&count)
+		if err != nil {
+			return err
+		}
+
+		if count > maxTags {
+			return errTooManyTags
+		}
+
+		return bumpOrgVersion(ctx, tx, orgId, 0)
+	})
+}
+
+func (s *sqlStorage) RemoveOrgTag(ctx context.Context, orgId, tag string) error {
+	return s.withTransaction(ctx, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx,
+			`DELETE FROM organization_tags WHERE organization_id = $1 AND tag = $2`, orgId, tag)
+		if err != nil {
+			return err
+		}
+
+		if deleted, err := affected(result); err != nil || !deleted {
+			return orError(err, errors.New("org doesn't have this tag"))
+		}
+
+		return bumpOrgVersion(ctx, tx, orgId, 0)
+	})
+}
+
+func (s *sqlStorage) UpdateOrgVisibility(ctx context.Context, orgId, visibility string) error {
+	return updateOrg(ctx, s.db, orgId, `visibility = $2`, visibility)
+}
+
+// UpdateOrgLogo replaces the blob keys of the organization logo sizes and
+// returns the previous ones so their blobs can be deleted, a nil logo removes it.
+func (s *sqlStorage) UpdateOrgLogo(ctx context.Context, orgId string, logo map[string]string) (map[string]string, error) {
+	_, _, logoDocument, err := orgDocuments(types.Org{Logo: logo})
+	if err != nil {
+		return nil, err
+	}
+
+	var previous map[string]string
+	err = s.withTransaction(ctx, func(tx *sql.Tx) error {
+		var document []byte
+		err := tx.QueryRowContext(ctx,
+			`SELECT logo FROM organizations WHERE id = $1 AND archived_at IS NULL`+s.dialect.lockRows, orgId).Scan(&document)
+		if err == sql.ErrNoRows {
+			return ErrOrgNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		err = decodeDocument(document, &previous)
+		if err != nil {
+			return err
+		}
+
+		return updateOrg(ctx, tx, orgId, `logo = $2`, logoDocument)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return previous, nil
+}
+
+// SearchDiscoverableOrgs matches the query as a case-insensitive substring of
+// the organization name, private organizations are never returned.
+func (s *sqlStorage) SearchDiscoverableOrgs(ctx context.Context, query string, limit int) ([]types.Org, error) {
+	return findOrgs(ctx, s.db, `WHERE archived_at IS NULL AND visibility = $1
+		AND lower(name) LIKE $2 ESCAPE '\'
+		ORDER BY name `+s.dialect.binaryCollation+`, id LIMIT $3`,
+		types.ORG_VISIBILITY_DISCOVERABLE, "%"+escapeLike(strings.ToLower(query))+"%", limit)
+}
+
+func (s *sqlStorage) AddOrgDomain(ctx context.Context, orgId string, orgDomain types.OrgDomain) error {
+	return s.withTransaction(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `INSERT INTO organization_domains
+			(organization_id, domain, token, verified, access_level) VALUES ($1, $2, $3, $4, $5)`,
+			orgId, orgDomain.Domain, orgDomain.Token, orgDomain.Verified, orgDomain.AccessLevel)
+		if isUniqueViolation(err) {
+			return errors.New("domain already claimed by this organization")
+		}
+		if isForeignKeyViolation(err) {
+			return ErrOrgNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		return bumpOrgVersion(ctx, tx, orgId, 0)
+	})
+}
+
+func (s *sqlStorage) VerifyOrgDomain(ctx context.Context, orgId, domain string) error {
+	return s.withTransaction(ctx, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, `UPDATE organization_domains SET verified = TRUE
+			WHERE organization_id = $1 AND domain = $2`, orgId, domain)
+		if err != nil {
+			return err
+		}
+
+		if updated, err := affected(result); err != nil || !updated {
+			return orError(err, errors.New("domain is not claimed by this organization"))
+		}
+
+		return bumpOrgVersion(ctx, tx, orgId, 0)
+	})
+}
+
+func (s *sqlStorage) RemoveOrgDomain(ctx context.Context, orgId, domain string) error {
+	return s.withTransaction(ctx, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx,
+			`DELETE FROM organization_domains WHERE organization_id = $1 AND domain = $2`, orgId, domain)
+		if err != nil {
+			return err
+		}
+
+		if deleted, err := affected(result); err != nil || !deleted {
+			return orError(err, errors.New("domain is not claimed by this organization"))
+		}
+
+		return bumpOrgVersion(ctx, tx, orgId, 0)
+	})
+}
+
+func (s *sqlStorage) ReadOrgsByVerifiedDomain(ctx context.Context, domain string) ([]types.Org, error) {
+	return findOrgs(ctx, s.db, `WHERE archived_at IS NULL AND id IN (
+			SELECT organization_id FROM organization_domains WHERE domain = $1 AND verified
+		) ORDER BY id`, domain)
+}
+
+func (s *sqlStorage) UpdateOrgQuota(ctx context.Context, orgId string, quota types.OrgQuota) error {
+	return updateOrg(ctx, s.db, orgId, `max_members = $2, max_pending_invites = $3, max_teams = $4`,
+		quota.MaxMembers, quota.MaxPendingInvites, quota.MaxTeams)
+}
+
+func (s *sqlStorage) MoveOrg(ctx context.Context, orgId, parentId string) error {
+	return updateOrg(ctx, s.db, orgId, `parent_id = $2`, nullString(parentId))
+}
+
+// DeleteOrg archives the organization, it disappears from every read until it
+// is restored, or purged for good by PurgeArchivedOrgs.
+func (s *sqlStorage) DeleteOrg(ctx context.Context, orgId string) error {
+	result, err := s.db.ExecContext(ctx, `UPDATE organizations SET archived_at = $2, version = version + 1
+		WHERE id = $1 AND archived_at IS NULL`, orgId, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	if archived, err := affected(result); err != nil || !archived {
+		return orError(err, ErrOrgNotFound)
+	}
+
+	return nil
+}
+
+// RestoreOrg brings back an organization archived after the given time.
+func (s *sqlStorage) RestoreOrg(ctx context.Context, orgId string, archivedAfter time.Time) error {
+	result, err := s.db.ExecContext(ctx, `UPDATE organizations SET archived_at = NULL, version = version + 1
+		WHERE id = $1 AND archived_at >= $2`, orgId, archivedAfter.UTC())
+	if err != nil {
+		return err
+	}
+
+	if restored, err := affected(result); err != nil || !restored {
+		return orError(err, errors.New("org is not archived or its retention period is over"))
+	}
+
+	return nil
+}
+
+// PurgeArchivedOrgs permanently deletes the organizations archived before the
+// given time and returns the purged organizations. The children of a purged
+// organization are attached to its parent and everything else that belongs
+// to it is deleted by the foreign key cascades.
+func (s *sqlStorage) PurgeArchivedOrgs(ctx context.Context, archivedBefore time.Time) ([]types.Org, error) {
+	orgs, err := findOrgs(ctx, s.db, `WHERE archived_at < $1 ORDER BY id`, archivedBefore.UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	for i, org := range orgs {
+		err = s.withTransaction(ctx, func(tx *sql.Tx) error {
+			// the parent is read again, purging an ancestor first may have changed it
+			_, err := tx.ExecContext(ctx, `UPDATE organizations
+				SET parent_id = (SELECT parent_id FROM organizations WHERE id = $1), version = version + 1
+				WHERE parent_id = $1`, org.OrgId)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.ExecContext(ctx, `DELETE FROM organizations WHERE id = $1`, org.OrgId)
+			return err
+		})
+		if err != nil {
+			return orgs[:i], err
+		}
+	}
+
+	return orgs, nil
+}
+
+func (s *sqlStorage) InviteUserToOrg(ctx context.Context, orgId string, member types.OrgMember, version int) error {
+	return s.withTransaction(ctx, func(tx *sql.Tx) error {
+		return inviteUsersToOrg(ctx, tx, orgId, []types.OrgMember{member}, version)
+	})
+}
+
+// InviteUsersToOrg reserves room in the member quota and stores the
+// memberships in one transaction, so concurrent invitations can neither
+// exceed the quota nor add the same member twice.
+func (s *sqlStorage) InviteUsersToOrg(ctx context.Context, orgId string, members []types.OrgMember) error {
+	if len(members) == 0 {
+		return nil
+	}
+
+	return s.withTransaction(ctx, func(tx *sql.Tx) error {
+		return inviteUsersToOrg(ctx, tx, orgId, members, 0)
+	})
+}
+
+// RemoveUserFromOrg drops the membership and the member from every team and
+// project of that organization, in one transaction.
+func (s *sqlStorage) RemoveUserFromOrg(ctx context.Context, orgId, email string, version int) error {
+	return s.withTransaction(ctx, func(tx *sql.Tx) error {
+		err := bumpOrgVersion(ctx, tx, orgId, version)
+		if err != nil {
+			return err
+		}
+
+		result, err := tx.ExecContext(ctx,
+			`DELETE FROM memberships WHERE organization_id = $1 AND email = $2`, orgId, email)
+		if err != nil {
+			return err
+		}
+
+		deleted, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		err = releaseOrgUsage(ctx, tx, orgId, "members_count", int(deleted))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM team_members WHERE email = $2
+			AND team_id IN (SELECT id FROM teams WHERE organization_id = $1)`, orgId, email)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM project_members WHERE email = $2
+			AND project_id IN (SELECT id FROM projects WHERE organization_id = $1)`, orgId, email)
+		return err
+	})
+}
+
+// ReadOrgMembers returns one page of the members of the organization matching
+// the query. Members are compared and sorted case-insensitively and the email
+// breaks ties, so the pages stay stable while members are added or removed.
+func (s *sqlStorage) ReadOrgMembers(ctx context.Context, orgId string, query types.MemberQuery) ([]types.OrgMember, error) {
+	sortKey := `lower(coalesce(u.name, '')) ` + s.dialect.binaryCollation
+	if query.SortBy == "email" {
+		sortKey = `lower(m.email) ` + s.dialect.binaryCollation
+	}
+
+	order, after := "ASC", ">"
+	if query.Descending {
+		order, after = "DESC", "<"
+	}
+
+	clause := `WHERE m.organization_id = $1`
+	args := []interface{}{orgId}
+
+	if query.Role != "" {
+		args = append(args, query.Role)
+		clause += ` AND m.access_level = $` + strconv.Itoa(len(args))
+	}
+
+	if len(query.Emails) > 0 {
+		clause += ` AND m.email IN (` + placeholders(len(args)+1, len(query.Emails)) + `)`
+		args = append(args, stringArgs(query.Emails)...)
+	}
+
+	if query.Prefix != "" {
+		args = append(args, escapeLike(strings.ToLower(query.Prefix))+"%")
+		n := strconv.Itoa(len(args))
+		clause += ` AND (lower(coalesce(u.name, '')) LIKE $` + n + ` ESCAPE '\' OR lower(m.email) LIKE $` + n + ` ESCAPE '\')`
+	}
+
+	// the emails are unique within the organization, sorting by email needs
+	// no tie-breaker and is read in order from the (organization_id, lower(email))
+	// index
+	orderBy := fmt.Sprintf(`%s %s, lower(m.email) %s %s`, sortKey, order, s.dialect.binaryCollation, order)
+	if query.SortBy == "email" {
+		orderBy = sortKey + ` ` + order
+	}
+
+	if query.After != nil && query.SortBy == "email" {
+		args = append(args, strings.ToLower(query.After.Email))
+		clause += fmt.Sprintf(` AND %s %s $%d`, sortKey, after, len(args))
+	} else if query.After != nil {
+		args = append(args, strings.ToLower(query.After.Key), strings.ToLower(query.After.Email))
+		clause += fmt.Sprintf(` AND (%s, lower(m.email) %s) %s ($%d, $%d)`, sortKey, s.dialect.binaryCollation, after, len(args)-1, len(args))
+	}
+
+	args = append(args, query.Limit)
+	rows, err := s.db.QueryContext(ctx, `SELECT m.email, m.access_level, coalesce(u.name, '')
+		FROM memberships m LEFT JOIN users u ON u.email = m.email `+clause+
+		fmt.Sprintf(` ORDER BY %s LIMIT $%d`, orderBy, len(args)),
+		args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []types.OrgMember{}
+	for rows.Next() {
+		var member types.OrgMember
+		err := rows.Scan(&member.Email, &member.AccessLevel, &member.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		members = append(members, member)
+	}
+
+	return members, rows.Err()
+}
+
+func (s *sqlStorage) IsOrgMember(ctx context.Context, orgId, email string) bool {
+	return s.exists(ctx, `SELECT EXISTS (
+		SELECT 1 FROM memberships WHERE organization_id = $1 AND email = $2
+	)`, orgId, email)
+}
+
+func (s *sqlStorage) CreateInvitation(ctx context.Context, invitation types.Invitation) error {
+	return s.CreateInvitations(ctx, []types.Invitation{invitation})
+}
+
+func (s *sqlStorage) CreateInvitations(ctx context.Context, invitations []types.Invitation) error {
+	if len(invitations) == 0 {
+		return nil
+	}
+
+	return s.withTransaction(ctx, func(tx *sql.Tx) error {
+		return createInvitations(ctx, tx, invitations[0].OrgId, invitations)
+	})
+}
+
+// ImportMembersToOrg adds the members and creates the invitations of an import
+// in one transaction, a quota exceeded by either leaves nothing written.
+func (s *sqlStorage) ImportMembersToOrg(ctx context.Context, orgId string, members []types.OrgMember, invitations []types.Invitation, version int) error {
+	return s.withTransaction(ctx, func(tx *sql.Tx) error {
+		if len(members) > 0 {
+			err := inviteUsersToOrg(ctx, tx, orgId, members, version)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(invitations) > 0 {
+			return createInvitations(ctx, tx, orgId, invitations)
+		}
+
+		return nil
+	})
+}
+
+func (s *sqlStorage) ReadOrgInvitations(ctx context.Context, orgId string) ([]types.Invitation, error) {
+	return s.findInvitations(ctx, `WHERE organization_id = $1`, orgId)
+}
+
+func (s *sqlStorage) ReadInvitations(ctx context.Context, email string) ([]types.Invitation, error) {
+	return s.findInvitations(ctx, `WHERE email = $1`, email)
+}
+
+func (s *sqlStorage) IsInvitedToOrg(ctx context.Context, orgId, email string) bool {
+	return s.exists(ctx, `SELECT EXISTS (
+		SELECT 1 FROM invitations WHERE organization_id = $1 AND email = $2
+	)`, orgId, email)
+}
+
+func (s *sqlStorage) DeleteInvitation(ctx context.Context, orgId, email string) error {
+	return s.withTransaction(ctx, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx,
+			`DELETE FROM invitations WHERE organization_id = $1 AND email = $2`, orgId, email)
+		if err != nil {
+			return err
+		}
+
+		deleted, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		return releaseOrgUsage(ctx, tx, orgId, "pending_invites", int(deleted))
+	})
+}
+
+func (s *sqlStorage) CreateInviteLink(ctx context.Context, link types.InviteLink) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO invite_links
+		(code, organization_id, access_level, max_uses, uses, expires_at) VALUES ($1, $2, $3, $4, $5, $6)`,
+		link.Code, link.OrgId, link.AccessLevel, link.MaxUses, link.Uses, link.ExpiresAt.UTC())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *sqlStorage) ReadInviteLinks(ctx context.Context, orgId string) ([]types.InviteLink, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT code, organization_id, access_level, max_uses, uses, expires_at
+		FROM invite_links WHERE organization_id = $1 ORDER BY code`, orgId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []types.InviteLink
+	for rows.Next() {
+		link, err := scanInviteLink(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		links = append(links, link)
+	}
+
+	return links, rows.Err()
+}
+
+func (s *sqlStorage) DeleteInviteLink(ctx context.Context, orgId, code string) error {
+	result, err := s.db.ExecContext(ctx,
+		`DELETE FROM invite_links WHERE organization_id = $1 AND code = $2`, orgId, code)
+	if err != nil {
+		return err
+	}
+
+	if deleted, err := affected(result); err != nil || !deleted {
+		return orError(err, errors.New("invite link doesn't exists"))
+	}
+
+	return nil
+}
+
+// RedeemInviteLink consumes one use of the link in a single conditional update,
+// so concurrent redemptions can never exceed the link's maximum number of uses.
+func (s *sqlStorage) RedeemInviteLink(ctx context.Context, code string) (types.InviteLink, error) {
+	row := s.db.QueryRowContext(ctx, `UPDATE invite_links SET uses = uses + 1
+		WHERE code = $1 AND expires_at > $2 AND uses < max_uses
+		RETURNING code, organization_id, access_level, max_uses, uses, expires_at`, code, time.Now().UTC())
+
+	link, err := scanInviteLink(row)
+	if err == sql.ErrNoRows {
+		return types.InviteLink{}, errors.New("invite link is invalid, expired or used up")
+	}
+	if err != nil {
+		return types.InviteLink{}, err
+	}
+
+	return link, nil
+}
+
+func (s *sqlStorage) ReleaseInviteLink(ctx context.Context, code string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE invite_links SET uses = uses - 1 WHERE code = $1 AND uses > 0`, code)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *sqlStorage) CreateTeam(ctx context.Context, team types.Team) (string, error) {
+	id := primitive.NewObjectID().Hex()
+
+	err := s.withTransaction(ctx, func(tx *sql.Tx) error {
+		err := reserveOrgUsage(ctx, tx, team.OrgId, "teams_count", "max_teams", 1)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `INSERT INTO teams (id, organization_id, name, description)
+			VALUES ($1, $2, $3, $4)`, id, team.OrgId, team.Name, team.Description)
+		if err != nil {
+			return err
+		}
+
+		rows := make([][]interface{}, len(team.Members))
+		for i, member := range team.Members {
+			rows[i] = []interface{}{id, member.Email, member.Name, member.Role}
+		}
+
+		return insertRows(ctx, tx, "team_members", []string{"team_id", "email", "name", "role"}, rows)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+func (s *sqlStorage) ReadTeam(ctx context.Context, orgId, teamId string) (types.Team, error) {
+	teams, err := s.findTeams(ctx, `WHERE id = $1 AND organization_id = $2`, teamId, orgId)
+	if err != nil {
+		return types.Team{}, err
+	}
+
+	if len(teams) == 0 {
+		return types.Team{}, errors.New("team doesn't exists")
+	}
+
+	return teams[0], nil
+}
+
+func (s *sqlStorage) ReadOrgsTeams(ctx context.Context, orgIds []string) ([]types.Team, error) {
+	if len(orgIds) == 0 {
+		return nil, nil
+	}
+
+	return s.findTeams(ctx, `WHERE organization_id IN (`+placeholders(1, len(orgIds))+`)`, stringArgs(orgIds)...)
+}
+
+func (s *sqlStorage) UpdateTeam(ctx context.Context, team types.Team) error {
+	result, err := s.db.ExecContext(ctx, `UPDATE teams SET name = $3, description = $4
+		WHERE id = $1 AND organization_id = $2`, team.TeamId, team.OrgId, team.Name, team.Description)
+	if err != nil {
+		return err
+	}
+
+	if updated, err := affected(result); err != nil || !updated {
+		return orError(err, errors.New("team doesn't exists"))
+	}
+
+	return nil
+}
+
+func (s *sqlStorage) DeleteTeam(ctx context.Context, orgId, teamId string) error {
+	return s.withTransaction(ctx, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, `DELETE FROM teams WHERE id = $1 AND organization_id = $2`, teamId, orgId)
+		if err != nil {
+			return err
+		}
+
+		if deleted, err := affected(result); err != nil || !deleted {
+			return orError(err, errors.New("team doesn't exists"))
+		}
+
+		return releaseOrgUsage(ctx, tx, orgId, "teams_count", 1)
+	})
+}
+
+func (s *sqlStorage) AddTeamMember(ctx context.Context, orgId, teamId string, member types.TeamMember) error {
+	result, err := s.db.ExecContext(ctx, `INSERT INTO team_members (team_id, email, name, role)
+		SELECT id, $3, $4, $5 FROM teams WHERE id = $1 AND organization_id = $2
+		ON CONFLICT (team_id, email) DO NOTHING`,
+		teamId, orgId, member.Email, member.Name, member.Role)
+	if err != nil {
+		return err
+	}
+
+	if added, err := affected(result); err != nil || !added {
+		return orError(err, errors.New("user already exists in this team"))
+	}
+
+	return nil
+}
+
+func (s *sqlStorage) RemoveTeamMember(ctx context.Context, orgId, teamId, email string) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM team_members WHERE email = $3
+		AND team_id IN (SELECT id FROM teams WHERE id = $1 AND organization_id = $2)`,
+		teamId, orgId, email)
+	if err != nil {
+		return err
+	}
+
+	if removed, err := affected(result); err != nil || !removed {
+		return orError(err, errors.New("user is not a team member"))
+	}
+
+	return nil
+}
+
+func (s *sqlStorage) CreateProject(ctx context.Context, project types.Project) (string, error) {
+	id := primitive.NewObjectID().Hex()
+
+	err := s.withTransaction(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `INSERT INTO projects (id, organization_id, name, description)
+			VALUES ($1, $2, $3, $4)`, id, project.OrgId, project.Name, project.Description)
+		if err != nil {
+			return err
+		}
+
+		rows := make([][]interface{}, len(project.Members))
+		for i, member := range project.Members {
+			rows[i] = []interface{}{id, member.Email, member.Name, member.AccessLevel}
+		}
+
+		return insertRows(ctx, tx, "project_members", []string{"project_id", "email", "name", "access_level"}, rows)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+func (s *sqlStorage) ReadProject(ctx context.Context, orgId, projectId string) (types.Project, error) {
+	projects, err := s.findProjects(ctx, `WHERE id = $1 AND organization_id = $2`, projectId, orgId)
+	if err != nil {
+		return types.Project{}, err
+	}
+
+	if len(projects) == 0 {
+		return types.Project{}, errors.New("project doesn't exists")
+	}
+
+	return projects[0], nil
+}
+
+func (s *sqlStorage) ReadOrgProjects(ctx context.Context, orgId string) ([]types.Project, error) {
+	return s.findProjects(ctx, `WHERE organization_id = $1`, orgId)
+}
+
+func (s *sqlStorage) UpdateProject(ctx context.Context, project types.Project) error {
+	result, err := s.db.ExecContext(ctx, `UPDATE projects SET name = $3, description = $4
+		WHERE id = $1 AND organization_id = $2`, project.ProjectId, project.OrgId, project.Name, project.Description)
+	if err != nil {
+		return err
+	}
+
+	if updated, err := affected(result); err != nil || !updated {
+		return orError(err, errors.New("project doesn't exists"))
+	}
+
+	return nil
+}
+
+func (s *sqlStorage) DeleteProject(ctx context.Context, orgId, projectId string) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM projects WHERE id = $1 AND organization_id = $2`, projectId, orgId)
+	if err != nil {
+		return err
+	}
+
+	if deleted, err := affected(result); err != nil || !deleted {
+		return orError(err, errors.New("project doesn't exists"))
+	}
+
+	return nil
+}
+
+// SetProjectMember adds or replaces the access level override of a member,
+// the replaced override is deleted in the same transaction so the member is
+// never listed twice.
+func (s *sqlStorage) SetProjectMember(ctx context.Context, orgId, projectId string, member types.ProjectMember) error {
+	return s.withTransaction(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `DELETE FROM project_members WHERE email = $3
+			AND project_id IN (SELECT id FROM projects WHERE id = $1 AND organization_id = $2)`,
+			projectId, orgId, member.Email)
+		if err != nil {
+			return err
+		}
+
+		result, err := tx.ExecContext(ctx, `INSERT INTO project_members (project_id, email, name, access_level)
+			SELECT id, $3, $4, $5 FROM projects WHERE id = $1 AND organization_id = $2`,
+			projectId, orgId, member.Email, member.Name, member.AccessLevel)
+		if err != nil {
+			return err
+		}
+
+		if added, err := affected(result); err != nil || !added {
+			return orError(err, errors.New("project doesn't exists"))
+		}
+
+		return nil
+	})
+}
+
+func (s *sqlStorage) RemoveProjectMember(ctx context.Context, orgId, projectId, email string) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM project_members WHERE email = $3
+		AND project_id IN (SELECT id FROM projects WHERE id = $1 AND organization_id = $2)`,
+		projectId, orgId, email)
+	if err != nil {
+		return err
+	}
+
+	if removed, err := affected(result); err != nil || !removed {
+		return orError(err, errors.New("user has no override in this project"))
+	}
+
+	return nil
+}
+
+func (s *sqlStorage) CreateJoinRequest(ctx context.Context, joinRequest types.JoinRequest) (string, error) {
+	id := primitive.NewObjectID().Hex()
+
+	_, err := s.db.ExecContext(ctx, `INSERT INTO join_requests
+		(id, organization_id, email, name, status, created_at) VALUES ($1, $2, $3, $4, $5, $6)`,
+		id, joinRequest.OrgId, joinRequest.Email, joinRequest.Name, joinRequest.Status, joinRequest.CreatedAt.UTC())
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+func (s *sqlStorage) ReadPendingJoinRequests(ctx context.Context, orgId string) ([]types.JoinRequest, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, organization_id, email, name, status, created_at
+		FROM join_requests WHERE organization_id = $1 AND status = $2 ORDER BY id`,
+		orgId, types.JOIN_REQUEST_STATUS_PENDING)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var joinRequests []types.JoinRequest
+	for rows.Next() {
+		joinRequest, err := scanJoinRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		joinRequests = append(joinRequests, joinRequest)
+	}
+
+	return joinRequests, rows.Err()
+}
+
+func (s *sqlStorage) HasPendingJoinRequest(ctx context.Context, orgId, email string) bool {
+	return s.exists(ctx, `SELECT EXISTS (
+		SELECT 1 FROM join_requests WHERE organization_id = $1 AND email = $2 AND status = $3
+	)`, orgId, email, types.JOIN_REQUEST_STATUS_PENDING)
+}
+
+// SetJoinRequestStatus moves a join request from the expected status to the new
+// one in a single conditional update, so a request is resolved at most once.
+func (s *sqlStorage) SetJoinRequestStatus(ctx context.Context, orgId, requestId, from, to string) (types.JoinRequest, error) {
+	row := s.db.QueryRowContext(ctx, `UPDATE join_requests SET status = $4
+		WHERE id = $1 AND organization_id = $2 AND status = $3
+		RETURNING id, organization_id, email, name, status, created_at`, requestId, orgId, from, to)
+
+	joinRequest, err := scanJoinRequest(row)
+	if err == sql.ErrNoRows {
+		return types.JoinRequest{}, errors.New("join request doesn't exists or is already resolved")
+	}
+	if err != nil {
+		return types.JoinRequest{}, err
+	}
+
+	return joinRequest, nil
+}
+
+// ====================== helper private function ====================== //
+
+// withTransaction runs fn in a transaction that is committed when fn succeeds
+// and rolled back otherwise.
+func (s *sqlStorage) withTransaction(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	err = fn(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqlStorage) exists(ctx context.Context, query string, args ...interface{}) bool {
+	var exists bool
+	err := s.db.QueryRowContext(ctx, query, args...).Scan(&exists)
+
+	return err == nil && exists
+}
+
+// availableSlug returns the base slug, or its first numbered variant that is
+// neither the current nor a previous slug of another organization than orgId.
+func (s *sqlStorage) availableSlug(ctx context.Context, baseSlug, orgId string) (string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT slug FROM organization_slugs
+		WHERE (slug = $1 OR slug LIKE $2 ESCAPE '\') AND organization_id <> $3`,
+		baseSlug, escapeLike(baseSlug)+"-%", orgId)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	pattern := slugRegex(baseSlug)
+	taken := make(map[string]bool)
+	for rows.Next() {
+		var slug string
+		err := rows.Scan(&slug)
+		if err != nil {
+			return "", err
+		}
+
+		if pattern.MatchString(slug) {
+			taken[slug] = true
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	slug := baseSlug
+	for n := 2; taken[slug]; n++ {
+		slug = baseSlug + "-" + strconv.Itoa(n)
+	}
+
+	return slug, nil
+}
+
+func (s *sqlStorage) findInvitations(ctx context.Context, clause string, args ...interface{}) ([]types.Invitation, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT organization_id, email, access_level
+		FROM invitations `+clause+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invitations []types.Invitation
+	for rows.Next() {
+		var invitation types.Invitation
+		err := rows.Scan(&invitation.OrgId, &invitation.Email, &invitation.AccessLevel)
+		if err != nil {
+			return nil, err
+		}
+
+		invitations = append(invitations, invitation)
+	}
+
+	return invitations, rows.Err()
+}
+
+// findTeams reads the teams matching the clause and then all their members
+// with a single query, in the order they were added.
+func (s *sqlStorage) findTeams(ctx context.Context, clause string, args ...interface{}) ([]types.Team, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, organization_id, name, description
+		FROM teams `+clause+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var teams []types.Team
+	var teamIds []string
+	for rows.Next() {
+		team := types.Team{Members: []types.TeamMember{}}
+		err := rows.Scan(&team.TeamId, &team.OrgId, &team.Name, &team.Description)
+		if err != nil {
+			return nil, err
+		}
+
+		teams = append(teams, team)
+		teamIds = append(teamIds, team.TeamId)
+	}
+
+	if err := rows.Err(); err != nil || len(teams) == 0 {
+		return teams, err
+	}
+
+	memberRows, err := s.db.QueryContext(ctx, `SELECT team_id, email, name, role FROM team_members
+		WHERE team_id IN (`+placeholders(1, len(teamIds))+`) ORDER BY id`, stringArgs(teamIds)...)
+	if err != nil {
+		return nil, err
+	}
+	defer memberRows.Close()
+
+	members := make(map[string][]types.TeamMember, len(teams))
+	for memberRows.Next() {
+		var teamId string
+		var member types.TeamMember
+		err := memberRows.Scan(&teamId, &member.Email, &member.Name, &member.Role)
+		if err != nil {
+			return nil, err
+		}
+
+		members[teamId] = append(members[teamId], member)
+	}
+
+	for i := range teams {
+		teams[i].Members = append(teams[i].Members, members[teams[i].TeamId]...)
+	}
+
+	return teams, memberRows.Err()
+}
+
+// findProjects reads the projects matching the clause and then all their
+// member overrides with a single query.
+func (s *sqlStorage) findProjects(ctx context.Context, clause string, args ...interface{}) ([]types.Project, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, organization_id, name, description
+		FROM projects `+clause+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var projects []types.Project
+	var projectIds []string
+	for rows.Next() {
+		project := types.Project{Members: []types.ProjectMember{}}
+		err := rows.Scan(&project.ProjectId, &project.OrgId, &project.Name, &project.Description)
+		if err != nil {
+			return nil, err
+		}
+
+		projects = append(projects, project)
+		projectIds = append(projectIds, project.ProjectId)
+	}
+
+	if err := rows.Err(); err != nil || len(projects) == 0 {
+		return projects, err
+	}
+
+	memberRows, err := s.db.QueryContext(ctx, `SELECT project_id, email, name, access_level FROM project_members
+		WHERE project_id IN (`+placeholders(1, len(projectIds))+`) ORDER BY id`, stringArgs(projectIds)...)
+	if err != nil {
+		return nil, err
+	}
+	defer memberRows.Close()
+
+	members := make(map[string][]types.ProjectMember, len(projects))
+	for memberRows.Next() {
+		var projectId string
+		var member types.ProjectMember
+		err := memberRows.Scan(&projectId, &member.Email, &member.Name, &member.AccessLevel)
+		if err != nil {
+			return nil, err
+		}
+
+		members[projectId] = append(members[projectId], member)
+	}
+
+	for i := range projects {
+		projects[i].Members = append(projects[i].Members, members[projects[i].ProjectId]...)
+	}
+
+	return projects, memberRows.Err()
+}
+
+func findOrg(ctx context.Context, q sqlQuerier, clause string, args ...interface{}) (types.Org, error) {
+	orgs, err := findOrgs(ctx, q, clause, args...)
+	if err != nil {
+		return types.Org{}, err
+	}
+
+	if len(orgs) == 0 {
+		return types.Org{}, ErrOrgNotFound
+	}
+
+	return orgs[0], nil
+}
+
+// findOrgs reads the organizations matching the clause, which holds everything
+// after the FROM, and then their slugs, tags, domains and members with a single
+// query each.
+func findOrgs(ctx context.Context, q sqlQuerier, clause string, args ...interface{}) ([]types.Org, error) {
+	rows, err := q.QueryContext(ctx, `SELECT `+orgColumns+` FROM organizations `+clause, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orgs []types.Org
+	for rows.Next() {
+		org, err := scanOrg(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		orgs = append(orgs, org)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = attachOrgDetails(ctx, q, orgs)
+	if err != nil {
+		return nil, err
+	}
+
+	return orgs, nil
+}
+
+func attachOrgDetails(ctx context.Context, q sqlQuerier, orgs []types.Org) error {
+	if len(orgs) == 0 {
+		return nil
+	}
+
+	index := make(map[string]*types.Org, len(orgs))
+	orgIds := make([]interface{}, len(orgs))
+	for i := range orgs {
+		index[orgs[i].OrgId] = &orgs[i]
+		orgIds[i] = orgs[i].OrgId
+	}
+	in := placeholders(1, len(orgIds))
+
+	// the current slug is one of the slugs too, the others are its history
+	err := scanEach(ctx, q, `SELECT organization_id, slug FROM organization_slugs
+		WHERE organization_id IN (`+in+`) ORDER BY id`, orgIds, func(rows *sql.Rows) error {
+		var orgId, slug string
+		err := rows.Scan(&orgId, &slug)
+		if org := index[orgId]; err == nil && slug != org.Slug {
+			org.SlugHistory = append(org.SlugHistory, slug)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	err = scanEach(ctx, q, `SELECT organization_id, tag FROM organization_tags
+		WHERE organization_id IN (`+in+`) ORDER BY id`, orgIds, func(rows *sql.Rows) error {
+		var orgId, tag string
+		err := rows.Scan(&orgId, &tag)
+		if err == nil {
+			index[orgId].Tags = append(index[orgId].Tags, tag)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return scanEach(ctx, q, `SELECT organization_id, domain, token, verified, access_level FROM organization_domains
+		WHERE organization_id IN (`+in+`) ORDER BY id`, orgIds, func(rows *sql.Rows) error {
+		var orgId string
+		var domain types.OrgDomain
+		err := rows.Scan(&orgId, &domain.Domain, &domain.Token, &domain.Verified, &domain.AccessLevel)
+		if err == nil {
+			index[orgId].Domains = append(index[orgId].Domains, domain)
+		}
+		return err
+	})
+}
+
+func scanEach(ctx context.Context, q sqlQuerier, query string, args []interface{}, scan func(rows *sql.Rows) error) error {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		err := scan(rows)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// sqlRow is either a *sql.Row or the current row of a *sql.Rows.
+type sqlRow interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanOrg(row sqlRow) (types.Org, error) {
+	var org types.Org
+	var parentId sql.NullString
+	var metadataFields, metadata, logo []byte
+	var archivedAt sql.NullTime
+
+	err := row.Scan(&org.OrgId, &org.Slug, &parentId, &org.Name, &org.Description, &org.Version,
+		&org.Timezone, &org.Locale, &org.LogoURL, &org.DefaultMemberRole, &org.Visibility,
+		&metadataFields, &metadata, &logo,
+		&org.Quota.MaxMembers, &org.Quota.MaxPendingInvites, &org.Quota.MaxTeams,
+		&org.MembersCount, &org.PendingInvites, &org.TeamsCount, &archivedAt)
+	if err != nil {
+		return types.Org{}, err
+	}
+
+	org.ParentId = parentId.String
+	if archivedAt.Valid {
+		org.ArchivedAt = &archivedAt.Time
+	}
+
+	err = decodeDocument(metadataFields, &org.MetadataFields)
+	if err != nil {
+		return types.Org{}, err
+	}
+
+	err = decodeDocument(metadata, &org.Metadata)
+	if err != nil {
+		return types.Org{}, err
+	}
+
+	err = decodeDocument(logo, &org.Logo)
+	if err != nil {
+		return types.Org{}, err
+	}
+
+	return org, nil
+}
+
+func scanInviteLink(row sqlRow) (types.InviteLink, error) {
+	var link types.InviteLink
+	err := row.Scan(&link.Code, &link.OrgId, &link.AccessLevel, &link.MaxUses, &link.Uses, &link.ExpiresAt)
+
+	return link, err
+}
+
+func scanJoinRequest(row sqlRow) (types.JoinRequest, error) {
+	var joinRequest types.JoinRequest
+	err := row.Scan(&joinRequest.RequestId, &joinRequest.OrgId, &joinRequest.Email, &joinRequest.Name,
+		&joinRequest.Status, &joinRequest.CreatedAt)
+
+	return joinRequest, err
+}
+
+// orgDocuments encodes the fields of the organization stored as JSON
+// documents, a nil field is stored as NULL.
+func orgDocuments(org types.Org) (interface{}, interface{}, interface{}, error) {
+	var metadataFields, metadata, logo interface{}
+	var err error
+
+	if org.MetadataFields != nil {
+		metadataFields, err = encodeDocument(org.MetadataFields)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	if org.Metadata != nil {
+		metadata, err = encodeDocument(org.Metadata)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	if org.Logo != nil {
+		logo, err = encodeDocument(org.Logo)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	return metadataFields, metadata, logo, nil
+}
+
+// encodeDocument returns the document as text, the driver would send bytes
+// as bytea.
+func encodeDocument(value interface{}) (string, error) {
+	document, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	return string(document), nil
+}
+
+func decodeDocument(document []byte, value interface{}) error {
+	if document == nil {
+		return nil
+	}
+
+	return json.Unmarshal(document, value)
+}
+
+func addOrgSlug(ctx context.Context, q sqlQuerier, orgId, slug string) error {
+	if slug == "" {
+		return nil
+	}
+
+	_, err := q.ExecContext(ctx, `INSERT INTO organization_slugs (slug, organization_id) VALUES ($1, $2)`, slug, orgId)
+
+	return err
+}
+
+// updateOrg sets the columns of the organization, archived or not, and
+// increments its version. The arguments of set start at $2, $1 is the ID.
+func updateOrg(ctx context.Context, q sqlQuerier, orgId, set string, args ...interface{}) error {
+	result, err := q.ExecContext(ctx,
+		`UPDATE organizations SET `+set+`, version = version + 1 WHERE id = $1`,
+		append([]interface{}{orgId}, args...)...)
+	if err != nil {
+		return err
+	}
+
+	if updated, err := affected(result); err != nil || !updated {
+		return orError(err, ErrOrgNotFound)
+	}
+
+	return nil
+}
+
+// bumpOrgVersion increments the version of the organization. A non-zero
+// version must be the current one, ErrOrgModified is returned otherwise.
+func bumpOrgVersion(ctx context.Context, q sqlQuerier, orgId string, version int) error {
+	result, err := q.ExecContext(ctx, `UPDATE organizations SET version = version + 1
+		WHERE id = $1 AND ($2 = 0 OR version = $2)`, orgId, version)
+	if err != nil {
+		return err
+	}
+
+	if updated, err := affected(result); err != nil || !updated {
+		if version != 0 {
+			return orError(err, ErrOrgModified)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func inviteUsersToOrg(ctx context.Context, q sqlQuerier, orgId string, members []types.OrgMember, version int) error {
+	err := bumpOrgVersion(ctx, q, orgId, version)
+	if err != nil {
+		return err
+	}
+
+	err = reserveOrgUsage(ctx, q, orgId, "members_count", "max_members", len(members))
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]interface{}, len(members))
+	for i, member := range members {
+		rows[i] = []interface{}{orgId, member.Email, member.AccessLevel}
+	}
+
+	err = insertRows(ctx, q, "memberships", []string{"organization_id", "email", "access_level"}, rows)
+	if isUniqueViolation(err) {
+		return errors.New("user already exists in this organization")
+	}
+
+	return err
+}
+
+func createInvitations(ctx context.Context, q sqlQuerier, orgId string, invitations []types.Invitation) error {
+	err := checkInvitationsOrg(orgId, invitations)
+	if err != nil {
+		return err
+	}
+
+	err = reserveOrgUsage(ctx, q, orgId, "pending_invites", "max_pending_invites", len(invitations))
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]interface{}, len(invitations))
+	for i, invitation := range invitations {
+		rows[i] = []interface{}{invitation.OrgId, invitation.Email, invitation.AccessLevel}
+	}
+
+	return insertRows(ctx, q, "invitations", []string{"organization_id", "email", "access_level"}, rows)
+}
+
+// reserveOrgUsage increments a usage counter of the organization only if the
+// result stays within its quota, in a single conditional update. Counters
+// aren't part of the version, they change with every invitation and team.
+func reserveOrgUsage(ctx context.Context, q sqlQuerier, orgId, counter, limit string, n int) error {
+	result, err := q.ExecContext(ctx, `UPDATE organizations
+		SET `+counter+` = `+counter+` + $2
+		WHERE id = $1 AND archived_at IS NULL
+			AND (`+limit+` <= 0 OR `+counter+` + $2 <= `+limit+`)`, orgId, n)
+	if err != nil {
+		return err
+	}
+
+	if reserved, err := affected(result); err != nil || reserved {
+		return err
+	}
+
+	org, err := findOrg(ctx, q, `WHERE id = $1 AND archived_at IS NULL`, orgId)
+	if err != nil {
+		return err
+	}
+
+	return quotaError(org.Quota, limit)
+}
+
+func releaseOrgUsage(ctx context.Context, q sqlQuerier, orgId, counter string, n int) error {
+	if n == 0 {
+		return nil
+	}
+
+	_, err := q.ExecContext(ctx, `UPDATE organizations
+		SET `+counter+` = `+counter+` - $2
+		WHERE id = $1 AND `+counter+` >= $2`, orgId, n)
+
+	return err
+}
+
+// insertRows inserts the rows with as few multi-row statements as possible.
+func insertRows(ctx context.Context, q sqlQuerier, table string, columns []string, rows [][]interface{}) error {
+	for start := 0; start < len(rows); start += MAX_INSERT_ROWS {
+		end := start + MAX_INSERT_ROWS
+		if end > len(rows) {
+			end = len(rows)
+		}
+
+		values := make([]string, 0, end-start)
+		args := make([]interface{}, 0, (end-start)*len(columns))
+		for _, row := range rows[start:end] {
+			values = append(values, "("+placeholders(len(args)+1, len(row))+")")
+			args = append(args, row...)
+		}
+
+		_, err := q.ExecContext(ctx, `INSERT INTO `+table+` (`+strings.Join(columns, ", ")+`)
+			VALUES `+strings.Join(values, ", "), args...)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// placeholders returns n comma separated placeholders numbered from first.
+func placeholders(first, n int) string {
+	numbered := make([]string, n)
+	for i := range numbered {
+		numbered[i] = "$" + strconv.Itoa(first+i)
+	}
+
+	return strings.Join(numbered, ", ")
+}
+
+func stringArgs(values []string) []interface{} {
+	args := make([]interface{}, len(values))
+	for i, value := range values {
+		args[i] = value
+	}
+
+	return args
+}
+
+func nullTime(value *time.Time) interface{} {
+	if value == nil {
+		return nil
+	}
+
+	return value.UTC()
+}
+
+func nullString(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
+
+// escapeLike escapes the LIKE wildcards of a value matched literally.
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+// affected reports whether the statement changed at least one row.
+func affected(result sql.Result) (bool, error) {
+	n, err := result.RowsAffected()
+
+	return n > 0, err
+}
+
+// orError returns err when a statement failed and notFound when it didn't
+// change anything.
+func orError(err, notFound error) error {
+	if err != nil {
+		return err
+	}
+
+	return notFound
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE ||
+			sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY
+	}
+
+	return false
+}
+
+func isForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23503"
+	}
+
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY
+	}
+
+	return false
+}
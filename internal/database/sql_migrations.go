@@ -0,0 +1,266 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// sqlMigrationFileRegex matches the file names of the SQL migrations.
+var sqlMigrationFileRegex = regexp.MustCompile(`^([0-9]+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// sqlMigration is a migration made of SQL statements, each dialect has its own
+// <version>_<name>.up.sql and .down.sql files applied in version order like the
+// Mongo migrations. Each direction runs in a single transaction together with
+// the record of the migration.
+type sqlMigration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+func (s *sqlStorage) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	err := createSQLMigrationTable(ctx, s.db, s.dialect.timestampType)
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := appliedSQLMigrations(ctx, s.db)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, len(s.dialect.migrations))
+	for i, migration := range s.dialect.migrations {
+		statuses[i] = MigrationStatus{
+			Version: migration.Version,
+			Name:    migration.Name,
+		}
+
+		if appliedAt, ok := applied[migration.Version]; ok {
+			statuses[i].AppliedAt = &appliedAt
+		}
+	}
+
+	return statuses, nil
+}
+
+// MigrateUp applies every pending migration.
+func (s *sqlStorage) MigrateUp(ctx context.Context) error {
+	return s.MigrateTo(ctx, s.dialect.migrations[len(s.dialect.migrations)-1].Version)
+}
+
+// MigrateDown reverts the last applied migration.
+func (s *sqlStorage) MigrateDown(ctx context.Context) error {
+	return s.migrate(ctx, func(current int) int {
+		previous := 0
+		for _, migration := range s.dialect.migrations {
+			if migration.Version < current {
+				previous = migration.Version
+			}
+		}
+
+		return previous
+	})
+}
+
+// MigrateTo applies the pending migrations up to the version and reverts the
+// applied ones above it, version 0 reverts every migration.
+func (s *sqlStorage) MigrateTo(ctx context.Context, version int) error {
+	return s.migrate(ctx, func(int) int {
+		return version
+	})
+}
+
+// ====================== helper private function ====================== //
+
+// migrate moves the schema to the version target returns for the current
+// version, on a single connection holding the migration lock.
+func (s *sqlStorage) migrate(ctx context.Context, target func(current int) int) error {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	unlock, err := s.dialect.lockMigrations(ctx, conn)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	err = createSQLMigrationTable(ctx, conn, s.dialect.timestampType)
+	if err != nil {
+		return err
+	}
+
+	applied, err := appliedSQLMigrations(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	current := 0
+	for version := range applied {
+		if version > current {
+			current = version
+		}
+	}
+
+	version := target(current)
+	if version != 0 && !knownSQLMigration(s.dialect.migrations, version) {
+		return fmt.Errorf("unknown migration version %d", version)
+	}
+
+	for _, migration := range s.dialect.migrations {
+		if migration.Version > version {
+			break
+		}
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+
+		err = runSQLMigration(ctx, conn, migration.Up,
+			`INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`,
+			migration.Version, migration.Name, time.Now().UTC())
+		if err != nil {
+			return fmt.Errorf("applying migration %d %s: %w", migration.Version, migration.Name, err)
+		}
+	}
+
+	for i := len(s.dialect.migrations) - 1; i >= 0 && s.dialect.migrations[i].Version > version; i-- {
+		migration := s.dialect.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+
+		err = runSQLMigration(ctx, conn, migration.Down,
+			`DELETE FROM schema_migrations WHERE version = $1`, migration.Version)
+		if err != nil {
+			return fmt.Errorf("reverting migration %d %s: %w", migration.Version, migration.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func createSQLMigrationTable(ctx context.Context, q sqlQuerier, timestampType string) error {
+	_, err := q.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		name       TEXT NOT NULL,
+		applied_at `+timestampType+` NOT NULL
+	)`)
+
+	return err
+}
+
+func appliedSQLMigrations(ctx context.Context, q sqlQuerier) (map[int]time.Time, error) {
+	rows, err := q.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		err := rows.Scan(&version, &appliedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		applied[version] = appliedAt
+	}
+
+	return applied, rows.Err()
+}
+
+// runSQLMigration runs the statements of a migration and the statement that
+// records it in one transaction, a failed migration leaves nothing behind.
+func runSQLMigration(ctx context.Context, conn *sql.Conn, statements, record string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, statements)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, record, args...)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func knownSQLMigration(migrations []sqlMigration, version int) bool {
+	for _, migration := range migrations {
+		if migration.Version == version {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mustLoadSQLMigrations reads the migrations of the directory, the files are
+// embedded in the binary so a malformed one is a programming error.
+func mustLoadSQLMigrations(files fs.FS, dir string) []sqlMigration {
+	entries, err := fs.ReadDir(files, dir)
+	if err != nil {
+		panic(err)
+	}
+
+	byVersion := make(map[int]*sqlMigration)
+	for _, entry := range entries {
+		match := sqlMigrationFileRegex.FindStringSubmatch(entry.Name())
+		if match == nil {
+			panic(fmt.Sprintf("unexpected migration file %s", entry.Name()))
+		}
+
+		content, err := fs.ReadFile(files, path.Join(dir, entry.Name()))
+		if err != nil {
+			panic(err)
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &sqlMigration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]sqlMigration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			panic(fmt.Sprintf("migration %d %s needs both an up and a down file", migration.Version, migration.Name))
+		}
+
+		migrations = append(migrations, *migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations
+}
@@ -0,0 +1,69 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"net/url"
+	"strconv"
+
+	_ "modernc.org/sqlite"
+)
+
+//go:embed sqlite/*.sql
+var sqliteMigrationFiles embed.FS
+
+var sqliteDialect = sqlDialect{
+	// transactions take the write lock of the database as they begin
+	lockRows:        ``,
+	binaryCollation: `COLLATE BINARY`,
+	timestampType:   `TIMESTAMP`,
+	migrations:      mustLoadSQLMigrations(sqliteMigrationFiles, "sqlite"),
+	lockMigrations:  lockSQLiteMigrations,
+}
+
+// SQLite is the embedded implementation of the repositories, everything is
+// kept in a single data file so the server runs without any external service.
+// The schema is created and changed by the SQL migrations under sqlite/.
+type SQLite struct {
+	*sqlStorage
+}
+
+// NewSQLite opens the SQLite data file at path, creating it when missing. A
+// query waits up to DATABASE_OPERATION_TIMEOUT for the write lock of the file.
+func NewSQLite(ctx context.Context, path string) (*SQLite, error) {
+	query := url.Values{}
+	query.Add("_pragma", "foreign_keys(1)")
+	query.Add("_pragma", "journal_mode(WAL)")
+	query.Add("_pragma", "busy_timeout("+strconv.FormatInt(operationTimeout.Milliseconds(), 10)+")")
+	query.Set("_txlock", "immediate")
+	query.Set("_time_format", "sqlite")
+
+	db, err := sql.Open("sqlite", "file:"+path+"?"+query.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.PingContext(ctx)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLite{&sqlStorage{db: db, dialect: sqliteDialect}}, nil
+}
+
+// DB returns the connections to the data file, the auth token store shares
+// them to keep the refresh tokens in the same file.
+func (s *SQLite) DB() *sql.DB {
+	return s.db
+}
+
+// ====================== helper private function ====================== //
+
+// lockSQLiteMigrations locks nothing, every migration runs in a transaction
+// holding the write lock of the file and a concurrent run applying the same
+// migration fails on its record.
+func lockSQLiteMigrations(ctx context.Context, conn *sql.Conn) (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,7 @@
+-- the refresh tokens of the auth package, the sqlite storage replaces Redis
+CREATE TABLE refresh_tokens (
+    token TEXT PRIMARY KEY,
+    email TEXT NOT NULL
+);
+
+CREATE INDEX refresh_tokens_email_idx ON refresh_tokens (email);
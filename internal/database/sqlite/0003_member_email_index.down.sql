@@ -0,0 +1 @@
+DROP INDEX memberships_organization_lower_email_idx;
@@ -0,0 +1,76 @@
+package domain
+
+import (
+	"errors"
+	"net"
+	"strings"
+)
+
+// TXT_RECORD_PREFIX prefixes the token an organization publishes in a TXT
+// record of the domain it claims.
+const TXT_RECORD_PREFIX = "ideanest-verification="
+
+// Resolver looks up the TXT records of a domain, it is implemented by the
+// system DNS resolver and by FakeResolver for tests and local development.
+type Resolver interface {
+	LookupTXT(domain string) ([]string, error)
+}
+
+type NetResolver struct{}
+
+func (NetResolver) LookupTXT(domain string) ([]string, error) {
+	return net.LookupTXT(domain)
+}
+
+// FakeResolver serves TXT records from memory instead of the DNS.
+type FakeResolver map[string][]string
+
+func (r FakeResolver) LookupTXT(domain string) ([]string, error) {
+	records, ok := r[domain]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: domain, IsNotFound: true}
+	}
+
+	return records, nil
+}
+
+func VerificationRecord(token string) string {
+	return TXT_RECORD_PREFIX + token
+}
+
+// Verify checks that the domain publishes the verification record of token.
+func Verify(resolver Resolver, domain, token string) error {
+	records, err := resolver.LookupTXT(domain)
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		if strings.TrimSpace(record) == VerificationRecord(token) {
+			return nil
+		}
+	}
+
+	return errors.New("verification record not found in the domain TXT records")
+}
+
+// Normalize lowercases the domain and strips a trailing dot, it returns an
+// empty string when the value is not a plausible domain name.
+func Normalize(domain string) string {
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	if !strings.Contains(domain, ".") || strings.ContainsAny(domain, "@/: ") {
+		return ""
+	}
+
+	return domain
+}
+
+// OfEmail returns the normalized domain part of an email address.
+func OfEmail(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return ""
+	}
+
+	return Normalize(email[at+1:])
+}
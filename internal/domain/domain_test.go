@@ -0,0 +1,31 @@
+package domain
+
+import "testing"
+
+func TestVerify(t *testing.T) {
+	resolver := FakeResolver{
+		"ourcompany.com": {"v=spf1 -all", VerificationRecord("token")},
+	}
+
+	if err := Verify(resolver, "ourcompany.com", "token"); err != nil {
+		t.Errorf("Expected domain to be verified but got %s", err)
+	}
+
+	if err := Verify(resolver, "ourcompany.com", "other-token"); err == nil {
+		t.Errorf("Expected verification with a wrong token to fail")
+	}
+
+	if err := Verify(resolver, "unknown.com", "token"); err == nil {
+		t.Errorf("Expected verification of an unknown domain to fail")
+	}
+}
+
+func TestOfEmail(t *testing.T) {
+	if got := OfEmail("zaher@OurCompany.com"); got != "ourcompany.com" {
+		t.Errorf("Expected domain %s but got %s", "ourcompany.com", got)
+	}
+
+	if got := OfEmail("not-an-email"); got != "" {
+		t.Errorf("Expected empty domain but got %s", got)
+	}
+}
@@ -0,0 +1,51 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 300, 200))
+	img.Set(150, 100, color.White)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := Decode(buf.Bytes())
+	if err != nil {
+		t.Fatalf("Expected png to be decoded but got %s", err)
+	}
+
+	if decoded.Bounds().Dx() != 300 || decoded.Bounds().Dy() != 200 {
+		t.Errorf("Expected 300x200 image but got %v", decoded.Bounds())
+	}
+
+	if _, err := Decode([]byte("<svg xmlns=\"http://www.w3.org/2000/svg\"/>")); err != ErrUnsupportedFormat {
+		t.Errorf("Expected %s but got %v", ErrUnsupportedFormat, err)
+	}
+
+	huge := image.NewGray(image.Rect(0, 0, MAX_DIMENSION+1, 1))
+	buf.Reset()
+	if err := png.Encode(&buf, huge); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Decode(buf.Bytes()); err == nil {
+		t.Errorf("Expected image larger than %d pixels to be rejected", MAX_DIMENSION)
+	}
+}
+
+func TestSquare(t *testing.T) {
+	img := image.NewRGBA(image.Rect(10, 10, 310, 210))
+
+	squared := Square(img, 64)
+	if squared.Bounds() != image.Rect(0, 0, 64, 64) {
+		t.Errorf("Expected 64x64 image but got %v", squared.Bounds())
+	}
+}
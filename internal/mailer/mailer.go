@@ -0,0 +1,114 @@
+package mailer
+
+import (
+	"errors"
+	"log"
+	"net/smtp"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Sender delivers plain text mails, it is implemented by SMTPSender, by
+// LogSender for local development and by Outbox for tests.
+type Sender interface {
+	Send(to, subject, body string) error
+}
+
+// SMTPSender sends the mails through an SMTP server.
+type SMTPSender struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// NewSMTPSender sends from the address through the server at host:port, it
+// authenticates only when a username is given.
+func NewSMTPSender(host, port, username, password, from string) *SMTPSender {
+	sender := &SMTPSender{
+		addr: host + ":" + port,
+		from: from,
+	}
+
+	if username != "" {
+		sender.auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return sender
+}
+
+func (s *SMTPSender) Send(to, subject, body string) error {
+	message, err := formatMessage(s.from, to, subject, body)
+	if err != nil {
+		return err
+	}
+
+	return smtp.SendMail(s.addr, s.auth, s.from, []string{to}, message)
+}
+
+// tokenRegex matches the tokens mailed to users, e.g. the email verification
+// JWTs, anything this long without a space or an @ is taken for one.
+var tokenRegex = regexp.MustCompile(`[A-Za-z0-9_\-.=+/]{32,}`)
+
+// LogSender writes the mails to the log instead of sending them, with their
+// tokens redacted since the log is read by more people than the recipient.
+type LogSender struct{}
+
+func (LogSender) Send(to, subject, body string) error {
+	log.Printf("mail to %s: %s\n%s", to, subject, tokenRegex.ReplaceAllString(body, "[redacted]"))
+
+	return nil
+}
+
+// Outbox keeps the sent mails in memory.
+type Outbox struct {
+	mu    sync.Mutex
+	Mails []Mail
+}
+
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+func (o *Outbox) Send(to, subject, body string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.Mails = append(o.Mails, Mail{To: to, Subject: subject, Body: body})
+
+	return nil
+}
+
+// Last returns the last mail sent to the address.
+func (o *Outbox) Last(to string) (Mail, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for i := len(o.Mails) - 1; i >= 0; i-- {
+		if o.Mails[i].To == to {
+			return o.Mails[i], true
+		}
+	}
+
+	return Mail{}, false
+}
+
+// ======================== helper util function ======================== //
+
+func formatMessage(from, to, subject, body string) ([]byte, error) {
+	// a line break in a header would let its value add headers of its own
+	if strings.ContainsAny(from+to+subject, "\r\n") {
+		return nil, errors.New("mail headers cannot contain line breaks")
+	}
+
+	message := "From: " + from + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" +
+		strings.ReplaceAll(body, "\n", "\r\n")
+
+	return []byte(message), nil
+}
@@ -1,11 +1,41 @@
 package types
 
+import "time"
+
 const (
 	ACCESS_LEVEL_ADMIN = "admin"
 	ACCESS_LEVEL_USER  = "user"
 
-	USER_COLL = "user"
-	ORG_COLL  = "organization"
+	USER_COLL         = "user"
+	ORG_COLL          = "organization"
+	INVITATION_COLL   = "invitation"
+	INVITE_LINK_COLL  = "invite_link"
+	TEAM_COLL         = "team"
+	JOIN_REQUEST_COLL = "join_request"
+	PROJECT_COLL      = "project"
+	MEMBERSHIP_COLL   = "membership"
+
+	MIGRATION_COLL      = "migration"
+	MIGRATION_LOCK_COLL = "migration_lock"
+
+	ORG_VISIBILITY_PRIVATE      = "private"
+	ORG_VISIBILITY_DISCOVERABLE = "discoverable"
+
+	JOIN_REQUEST_STATUS_PENDING  = "pending"
+	JOIN_REQUEST_STATUS_APPROVED = "approved"
+	JOIN_REQUEST_STATUS_REJECTED = "rejected"
+
+	METADATA_TYPE_STRING  = "string"
+	METADATA_TYPE_NUMBER  = "number"
+	METADATA_TYPE_BOOLEAN = "boolean"
+
+	TEAM_ROLE_LEAD   = "lead"
+	TEAM_ROLE_MEMBER = "member"
+
+	IMPORT_STATUS_ADDED          = "added"
+	IMPORT_STATUS_ALREADY_MEMBER = "already_member"
+	IMPORT_STATUS_INVITED        = "invited"
+	IMPORT_STATUS_INVALID        = "invalid"
 )
 
 type UserInfo struct {
@@ -15,8 +45,7 @@ type UserInfo struct {
 
 type User struct {
 	UserInfo `bson:",inline"`
-	Password string   `bson:"password"`
-	Orgs     []string `bson:"organizations"`
+	Password string `bson:"password"`
 }
 
 type OrgMember struct {
@@ -24,15 +53,150 @@ type OrgMember struct {
 	AccessLevel string `bson:"access_level"`
 }
 
+// Membership is the single stored record of a user being a member of an
+// organization, the member name is read from the user.
+type Membership struct {
+	OrgId       string `bson:"organization_id"`
+	Email       string `bson:"email"`
+	AccessLevel string `bson:"access_level"`
+	// NameLower is the lowercased name of the user, the key Mongo indexes to
+	// sort the member directory by name.
+	NameLower string `bson:"name_lower"`
+}
+
+// MemberQuery selects one page of the member directory of an organization,
+// After is the sort key and email of the last member of the previous page.
+// Emails, when given, restricts the page to these members.
+type MemberQuery struct {
+	Prefix     string
+	Role       string
+	Emails     []string
+	SortBy     string
+	Descending bool
+	Limit      int
+	After      *MemberCursor
+}
+
+type MemberCursor struct {
+	Key   string `json:"k"`
+	Email string `json:"e"`
+}
+
 type OrgInfo struct {
 	OrgId       string `bson:"-"`
+	Slug        string `bson:"slug,omitempty"`
+	ParentId    string `bson:"parent_id,omitempty"`
 	Name        string `bson:"name"`
 	Description string `bson:"description"`
+	// Version is incremented by every write to the organization or its
+	// memberships, it is the ETag of the organization.
+	Version int `bson:"version"`
+}
+
+type OrgDomain struct {
+	Domain      string `bson:"domain"`
+	Token       string `bson:"token"`
+	Verified    bool   `bson:"verified"`
+	AccessLevel string `bson:"access_level"`
+}
+
+// OrgQuota caps the usage of an organization, a zero limit means unlimited.
+type OrgQuota struct {
+	MaxMembers        int `bson:"max_members"`
+	MaxPendingInvites int `bson:"max_pending_invites"`
+	MaxTeams          int `bson:"max_teams"`
+}
+
+// OrgSettings is stored inline in the organization document.
+type OrgSettings struct {
+	Timezone          string `bson:"timezone,omitempty"`
+	Locale            string `bson:"locale,omitempty"`
+	LogoURL           string `bson:"logo_url,omitempty"`
+	DefaultMemberRole string `bson:"default_member_role,omitempty"`
+	Visibility        string `bson:"visibility,omitempty"`
+}
+
+type MetadataField struct {
+	Key  string `bson:"key"`
+	Type string `bson:"type"`
 }
 
 type Org struct {
-	OrgInfo    `bson:",inline"`
-	OrgMembers []OrgMember `bson:"organization_members"`
+	OrgInfo        `bson:",inline"`
+	OrgSettings    `bson:",inline"`
+	SlugHistory    []string               `bson:"slug_history,omitempty"`
+	MetadataFields []MetadataField        `bson:"metadata_fields,omitempty"`
+	Metadata       map[string]interface{} `bson:"metadata,omitempty"`
+	Logo           map[string]string      `bson:"logo,omitempty"`
+	Tags           []string               `bson:"tags,omitempty"`
+	Domains        []OrgDomain            `bson:"domains,omitempty"`
+	Quota          OrgQuota               `bson:"quota"`
+	MembersCount   int                    `bson:"members_count"`
+	PendingInvites int                    `bson:"pending_invites"`
+	TeamsCount     int                    `bson:"teams_count"`
+	ArchivedAt     *time.Time             `bson:"archived_at,omitempty"`
+	// OrgMembers is never read by the storage, only ReadOrg and ReadAllOrgs
+	// fill it for the deprecated organization_members of their responses.
+	OrgMembers []OrgMember `bson:"-"`
+	Teams      []Team      `bson:"-"`
+}
+
+type TeamMember struct {
+	UserInfo `bson:",inline"`
+	Role     string `bson:"role"`
+}
+
+type Team struct {
+	TeamId      string       `bson:"-"`
+	OrgId       string       `bson:"organization_id"`
+	Name        string       `bson:"name"`
+	Description string       `bson:"description"`
+	Members     []TeamMember `bson:"team_members"`
+}
+
+// ProjectMember overrides the organization access level of a member for a
+// single project.
+type ProjectMember struct {
+	UserInfo    `bson:",inline"`
+	AccessLevel string `bson:"access_level"`
+}
+
+type Project struct {
+	ProjectId   string          `bson:"-"`
+	OrgId       string          `bson:"organization_id"`
+	Name        string          `bson:"name"`
+	Description string          `bson:"description"`
+	Members     []ProjectMember `bson:"project_members"`
+}
+
+type JoinRequest struct {
+	RequestId string `bson:"-"`
+	OrgId     string `bson:"organization_id"`
+	UserInfo  `bson:",inline"`
+	Status    string    `bson:"status"`
+	CreatedAt time.Time `bson:"created_at"`
+}
+
+type Invitation struct {
+	OrgId       string `bson:"organization_id"`
+	Email       string `bson:"email"`
+	AccessLevel string `bson:"access_level"`
+}
+
+type InviteLink struct {
+	Code        string    `bson:"code"`
+	OrgId       string    `bson:"organization_id"`
+	AccessLevel string    `bson:"access_level"`
+	MaxUses     int       `bson:"max_uses"`
+	Uses        int       `bson:"uses"`
+	ExpiresAt   time.Time `bson:"expires_at"`
+}
+
+type ImportResult struct {
+	Email       string
+	AccessLevel string
+	Status      string
+	Reason      string
 }
 
 type Token struct {
@@ -67,10 +231,104 @@ type UpdateOrgReq struct {
 	Description string `json:"description" binding:"required"`
 }
 
+type UpdateVisibilityReq struct {
+	Visibility string `json:"visibility" binding:"required,oneof=private discoverable"`
+}
+
+type AddOrgDomainReq struct {
+	Domain      string `json:"domain" binding:"required"`
+	AccessLevel string `json:"access_level" binding:"omitempty,oneof=user"`
+}
+
+type ChangeEmailReq struct {
+	Email    string `json:"new_email" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type VerifyEmailReq struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type UpdateSettingsReq struct {
+	Timezone          string `json:"timezone"`
+	Locale            string `json:"locale"`
+	LogoURL           string `json:"logo_url"`
+	DefaultMemberRole string `json:"default_member_role" binding:"omitempty,oneof=admin user"`
+	Visibility        string `json:"visibility" binding:"omitempty,oneof=private discoverable"`
+}
+
+type MetadataFieldReq struct {
+	Key  string `json:"key" binding:"required"`
+	Type string `json:"type" binding:"required,oneof=string number boolean"`
+}
+
+type UpdateQuotaReq struct {
+	MaxMembers        int `json:"max_members" binding:"min=0"`
+	MaxPendingInvites int `json:"max_pending_invites" binding:"min=0"`
+	MaxTeams          int `json:"max_teams" binding:"min=0"`
+}
+
+type MoveOrgReq struct {
+	ParentId string `json:"parent_id"`
+}
+
 type InviteReq struct {
 	Email string `json:"user_email" binding:"required"`
 }
 
+type CreateTeamReq struct {
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description"`
+}
+
+type UpdateTeamReq struct {
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description"`
+}
+
+type TeamMemberReq struct {
+	Email string `json:"user_email" binding:"required"`
+	Role  string `json:"role" binding:"required,oneof=lead member"`
+}
+
+type ReadMembersReq struct {
+	Query  string `form:"q"`
+	Role   string `form:"role" binding:"omitempty,oneof=admin user"`
+	Sort   string `form:"sort" binding:"omitempty,oneof=name -name email -email"`
+	Limit  int    `form:"limit" binding:"omitempty,min=1,max=200"`
+	Cursor string `form:"cursor"`
+}
+
+type AddOrgTagsReq struct {
+	Tags []string `json:"tags" binding:"required,min=1"`
+}
+
+type CreateProjectReq struct {
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description"`
+}
+
+type UpdateProjectReq struct {
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description"`
+}
+
+type ProjectMemberReq struct {
+	Email       string `json:"user_email" binding:"required"`
+	AccessLevel string `json:"access_level" binding:"required,oneof=admin user"`
+}
+
+type ImportMemberReq struct {
+	Email       string `json:"user_email"`
+	AccessLevel string `json:"access_level"`
+}
+
+type CreateInviteLinkReq struct {
+	AccessLevel string    `json:"access_level" binding:"required,oneof=admin user"`
+	MaxUses     int       `json:"max_uses" binding:"required,min=1"`
+	ExpiresAt   time.Time `json:"expires_at" binding:"required"`
+}
+
 // ===================== Consumer Response Structures ===================== //
 
 type MessageResp struct {
@@ -89,6 +347,7 @@ type CreateOrgResp struct {
 
 type UpdateOrgResp struct {
 	OrgId       string `json:"organization_id"`
+	Slug        string `json:"slug"`
 	Name        string `json:"name" binding:"required"`
 	Description string `json:"description" binding:"required"`
 }
@@ -99,9 +358,125 @@ type OrgMemberResp struct {
 	AccessLevel string `json:"access_level"`
 }
 
+type InviteLinkResp struct {
+	Code        string    `json:"code"`
+	OrgId       string    `json:"organization_id"`
+	AccessLevel string    `json:"access_level"`
+	MaxUses     int       `json:"max_uses"`
+	Uses        int       `json:"uses"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+type ImportResultResp struct {
+	Row         int    `json:"row"`
+	Email       string `json:"user_email"`
+	AccessLevel string `json:"access_level"`
+	Status      string `json:"status"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+type TeamMemberResp struct {
+	Name  string `json:"name"`
+	Email string `json:"user_email"`
+	Role  string `json:"role"`
+}
+
+type TeamResp struct {
+	TeamId      string           `json:"team_id"`
+	Name        string           `json:"name"`
+	Description string           `json:"description"`
+	Members     []TeamMemberResp `json:"team_members"`
+}
+
+type ProjectMemberResp struct {
+	Name        string `json:"name"`
+	Email       string `json:"user_email"`
+	AccessLevel string `json:"access_level"`
+}
+
+type ProjectResp struct {
+	ProjectId   string              `json:"project_id"`
+	Name        string              `json:"name"`
+	Description string              `json:"description"`
+	Members     []ProjectMemberResp `json:"project_members"`
+}
+
+type OrgDomainResp struct {
+	Domain             string `json:"domain"`
+	AccessLevel        string `json:"access_level"`
+	Verified           bool   `json:"verified"`
+	VerificationRecord string `json:"verification_record"`
+}
+
+type OrgUsageResp struct {
+	Members           int `json:"members"`
+	MaxMembers        int `json:"max_members"`
+	PendingInvites    int `json:"pending_invites"`
+	MaxPendingInvites int `json:"max_pending_invites"`
+	Teams             int `json:"teams"`
+	MaxTeams          int `json:"max_teams"`
+}
+
+type DiscoverOrgResp struct {
+	OrgId        string `json:"organization_id"`
+	Name         string `json:"name"`
+	Description  string `json:"description"`
+	MembersCount int    `json:"members_count"`
+}
+
+type CreateJoinRequestResp struct {
+	RequestId string `json:"request_id"`
+}
+
+type JoinRequestResp struct {
+	RequestId string    `json:"request_id"`
+	OrgId     string    `json:"organization_id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"user_email"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type OrgSettingsResp struct {
+	Timezone          string `json:"timezone"`
+	Locale            string `json:"locale"`
+	LogoURL           string `json:"logo_url"`
+	DefaultMemberRole string `json:"default_member_role"`
+	Visibility        string `json:"visibility"`
+}
+
+type MetadataFieldResp struct {
+	Key  string `json:"key"`
+	Type string `json:"type"`
+}
+
 type ReadOrgResp struct {
-	OrgId       string          `json:"organization_id"`
-	Name        string          `json:"name"`
-	Description string          `json:"description"`
-	OrgMembers  []OrgMemberResp `json:"organization_members"`
+	OrgId          string                 `json:"organization_id"`
+	Slug           string                 `json:"slug"`
+	LogoURLs       map[string]string      `json:"logo_urls,omitempty"`
+	Tags           []string               `json:"tags"`
+	ParentId       string                 `json:"parent_id,omitempty"`
+	Name           string                 `json:"name"`
+	Description    string                 `json:"description"`
+	Visibility     string                 `json:"visibility"`
+	Settings       OrgSettingsResp        `json:"settings"`
+	MetadataFields []MetadataFieldResp    `json:"metadata_fields"`
+	Metadata       map[string]interface{} `json:"metadata"`
+	Teams          []TeamResp             `json:"teams"`
+	// Deprecated: OrgMembers is only returned by the endpoints reading one or
+	// all organizations, page through GET /organization/{id}/members instead.
+	OrgMembers []OrgMemberResp `json:"organization_members,omitempty"`
+}
+
+type OrgLogoResp struct {
+	LogoURLs map[string]string `json:"logo_urls"`
+}
+
+type OrgTagsResp struct {
+	Tags []string `json:"tags"`
+}
+
+type MembersPageResp struct {
+	OrgMembers []OrgMemberResp `json:"organization_members"`
+	NextCursor string          `json:"next_cursor,omitempty"`
 }